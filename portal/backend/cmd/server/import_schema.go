@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfmodule"
+)
+
+// importSchema implements "server import-schema <module-dir>". It prints
+// the generated config schema and input mapping as JSON, ready to send to
+// the resource type API, and reports untranslated validations on stderr.
+func importSchema(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: server import-schema <module-dir>")
+		return 2
+	}
+
+	variables, err := tfmodule.Load(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read module variables: %v\n", err)
+		return 1
+	}
+
+	result := tfmodule.Generate(variables)
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write schema: %v\n", err)
+		return 1
+	}
+	return 0
+}
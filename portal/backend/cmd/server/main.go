@@ -6,10 +6,17 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/artifacts"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/config"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/cost"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/events"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/expiry"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/handlers"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/notify"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/provisioner"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tokens"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -17,8 +24,25 @@ import (
 )
 
 func main() {
+	// Subcommands that do not need the server
+	if len(os.Args) > 1 && os.Args[1] == "import-schema" {
+		os.Exit(importSchema(os.Args[2:]))
+	}
+
 	// Load configuration
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.DefaultJWTSecret() {
+		log.Println("JWT_SECRET is not set; Google sign-in is disabled")
+	}
+
+	// Load token signing keys
+	keys, err := loadKeySet(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
 
 	// Connect to database
 	db, err := repository.Connect(cfg)
@@ -36,6 +60,44 @@ func main() {
 		log.Printf("Warning: Failed to seed data: %v", err)
 	}
 
+	// Provisioning engine
+	workspaces := &provisioner.Workspaces{
+		BaseDir:     cfg.TerraformWorkDir,
+		RepoDir:     cfg.TerraformRepoDir,
+		StateBucket: cfg.TerraformStateBucket,
+	}
+	runner := newRunner(cfg)
+	pipeline := &provisioner.Pipeline{
+		Workspaces: workspaces,
+		Runner:     runner,
+		Backups:    newBackuper(cfg, runner),
+	}
+	if cfg.InfracostBinary != "" {
+		pipeline.Costs = cost.NewInfracost(cfg.InfracostBinary)
+	}
+	engine := provisioner.NewEngine(db, pipeline)
+
+	// Live request progress
+	broker := events.NewBroker(events.DefaultBufferSize, events.DefaultMaxRequests)
+	engine.Events = broker
+
+	// Run logs and plan artifacts
+	store := artifacts.NewLocal(cfg.ArtifactDir)
+	engine.Artifacts = store
+
+	// Resource expiry
+	scheduler := expiry.NewScheduler(db, engine, notify.New(cfg.NotifyWebhookURL))
+	scheduler.Interval = cfg.ExpiryCheckInterval
+	scheduler.Warning = cfg.ExpiryWarning
+	scheduler.BaseURL = cfg.FrontendURL
+	scheduler.Start()
+
+	// Cost estimation
+	estimator, err := newEstimator(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load cost catalog: %v", err)
+	}
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		ErrorHandler: customErrorHandler,
@@ -49,7 +111,7 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     cfg.FrontendURL,
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Last-Event-ID",
 		AllowCredentials: true,
 	}))
 
@@ -62,11 +124,19 @@ func main() {
 	})
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db, cfg)
+	authHandler := handlers.NewAuthHandler(db, cfg, keys)
 	envHandler := handlers.NewEnvironmentHandler(db)
-	rtHandler := handlers.NewResourceTypeHandler(db)
-	reqHandler := handlers.NewRequestHandler(db)
-	approvalHandler := handlers.NewApprovalHandler(db)
+	rtHandler := handlers.NewResourceTypeHandler(db, workspaces)
+	blueprintHandler := handlers.NewBlueprintHandler(db)
+	reqHandler := handlers.NewRequestHandler(db, engine, estimator, broker)
+	approvalHandler := handlers.NewApprovalHandler(db, engine, broker)
+	runHandler := handlers.NewRunHandler(db, store)
+	userHandler := handlers.NewUserHandler(db)
+	teamHandler := handlers.NewTeamHandler(db)
+	resourceHandler := handlers.NewResourceHandler(db)
+
+	// Public keys for verifying portal tokens
+	app.Get("/.well-known/jwks.json", authHandler.JWKS)
 
 	// API routes
 	api := app.Group("/api")
@@ -75,9 +145,10 @@ func main() {
 	auth := api.Group("/auth")
 	auth.Get("/google", authHandler.GoogleLogin)
 	auth.Get("/google/callback", authHandler.GoogleCallback)
+	auth.Post("/refresh", authHandler.Refresh)
 
 	// Protected routes
-	protected := api.Group("", middleware.AuthMiddleware(cfg.JWTSecret))
+	protected := api.Group("", middleware.AuthMiddleware(keys.Keyfunc, cfg.JWTIssuer, keys.Algorithms(), repository.NewSessionStore(db)))
 
 	// Auth protected
 	protected.Get("/auth/me", authHandler.Me)
@@ -85,20 +156,64 @@ func main() {
 
 	// Environments
 	protected.Get("/environments", envHandler.List)
+	protected.Post("/environments", middleware.RequireRole("admin"), envHandler.Create)
 	protected.Get("/environments/:id", envHandler.Get)
+	protected.Put("/environments/:id", middleware.RequireRole("admin"), envHandler.Update)
+	protected.Delete("/environments/:id", middleware.RequireRole("admin"), envHandler.Delete)
+	protected.Get("/environments/:id/approval-policy", envHandler.GetApprovalPolicy)
+	protected.Put("/environments/:id/approval-policy", middleware.RequireRole("admin"), envHandler.UpdateApprovalPolicy)
 
 	// Resource Types
 	protected.Get("/resource-types", rtHandler.List)
+	protected.Post("/resource-types", middleware.RequireRole("admin"), rtHandler.Create)
+	protected.Post("/resource-types/import-schema", middleware.RequireRole("admin"), rtHandler.ImportSchema)
 	protected.Get("/resource-types/:id", rtHandler.Get)
+	protected.Put("/resource-types/:id", middleware.RequireRole("admin"), rtHandler.Update)
 	protected.Get("/resource-types/:id/schema", rtHandler.GetSchema)
+	protected.Get("/resource-types/:id/versions", rtHandler.ListVersions)
+
+	// Blueprints
+	protected.Get("/blueprints", blueprintHandler.List)
+	protected.Post("/blueprints", middleware.RequireRole("admin"), blueprintHandler.Create)
+	protected.Get("/blueprints/:id", blueprintHandler.Get)
+	protected.Put("/blueprints/:id", middleware.RequireRole("admin"), blueprintHandler.Update)
+	protected.Delete("/blueprints/:id", middleware.RequireRole("admin"), blueprintHandler.Delete)
 
 	// Requests
 	protected.Get("/requests", reqHandler.List)
 	protected.Post("/requests", reqHandler.Create)
 	protected.Get("/requests/:id", reqHandler.Get)
+	protected.Get("/requests/:id/events", reqHandler.Events)
+	protected.Get("/requests/:id/runs", runHandler.List)
+	protected.Get("/requests/:id/runs/:runId/log", runHandler.Log)
+	protected.Get("/requests/:id/runs/:runId/artifacts/:name", runHandler.Artifact)
 	protected.Put("/requests/:id", reqHandler.Update)
 	protected.Delete("/requests/:id", reqHandler.Delete)
 	protected.Post("/requests/:id/submit", reqHandler.Submit)
+	protected.Post("/requests/:id/promote", reqHandler.Promote)
+	protected.Post("/requests/:id/withdraw", reqHandler.Withdraw)
+	protected.Post("/requests/:id/validate", reqHandler.Validate)
+	protected.Get("/requests/:id/rendered", reqHandler.Rendered)
+	protected.Get("/requests/:id/changes", reqHandler.Changes)
+	protected.Post("/requests/:id/infracost", reqHandler.ImportInfracost)
+	protected.Get("/requests/:id/budget", reqHandler.Budget)
+	protected.Post("/requests/:id/provision", middleware.RequireRole("admin"), reqHandler.Provision)
+
+	// Resource inventory
+	protected.Get("/resources", resourceHandler.List)
+	protected.Get("/resources/:id", resourceHandler.Get)
+	protected.Post("/resources/:id/extend", resourceHandler.Extend)
+
+	// Teams and budgets
+	protected.Get("/teams", teamHandler.List)
+	protected.Post("/teams", middleware.RequireRole("admin"), teamHandler.Create)
+	protected.Get("/teams/:id", teamHandler.Get)
+	protected.Put("/teams/:id", middleware.RequireRole("admin"), teamHandler.Update)
+	protected.Post("/teams/:id/members", middleware.RequireRole("admin"), teamHandler.AddMember)
+	protected.Delete("/teams/:id/members/:userId", middleware.RequireRole("admin"), teamHandler.RemoveMember)
+	protected.Get("/teams/:id/budgets", teamHandler.ListBudgets)
+	protected.Put("/teams/:id/budgets/:environmentId", middleware.RequireRole("admin"), teamHandler.SetBudget)
+	protected.Delete("/teams/:id/budgets/:environmentId", middleware.RequireRole("admin"), teamHandler.DeleteBudget)
 
 	// Approvals (approver/admin only)
 	approvals := protected.Group("/approvals", middleware.RequireRole("approver", "admin"))
@@ -107,6 +222,16 @@ func main() {
 	approvals.Post("/:id/approve", approvalHandler.Approve)
 	approvals.Post("/:id/reject", approvalHandler.Reject)
 
+	// User administration (admin only)
+	admin := protected.Group("/admin", middleware.RequireRole("admin"))
+	admin.Get("/users", userHandler.List)
+	admin.Post("/users", userHandler.Create)
+	admin.Get("/users/:id", userHandler.Get)
+	admin.Put("/users/:id/role", userHandler.UpdateRole)
+	admin.Put("/users/:id/groups", userHandler.UpdateGroups)
+	admin.Post("/users/:id/deactivate", userHandler.Deactivate)
+	admin.Post("/users/:id/reactivate", userHandler.Reactivate)
+
 	// Graceful shutdown
 	go func() {
 		if err := app.Listen(":" + cfg.Port); err != nil {
@@ -122,12 +247,55 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	broker.Close() // ends event streams so the server can drain
 	if err := app.Shutdown(); err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
+	scheduler.Stop()
+	engine.Wait()
 	log.Println("Server stopped")
 }
 
+func loadKeySet(cfg *config.Config) (*tokens.KeySet, error) {
+	if cfg.JWTSigningKeyFile == "" {
+		log.Println("JWT_SIGNING_KEY_FILE not set, using an ephemeral signing key")
+		return tokens.GenerateKeySet()
+	}
+	return tokens.LoadKeySet(cfg.JWTSigningKeyFile, cfg.JWTVerificationKeys)
+}
+
+func newRunner(cfg *config.Config) provisioner.Runner {
+	if cfg.ProvisionerRunner == "fake" {
+		log.Println("Using fake Terraform runner, no infrastructure will be changed")
+		return provisioner.NewFakeRunner()
+	}
+	return provisioner.NewTerraformRunner(cfg.TerraformBinary)
+}
+
+// newBackuper takes final backups with gcloud, unless the runner is the
+// fake one, which pretends to take them too
+func newBackuper(cfg *config.Config, runner provisioner.Runner) provisioner.Backuper {
+	if b, ok := runner.(provisioner.Backuper); ok {
+		return b
+	}
+	return provisioner.NewGCloudBackup(cfg.GCloudBinary, cfg.BackupBucket)
+}
+
+func newEstimator(cfg *config.Config) (cost.Estimator, error) {
+	if cfg.CostCatalogFile == "" {
+		catalog, err := cost.DefaultCatalog()
+		if err != nil {
+			return nil, err
+		}
+		return cost.NewCatalogEstimator(catalog), nil
+	}
+	catalog, err := cost.LoadCatalog(cfg.CostCatalogFile)
+	if err != nil {
+		return nil, err
+	}
+	return cost.NewCatalogEstimator(catalog), nil
+}
+
 func customErrorHandler(c *fiber.Ctx, err error) error {
 	code := fiber.StatusInternalServerError
 	message := "Internal Server Error"
@@ -0,0 +1,91 @@
+// Package artifacts stores files produced by Terraform runs, such as saved
+// plans and their JSON rendering, behind a small interface so they can
+// move to object storage without touching the provisioner.
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when an artifact does not exist
+var ErrNotFound = errors.New("artifact not found")
+
+// Store keeps artifacts under slash-separated keys such as
+// "runs/<run id>/tfplan"
+type Store interface {
+	// Put stores everything read from r under key and returns its size
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+
+	// Open returns the artifact stored under key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// Local stores artifacts in a directory on the local filesystem
+type Local struct {
+	Dir string
+}
+
+// NewLocal creates a store rooted at dir
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+// Put writes the artifact to a temporary file and moves it into place, so
+// readers never see a partial artifact
+func (s *Local) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Open opens a stored artifact
+func (s *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// path maps a key to a file under Dir, refusing keys that would escape it
+func (s *Local) path(key string) (string, error) {
+	clean := path.Clean(key)
+	if key == "" || path.IsAbs(key) || clean != key || clean == "." ||
+		clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid artifact key %q", key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,63 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPutOpen(t *testing.T) {
+	s := NewLocal(t.TempDir())
+	ctx := context.Background()
+
+	n, err := s.Put(ctx, "runs/1/tfplan.json", strings.NewReader(`{"format_version":"1.2"}`))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if n != 24 {
+		t.Errorf("expected 24 bytes written, got %d", n)
+	}
+
+	// Overwriting replaces the artifact
+	if _, err := s.Put(ctx, "runs/1/tfplan.json", strings.NewReader(`{}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	r, err := s.Open(ctx, "runs/1/tfplan.json")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer r.Close()
+	data, _ := io.ReadAll(r)
+	if string(data) != `{}` {
+		t.Errorf("unexpected content %q", data)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(s.Dir, "runs", "1"))
+	if len(entries) != 1 {
+		t.Errorf("expected no leftover temporary files, got %d entries", len(entries))
+	}
+}
+
+func TestLocalOpenMissing(t *testing.T) {
+	s := NewLocal(t.TempDir())
+	if _, err := s.Open(context.Background(), "runs/missing/tfplan"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestLocalRejectsInvalidKeys(t *testing.T) {
+	s := NewLocal(t.TempDir())
+	for _, key := range []string{"", ".", "..", "../etc/passwd", "/etc/passwd", "runs/../../x", "runs//tfplan"} {
+		if _, err := s.Put(context.Background(), key, strings.NewReader("x")); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+		if _, err := s.Open(context.Background(), key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("expected Open(%q) to reject the key, got %v", key, err)
+		}
+	}
+}
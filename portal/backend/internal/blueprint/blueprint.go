@@ -0,0 +1,151 @@
+// Package blueprint orders the components of a blueprint and wires the
+// outputs of one component into the inputs of another. A wiring maps a
+// module variable to an output of another component:
+//
+//	{
+//	  "vpc_id": "network.vpc_id",
+//	  "subnet_id": "network.private_subnet_id"
+//	}
+//
+// A component is provisioned after every component it reads from and every
+// component in its DependsOn.
+package blueprint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,30}$`)
+
+// Component is the part of a blueprint component that decides ordering
+// and wiring
+type Component struct {
+	Name      string
+	Wiring    models.JSON // module variable -> "<component>.<output>"
+	DependsOn []string
+}
+
+// ParseReference splits a wiring reference into component and output
+func ParseReference(ref string) (component, output string, err error) {
+	component, output, ok := strings.Cut(ref, ".")
+	if !ok || component == "" || output == "" {
+		return "", "", fmt.Errorf("reference %q must be <component>.<output>", ref)
+	}
+	return component, output, nil
+}
+
+// Dependencies returns the components c reads outputs from or explicitly
+// depends on, sorted and without duplicates
+func Dependencies(c Component) ([]string, error) {
+	seen := map[string]bool{}
+	for _, name := range c.DependsOn {
+		seen[name] = true
+	}
+	for variable, raw := range c.Wiring {
+		ref, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("wiring for %s.%s must be a string", c.Name, variable)
+		}
+		component, _, err := ParseReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("wiring for %s.%s: %w", c.Name, variable, err)
+		}
+		seen[component] = true
+	}
+
+	deps := make([]string, 0, len(seen))
+	for name := range seen {
+		deps = append(deps, name)
+	}
+	sort.Strings(deps)
+	return deps, nil
+}
+
+// Order returns the component names in the order they must be provisioned.
+// Components that do not depend on each other keep their given order. It
+// fails on unknown or duplicate names, self references and cycles.
+func Order(components []Component) ([]string, error) {
+	index := map[string]int{}
+	for i, c := range components {
+		if !namePattern.MatchString(c.Name) {
+			return nil, fmt.Errorf("component name %q must start with a letter and contain only lowercase letters, digits, hyphens and underscores", c.Name)
+		}
+		if _, ok := index[c.Name]; ok {
+			return nil, fmt.Errorf("component %s is defined more than once", c.Name)
+		}
+		index[c.Name] = i
+	}
+
+	pending := make([]int, len(components)) // unprovisioned dependencies
+	dependents := make([][]int, len(components))
+	for i, c := range components {
+		deps, err := Dependencies(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range deps {
+			if dep == c.Name {
+				return nil, fmt.Errorf("component %s depends on itself", c.Name)
+			}
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("component %s depends on unknown component %s", c.Name, dep)
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	order := make([]string, 0, len(components))
+	done := make([]bool, len(components))
+	for len(order) < len(components) {
+		// Take the first component whose dependencies are all in place
+		next := -1
+		for i := range components {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for i, c := range components {
+				if !done[i] {
+					cycle = append(cycle, c.Name)
+				}
+			}
+			return nil, fmt.Errorf("components %s have circular dependencies", strings.Join(cycle, ", "))
+		}
+
+		done[next] = true
+		order = append(order, components[next].Name)
+		for _, i := range dependents[next] {
+			pending[i]--
+		}
+	}
+	return order, nil
+}
+
+// Resolve returns the module variables a wiring sets, given the outputs of
+// the components that have already been provisioned
+func Resolve(wiring models.JSON, outputs map[string]models.JSON) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(wiring))
+	for variable, raw := range wiring {
+		ref, _ := raw.(string)
+		component, output, err := ParseReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("wiring for %s: %w", variable, err)
+		}
+		value, ok := outputs[component][output]
+		if !ok {
+			return nil, fmt.Errorf("component %s has no output %s for %s", component, output, variable)
+		}
+		values[variable] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,83 @@
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+func serviceStack() []Component {
+	return []Component{
+		{Name: "gke", Wiring: models.JSON{"vpc_id": "network.vpc_id", "subnet_id": "network.private_subnet_id"}},
+		{Name: "cloudsql", Wiring: models.JSON{"vpc_id": "network.vpc_id"}},
+		{Name: "network"},
+		{Name: "redis", Wiring: models.JSON{"vpc_id": "network.vpc_id"}, DependsOn: []string{"cloudsql"}},
+	}
+}
+
+func TestOrder(t *testing.T) {
+	order, err := Order(serviceStack())
+	if err != nil {
+		t.Fatalf("Order failed: %v", err)
+	}
+
+	expected := []string{"network", "gke", "cloudsql", "redis"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+}
+
+func TestOrderErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []Component
+		message    string
+	}{
+		{"duplicate", []Component{{Name: "network"}, {Name: "network"}}, "more than once"},
+		{"invalid name", []Component{{Name: "Network"}}, "must start with a letter"},
+		{"unknown", []Component{{Name: "gke", Wiring: models.JSON{"vpc_id": "vpc.vpc_id"}}}, "unknown component vpc"},
+		{"self", []Component{{Name: "gke", DependsOn: []string{"gke"}}}, "depends on itself"},
+		{"bad reference", []Component{{Name: "gke", Wiring: models.JSON{"vpc_id": "network"}}}, "<component>.<output>"},
+		{"not a string", []Component{{Name: "gke", Wiring: models.JSON{"vpc_id": 1.0}}}, "must be a string"},
+		{"cycle", []Component{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", Wiring: models.JSON{"x": "a.x"}},
+			{Name: "c"},
+		}, "a, b have circular dependencies"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Order(tt.components)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected %q in %q", tt.message, err.Error())
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	outputs := map[string]models.JSON{
+		"network": {"vpc_id": "projects/acme/global/networks/dev-1b4e28ba", "private_subnet_id": "subnet-1"},
+	}
+
+	values, err := Resolve(models.JSON{"vpc_id": "network.vpc_id", "subnet_id": "network.private_subnet_id"}, outputs)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if values["vpc_id"] != "projects/acme/global/networks/dev-1b4e28ba" || values["subnet_id"] != "subnet-1" {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	if _, err := Resolve(models.JSON{"vpc_id": "network.vpc_name"}, outputs); err == nil {
+		t.Error("expected an error for a missing output")
+	}
+	if _, err := Resolve(models.JSON{"vpc_id": "cloudsql.vpc_id"}, outputs); err == nil {
+		t.Error("expected an error for a component that has not been provisioned")
+	}
+}
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"errors"
 	"os"
+	"strings"
+	"time"
 )
 
+// defaultJWTSecret is only acceptable outside production
+const defaultJWTSecret = "your-secret-key-change-in-production"
+
 // Config holds all configuration for the application
 type Config struct {
 	// Server
@@ -18,14 +24,41 @@ type Config struct {
 	GoogleClientSecret string
 	GoogleRedirectURL  string
 
+	// Sign-in restrictions
+	AllowedDomains []string
+	InviteOnly     bool
+
 	// JWT
-	JWTSecret string
+	JWTSecret           string // the OAuth state cookie key is derived from it
+	JWTIssuer           string
+	JWTSigningKeyFile   string
+	JWTVerificationKeys []string
+	AccessTokenTTL      time.Duration
+	RefreshTokenTTL     time.Duration
 
 	// GCP
 	GCPProjectID         string
 	GCPRegion            string
 	TerraformStateBucket string
 
+	// Provisioning
+	TerraformBinary   string
+	TerraformRepoDir  string
+	TerraformWorkDir  string
+	ProvisionerRunner string
+	GCloudBinary      string // used for final backups before a destroy
+	BackupBucket      string // GCS bucket final backups are exported to
+	ArtifactDir       string // where plan files and other run artifacts are kept
+
+	// Cost estimation
+	CostCatalogFile string // built-in catalog when empty
+	InfracostBinary string // plans are not priced when empty
+
+	// Resource expiry
+	ExpiryCheckInterval time.Duration
+	ExpiryWarning       time.Duration // how long before expiry owners are warned
+	NotifyWebhookURL    string        // notifications are only logged when empty
+
 	// Frontend
 	FrontendURL string
 }
@@ -39,10 +72,29 @@ func Load() *Config {
 		GoogleClientID:       getEnv("GOOGLE_CLIENT_ID", ""),
 		GoogleClientSecret:   getEnv("GOOGLE_CLIENT_SECRET", ""),
 		GoogleRedirectURL:    getEnv("GOOGLE_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback"),
-		JWTSecret:            getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		AllowedDomains:       getList("ALLOWED_DOMAINS"),
+		InviteOnly:           getEnv("INVITE_ONLY", "false") == "true",
+		JWTSecret:            getEnv("JWT_SECRET", defaultJWTSecret),
+		JWTIssuer:            getEnv("JWT_ISSUER", "infra-portal"),
+		JWTSigningKeyFile:    getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTVerificationKeys:  getList("JWT_VERIFICATION_KEY_FILES"),
+		AccessTokenTTL:       getDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:      getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
 		GCPProjectID:         getEnv("GCP_PROJECT_ID", ""),
 		GCPRegion:            getEnv("GCP_REGION", "asia-southeast1"),
 		TerraformStateBucket: getEnv("TERRAFORM_STATE_BUCKET", ""),
+		TerraformBinary:      getEnv("TERRAFORM_BINARY", "terraform"),
+		TerraformRepoDir:     getEnv("TERRAFORM_REPO_DIR", "../.."),
+		TerraformWorkDir:     getEnv("TERRAFORM_WORK_DIR", "/tmp/infra-portal/workspaces"),
+		ProvisionerRunner:    getEnv("PROVISIONER_RUNNER", "terraform"),
+		GCloudBinary:         getEnv("GCLOUD_BINARY", "gcloud"),
+		BackupBucket:         getEnv("BACKUP_BUCKET", ""),
+		ArtifactDir:          getEnv("ARTIFACT_DIR", "/tmp/infra-portal/artifacts"),
+		CostCatalogFile:      getEnv("COST_CATALOG_FILE", ""),
+		InfracostBinary:      getEnv("INFRACOST_BINARY", ""),
+		ExpiryCheckInterval:  getDuration("EXPIRY_CHECK_INTERVAL", 5*time.Minute),
+		ExpiryWarning:        getDuration("EXPIRY_WARNING", 24*time.Hour),
+		NotifyWebhookURL:     getEnv("NOTIFY_WEBHOOK_URL", ""),
 		FrontendURL:          getEnv("FRONTEND_URL", "http://localhost:3000"),
 	}
 }
@@ -54,6 +106,45 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(getEnv(key, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// Validate rejects insecure settings in production
+func (c *Config) Validate() error {
+	if c.Env != "production" {
+		return nil
+	}
+	if c.DefaultJWTSecret() {
+		return errors.New("JWT_SECRET must be set in production")
+	}
+	if c.JWTSigningKeyFile == "" {
+		return errors.New("JWT_SIGNING_KEY_FILE must be set in production")
+	}
+	return nil
+}
+
+// DefaultJWTSecret reports whether JWT_SECRET is unset or still the
+// published default
+func (c *Config) DefaultJWTSecret() bool {
+	return c.JWTSecret == defaultJWTSecret || c.JWTSecret == ""
+}
+
 // GetDSN returns the database connection string
 func (c *Config) GetDSN() string {
 	return c.DatabaseURL
@@ -0,0 +1,282 @@
+package cost
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+//go:embed catalog.json
+var defaultCatalog []byte
+
+// Catalog is a local price list. Prices are hourly unless noted and are
+// multiplied by the region factor.
+type Catalog struct {
+	Currency      string             `json:"currency"`
+	HoursPerMonth float64            `json:"hours_per_month"`
+	Regions       map[string]float64 `json:"regions"`
+
+	Compute struct {
+		MachineTypes map[string]float64 `json:"machine_types"`
+		SpotDiscount float64            `json:"spot_discount"` // fraction taken off for spot VMs
+		Disks        map[string]float64 `json:"disks"`         // per GB-month
+	} `json:"compute"`
+
+	GKE struct {
+		ClusterHourly float64 `json:"cluster_hourly"`
+	} `json:"gke"`
+
+	CloudSQL struct {
+		Tiers          map[string]float64 `json:"tiers"` // shared-core tiers
+		VCPUHourly     float64            `json:"vcpu_hourly"`
+		MemoryGBHourly float64            `json:"memory_gb_hourly"`
+		Storage        map[string]float64 `json:"storage"` // per GB-month
+		HAMultiplier   float64            `json:"ha_multiplier"`
+	} `json:"cloudsql"`
+
+	Memorystore struct {
+		GBHourly map[string]float64 `json:"gb_hourly"` // by tier
+	} `json:"memorystore"`
+}
+
+// DefaultCatalog returns the catalog built into the binary
+func DefaultCatalog() (*Catalog, error) {
+	return ParseCatalog(defaultCatalog)
+}
+
+// LoadCatalog reads a catalog from a JSON file
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCatalog(data)
+}
+
+// ParseCatalog decodes a JSON catalog
+func ParseCatalog(data []byte) (*Catalog, error) {
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cost catalog: %w", err)
+	}
+	if c.Currency == "" {
+		c.Currency = "USD"
+	}
+	if c.HoursPerMonth == 0 {
+		c.HoursPerMonth = 730
+	}
+	if c.CloudSQL.HAMultiplier == 0 {
+		c.CloudSQL.HAMultiplier = 1
+	}
+	return &c, nil
+}
+
+// CatalogEstimator prices the platform's modules from a Catalog
+type CatalogEstimator struct {
+	catalog *Catalog
+}
+
+// NewCatalogEstimator creates an estimator backed by catalog
+func NewCatalogEstimator(catalog *Catalog) *CatalogEstimator {
+	return &CatalogEstimator{catalog: catalog}
+}
+
+// Estimate implements Estimator. Modules without pricing rules only carry
+// the resource type's base cost.
+func (e *CatalogEstimator) Estimate(_ context.Context, input Input) (*Estimate, error) {
+	c := e.catalog
+	est := &Estimate{Currency: c.Currency, Items: []LineItem{}}
+
+	regionFactor, ok := c.Regions[input.Region]
+	if !ok {
+		regionFactor = 1
+		if input.Region != "" {
+			est.Warnings = append(est.Warnings, fmt.Sprintf("no price adjustment for region %s", input.Region))
+		}
+	}
+
+	if input.BaseCost > 0 {
+		est.add("Base cost", 1, "month", input.BaseCost)
+	}
+
+	p := pricer{catalog: c, estimate: est, vars: input.Variables, region: regionFactor}
+	switch input.Module() {
+	case "gke":
+		p.gke()
+	case "cloudsql":
+		p.cloudSQL()
+	case "memorystore":
+		p.memorystore()
+	}
+	return est, nil
+}
+
+// pricer adds the line items of one module to an estimate
+type pricer struct {
+	catalog  *Catalog
+	estimate *Estimate
+	vars     map[string]interface{}
+	region   float64
+}
+
+// hourly adds an item billed by the hour
+func (p *pricer) hourly(name string, quantity float64, unit string, price float64) {
+	p.estimate.add(name, quantity, unit, round4(price*p.catalog.HoursPerMonth*p.region))
+}
+
+// monthly adds an item billed by the month
+func (p *pricer) monthly(name string, quantity float64, unit string, price float64) {
+	p.estimate.add(name, quantity, unit, round4(price*p.region))
+}
+
+func (p *pricer) warn(format string, args ...interface{}) {
+	p.estimate.Warnings = append(p.estimate.Warnings, fmt.Sprintf(format, args...))
+}
+
+// gke prices the cluster fee and node pools. Like the other pricers it falls
+// back to the module's defaults for variables that are not set.
+func (p *pricer) gke() {
+	c := p.catalog
+	p.hourly("GKE cluster management", 1, "cluster", c.GKE.ClusterHourly)
+
+	p.nodePool("Primary pool",
+		p.str("primary_pool_machine_type", "e2-standard-4"),
+		p.num("primary_pool_min_node_count", 1),
+		p.num("primary_pool_disk_size_gb", 100),
+		p.str("primary_pool_disk_type", "pd-standard"),
+		p.boolean("primary_pool_spot", false) || p.boolean("primary_pool_preemptible", false))
+
+	if p.boolean("create_spot_pool", false) {
+		p.nodePool("Spot pool",
+			p.str("spot_pool_machine_type", "e2-standard-2"),
+			p.num("spot_pool_min_node_count", 0),
+			p.num("spot_pool_disk_size_gb", 50),
+			"pd-standard",
+			true)
+	}
+}
+
+// nodePool prices the minimum size of a node pool, which is what the
+// cluster runs when idle
+func (p *pricer) nodePool(name, machineType string, nodes, diskGB float64, diskType string, spot bool) {
+	if nodes <= 0 {
+		return
+	}
+	c := p.catalog
+
+	price, ok := c.Compute.MachineTypes[machineType]
+	if !ok {
+		p.warn("no price for machine type %s", machineType)
+	} else {
+		unit := machineType
+		if spot {
+			price *= 1 - c.Compute.SpotDiscount
+			unit += " (spot)"
+		}
+		p.hourly(name+" nodes", nodes, unit, price)
+	}
+
+	diskPrice, ok := c.Compute.Disks[diskType]
+	if !ok {
+		p.warn("no price for disk type %s", diskType)
+		return
+	}
+	p.monthly(name+" boot disks", nodes*diskGB, "GB "+diskType, diskPrice)
+}
+
+func (p *pricer) cloudSQL() {
+	c := p.catalog
+	tier := p.str("tier", "db-custom-2-4096")
+	ha := p.str("availability_type", "ZONAL") == "REGIONAL"
+
+	multiplier := 1.0
+	suffix := ""
+	if ha {
+		multiplier = c.CloudSQL.HAMultiplier
+		suffix = " (regional)"
+	}
+
+	price, ok := p.sqlTier(tier)
+	if ok {
+		p.hourly("Cloud SQL instance"+suffix, 1, tier, price*multiplier)
+	}
+
+	diskType := p.str("disk_type", "PD_SSD")
+	if storage, ok := c.CloudSQL.Storage[diskType]; ok {
+		p.monthly("Cloud SQL storage"+suffix, p.num("disk_size", 20), "GB "+diskType, storage*multiplier)
+	} else {
+		p.warn("no price for Cloud SQL disk type %s", diskType)
+	}
+
+	if p.boolean("create_read_replica", false) {
+		replicaTier := p.str("replica_tier", "")
+		if replicaTier == "" {
+			replicaTier = tier
+		}
+		if price, ok := p.sqlTier(replicaTier); ok {
+			p.hourly("Cloud SQL read replica", 1, replicaTier, price)
+		}
+	}
+}
+
+var customTier = regexp.MustCompile(`^db-custom-(\d+)-(\d+)$`)
+
+// sqlTier returns the hourly price of a Cloud SQL tier. Custom tiers are
+// priced by vCPU and memory.
+func (p *pricer) sqlTier(tier string) (float64, bool) {
+	c := p.catalog
+	if price, ok := c.CloudSQL.Tiers[tier]; ok {
+		return price, true
+	}
+	if m := customTier.FindStringSubmatch(tier); m != nil {
+		vcpus, _ := strconv.ParseFloat(m[1], 64)
+		memoryMB, _ := strconv.ParseFloat(m[2], 64)
+		return vcpus*c.CloudSQL.VCPUHourly + memoryMB/1024*c.CloudSQL.MemoryGBHourly, true
+	}
+	p.warn("no price for Cloud SQL tier %s", tier)
+	return 0, false
+}
+
+func (p *pricer) memorystore() {
+	tier := p.str("tier", "BASIC")
+	price, ok := p.catalog.Memorystore.GBHourly[tier]
+	if !ok {
+		p.warn("no price for Memorystore tier %s", tier)
+		return
+	}
+	p.hourly("Memorystore Redis", p.num("memory_size_gb", 1), "GB "+tier, price)
+}
+
+func (p *pricer) str(name, def string) string {
+	if s, ok := p.vars[name].(string); ok && s != "" {
+		return s
+	}
+	return def
+}
+
+func (p *pricer) num(name string, def float64) float64 {
+	switch v := p.vars[name].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	}
+	return def
+}
+
+func (p *pricer) boolean(name string, def bool) bool {
+	if b, ok := p.vars[name].(bool); ok {
+		return b
+	}
+	return def
+}
+
+// round4 keeps unit prices readable without losing precision in totals
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
@@ -0,0 +1,71 @@
+// Package cost estimates the monthly cost of provisioning a request
+package cost
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+)
+
+// Estimator computes the monthly cost of a module with the given variables
+type Estimator interface {
+	Estimate(ctx context.Context, input Input) (*Estimate, error)
+}
+
+// Input describes what is being provisioned
+type Input struct {
+	// ModulePath is the resource type's Terraform module, relative to the
+	// repository root
+	ModulePath string
+
+	// Region is the target environment's region
+	Region string
+
+	// BaseCost is the resource type's flat monthly cost
+	BaseCost float64
+
+	// Variables are the rendered Terraform variables
+	Variables map[string]interface{}
+}
+
+// Module returns the module name, e.g. "cloudsql" for terraform/modules/cloudsql
+func (in Input) Module() string {
+	return filepath.Base(filepath.Clean(in.ModulePath))
+}
+
+// Estimate is an itemised monthly cost
+type Estimate struct {
+	Currency string     `json:"currency"`
+	Monthly  float64    `json:"monthly"`
+	Items    []LineItem `json:"items"`
+
+	// Warnings lists parts of the configuration that could not be priced
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// LineItem is one priced component of an estimate
+type LineItem struct {
+	Name      string  `json:"name"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit"`
+	UnitPrice float64 `json:"unit_price"`
+	Monthly   float64 `json:"monthly"`
+}
+
+// add appends a line item and updates the total
+func (e *Estimate) add(name string, quantity float64, unit string, unitPrice float64) {
+	monthly := round(quantity * unitPrice)
+	e.Items = append(e.Items, LineItem{
+		Name:      name,
+		Quantity:  quantity,
+		Unit:      unit,
+		UnitPrice: unitPrice,
+		Monthly:   monthly,
+	})
+	e.Monthly = round(e.Monthly + monthly)
+}
+
+// round rounds to cents
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,230 @@
+package cost
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testCatalog = `{
+  "currency": "USD",
+  "hours_per_month": 100,
+  "regions": {"us-central1": 1, "asia-southeast2": 2},
+  "compute": {
+    "machine_types": {"e2-standard-2": 0.1, "e2-standard-4": 0.2},
+    "spot_discount": 0.5,
+    "disks": {"pd-standard": 0.04}
+  },
+  "gke": {"cluster_hourly": 0.1},
+  "cloudsql": {
+    "tiers": {"db-f1-micro": 0.01},
+    "vcpu_hourly": 0.04,
+    "memory_gb_hourly": 0.01,
+    "storage": {"PD_SSD": 0.2},
+    "ha_multiplier": 2
+  },
+  "memorystore": {"gb_hourly": {"BASIC": 0.05, "STANDARD_HA": 0.06}}
+}`
+
+func testEstimator(t *testing.T) *CatalogEstimator {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(path, []byte(testCatalog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := LoadCatalog(path)
+	if err != nil {
+		t.Fatalf("LoadCatalog failed: %v", err)
+	}
+	return NewCatalogEstimator(catalog)
+}
+
+func TestEstimateGKE(t *testing.T) {
+	est, err := testEstimator(t).Estimate(context.Background(), Input{
+		ModulePath: "terraform/modules/gke",
+		Region:     "us-central1",
+		Variables: map[string]interface{}{
+			"primary_pool_machine_type":   "e2-standard-2",
+			"primary_pool_min_node_count": 3.0,
+			"create_spot_pool":            true,
+			"spot_pool_min_node_count":    2.0,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cluster 10 + nodes 3*10 + disks 300GB*0.04 + spot nodes 2*5 + spot disks 100GB*0.04
+	expected := map[string]float64{
+		"GKE cluster management":  10,
+		"Primary pool nodes":      30,
+		"Primary pool boot disks": 12,
+		"Spot pool nodes":         10,
+		"Spot pool boot disks":    4,
+	}
+	if len(est.Items) != len(expected) {
+		t.Fatalf("expected %d items, got %+v", len(expected), est.Items)
+	}
+	for _, item := range est.Items {
+		if item.Monthly != expected[item.Name] {
+			t.Errorf("%s: expected %v, got %v", item.Name, expected[item.Name], item.Monthly)
+		}
+	}
+	if est.Monthly != 66 {
+		t.Errorf("expected total 66, got %v", est.Monthly)
+	}
+}
+
+func TestEstimateCloudSQL(t *testing.T) {
+	e := testEstimator(t)
+
+	zonal, err := e.Estimate(context.Background(), Input{
+		ModulePath: "terraform/modules/cloudsql",
+		Region:     "us-central1",
+		Variables:  map[string]interface{}{"tier": "db-custom-2-4096", "disk_size": 10.0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// instance (2*0.04 + 4*0.01) * 100 = 12, storage 10*0.2 = 2
+	if zonal.Monthly != 14 {
+		t.Errorf("expected 14, got %v (%+v)", zonal.Monthly, zonal.Items)
+	}
+
+	regional, err := e.Estimate(context.Background(), Input{
+		ModulePath: "terraform/modules/cloudsql",
+		Region:     "asia-southeast2",
+		Variables:  map[string]interface{}{"tier": "db-custom-2-4096", "disk_size": 10.0, "availability_type": "REGIONAL"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HA doubles, the region doubles again
+	if regional.Monthly != 56 {
+		t.Errorf("expected 56, got %v (%+v)", regional.Monthly, regional.Items)
+	}
+}
+
+func TestEstimateMemorystore(t *testing.T) {
+	est, err := testEstimator(t).Estimate(context.Background(), Input{
+		ModulePath: "terraform/modules/memorystore",
+		Region:     "us-central1",
+		BaseCost:   5,
+		Variables:  map[string]interface{}{"tier": "STANDARD_HA", "memory_size_gb": 4.0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(est.Items) != 2 || est.Items[0].Name != "Base cost" {
+		t.Fatalf("expected base cost and redis items, got %+v", est.Items)
+	}
+	if est.Monthly != 29 {
+		t.Errorf("expected 29, got %v", est.Monthly)
+	}
+}
+
+func TestEstimateWarnings(t *testing.T) {
+	est, err := testEstimator(t).Estimate(context.Background(), Input{
+		ModulePath: "terraform/modules/gke",
+		Region:     "mars-north1",
+		Variables:  map[string]interface{}{"primary_pool_machine_type": "a2-ultragpu-8g"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(est.Warnings) != 2 {
+		t.Errorf("expected warnings for the region and machine type, got %v", est.Warnings)
+	}
+
+	unknown, err := testEstimator(t).Estimate(context.Background(), Input{ModulePath: "terraform/modules/vpc", BaseCost: 12.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unknown.Monthly != 12.5 || len(unknown.Warnings) != 0 {
+		t.Errorf("modules without pricing rules should only carry the base cost, got %+v", unknown)
+	}
+}
+
+func TestDefaultCatalog(t *testing.T) {
+	catalog, err := DefaultCatalog()
+	if err != nil {
+		t.Fatalf("embedded catalog should parse: %v", err)
+	}
+	est, err := NewCatalogEstimator(catalog).Estimate(context.Background(), Input{
+		ModulePath: "terraform/modules/cloudsql",
+		Region:     "asia-southeast1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Monthly <= 0 || len(est.Warnings) != 0 {
+		t.Errorf("module defaults should be priced, got %+v", est)
+	}
+}
+
+const infracostSample = `{
+  "version": "0.2",
+  "currency": "USD",
+  "projects": [{
+    "name": "portal/dev/cloudsql",
+    "pastBreakdown": {
+      "resources": [
+        {"name": "google_sql_database_instance.main", "resourceType": "google_sql_database_instance", "monthlyCost": "50.5"}
+      ],
+      "totalMonthlyCost": "50.5"
+    },
+    "breakdown": {
+      "resources": [
+        {"name": "google_sql_database_instance.main", "resourceType": "google_sql_database_instance", "monthlyCost": "101"},
+        {"name": "google_secret_manager_secret.db_password", "resourceType": "google_secret_manager_secret", "monthlyCost": null}
+      ],
+      "totalMonthlyCost": "101"
+    }
+  }],
+  "totalMonthlyCost": "101",
+  "pastTotalMonthlyCost": "50.5",
+  "diffTotalMonthlyCost": "50.5"
+}`
+
+func TestParseInfracost(t *testing.T) {
+	pc, err := ParseInfracost([]byte(infracostSample))
+	if err != nil {
+		t.Fatalf("ParseInfracost failed: %v", err)
+	}
+	if pc.Monthly != 101 || pc.PastMonthly != 50.5 || pc.Delta != 50.5 {
+		t.Errorf("unexpected totals: %+v", pc)
+	}
+	if len(pc.Resources) != 2 {
+		t.Fatalf("expected 2 resources, got %+v", pc.Resources)
+	}
+
+	secret, instance := pc.Resources[0], pc.Resources[1]
+	if !secret.UsageBased || secret.Monthly != 0 {
+		t.Errorf("resources without a price should be marked usage based: %+v", secret)
+	}
+	if instance.PastMonthly != 50.5 || instance.Delta != 50.5 {
+		t.Errorf("unexpected instance cost: %+v", instance)
+	}
+}
+
+func TestParseInfracostWithoutTotals(t *testing.T) {
+	data := `{"projects": [{"breakdown": {"resources": [
+		{"name": "google_redis_instance.cache", "resourceType": "google_redis_instance", "monthlyCost": "35.77"}
+	]}}]}`
+	pc, err := ParseInfracost([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.Currency != "USD" || pc.Monthly != 35.77 || pc.Delta != 35.77 {
+		t.Errorf("totals should be summed from resources: %+v", pc)
+	}
+}
+
+func TestParseInfracostInvalid(t *testing.T) {
+	for _, data := range []string{`not json`, `{"projects": []}`, `{"projects": [{"breakdown": {"resources": [{"name": "x", "monthlyCost": "lots"}]}}]}`} {
+		if _, err := ParseInfracost([]byte(data)); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+}
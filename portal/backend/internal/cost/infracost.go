@@ -0,0 +1,195 @@
+package cost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sources of a plan cost
+const (
+	PlanCostSourcePlan   = "plan"   // produced by the portal after terraform plan
+	PlanCostSourceUpload = "upload" // uploaded, e.g. from CI
+)
+
+// PlanCost is the price of a Terraform plan as reported by Infracost
+type PlanCost struct {
+	Source      string         `json:"source"`
+	Currency    string         `json:"currency"`
+	Monthly     float64        `json:"monthly"`      // after the plan is applied
+	PastMonthly float64        `json:"past_monthly"` // before
+	Delta       float64        `json:"delta"`
+	Resources   []ResourceCost `json:"resources"`
+}
+
+// ResourceCost is the monthly cost of a single Terraform resource
+type ResourceCost struct {
+	Name         string  `json:"name"`
+	ResourceType string  `json:"resource_type"`
+	Monthly      float64 `json:"monthly"`
+	PastMonthly  float64 `json:"past_monthly"`
+	Delta        float64 `json:"delta"`
+
+	// UsageBased is set when Infracost could not price the resource
+	// without usage data
+	UsageBased bool `json:"usage_based,omitempty"`
+}
+
+// infracostOutput is the part of `infracost breakdown --format json` the
+// portal reads. Amounts are decimal strings and null when unknown.
+type infracostOutput struct {
+	Currency             string  `json:"currency"`
+	TotalMonthlyCost     *string `json:"totalMonthlyCost"`
+	PastTotalMonthlyCost *string `json:"pastTotalMonthlyCost"`
+	DiffTotalMonthlyCost *string `json:"diffTotalMonthlyCost"`
+	Projects             []struct {
+		Breakdown     *infracostBreakdown `json:"breakdown"`
+		PastBreakdown *infracostBreakdown `json:"pastBreakdown"`
+	} `json:"projects"`
+}
+
+type infracostBreakdown struct {
+	Resources []struct {
+		Name         string  `json:"name"`
+		ResourceType string  `json:"resourceType"`
+		MonthlyCost  *string `json:"monthlyCost"`
+	} `json:"resources"`
+}
+
+// ParseInfracost reads Infracost JSON output (`infracost breakdown` or
+// `infracost diff` with --format json)
+func ParseInfracost(data []byte) (*PlanCost, error) {
+	var out infracostOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("invalid infracost output: %w", err)
+	}
+	if len(out.Projects) == 0 {
+		return nil, fmt.Errorf("infracost output has no projects")
+	}
+
+	pc := &PlanCost{Currency: out.Currency, Resources: []ResourceCost{}}
+	if pc.Currency == "" {
+		pc.Currency = "USD"
+	}
+
+	byName := map[string]*ResourceCost{}
+	resource := func(name, resourceType string) *ResourceCost {
+		r, ok := byName[name]
+		if !ok {
+			r = &ResourceCost{Name: name, ResourceType: resourceType}
+			byName[name] = r
+		}
+		return r
+	}
+
+	for _, project := range out.Projects {
+		if project.Breakdown != nil {
+			for _, res := range project.Breakdown.Resources {
+				r := resource(res.Name, res.ResourceType)
+				monthly, known, err := amount(res.MonthlyCost)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", res.Name, err)
+				}
+				r.Monthly += monthly
+				r.UsageBased = r.UsageBased || !known
+			}
+		}
+		if project.PastBreakdown != nil {
+			for _, res := range project.PastBreakdown.Resources {
+				r := resource(res.Name, res.ResourceType)
+				monthly, _, err := amount(res.MonthlyCost)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", res.Name, err)
+				}
+				r.PastMonthly += monthly
+			}
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := byName[name]
+		r.Monthly = round(r.Monthly)
+		r.PastMonthly = round(r.PastMonthly)
+		r.Delta = round(r.Monthly - r.PastMonthly)
+		pc.Monthly += r.Monthly
+		pc.PastMonthly += r.PastMonthly
+		pc.Resources = append(pc.Resources, *r)
+	}
+
+	// Prefer the totals Infracost computed itself
+	var err error
+	if pc.Monthly, err = total(out.TotalMonthlyCost, pc.Monthly); err != nil {
+		return nil, err
+	}
+	if pc.PastMonthly, err = total(out.PastTotalMonthlyCost, pc.PastMonthly); err != nil {
+		return nil, err
+	}
+	if pc.Delta, err = total(out.DiffTotalMonthlyCost, pc.Monthly-pc.PastMonthly); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// amount parses a decimal string. Null amounts are unknown and count as 0.
+func amount(s *string) (float64, bool, error) {
+	if s == nil || *s == "" {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseFloat(*s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid amount %q", *s)
+	}
+	return v, true, nil
+}
+
+func total(s *string, fallback float64) (float64, error) {
+	v, known, err := amount(s)
+	if err != nil {
+		return 0, err
+	}
+	if !known {
+		return round(fallback), nil
+	}
+	return round(v), nil
+}
+
+// Infracost runs the infracost CLI. It reads INFRACOST_API_KEY from the
+// environment.
+type Infracost struct {
+	Binary string
+	Env    []string
+}
+
+// NewInfracost creates a runner for the given infracost binary
+func NewInfracost(binary string) *Infracost {
+	if binary == "" {
+		binary = "infracost"
+	}
+	return &Infracost{Binary: binary}
+}
+
+// Breakdown prices a plan saved with `terraform show -json` and returns
+// Infracost's JSON output
+func (i *Infracost) Breakdown(ctx context.Context, planJSON string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, i.Binary, "breakdown", "--path", planJSON, "--format", "json", "--no-color")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), i.Env...)
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("infracost breakdown: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
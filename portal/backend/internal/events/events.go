@@ -0,0 +1,320 @@
+// Package events fans request progress out to live subscribers: status
+// transitions, approval decisions and Terraform output as it is produced.
+// Recent events are kept in a ring buffer per request so a client that
+// reconnects with the ID of the last event it saw gets what it missed.
+package events
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types
+const (
+	TypeStatus   = "status"
+	TypeApproval = "approval"
+	TypeLog      = "log"
+	TypeReset    = "reset"
+)
+
+// DefaultBufferSize is how many recent events a broker keeps for replay
+// per request
+const DefaultBufferSize = 1024
+
+// DefaultMaxRequests is how many requests a broker keeps events for. The
+// request that has been quiet the longest is forgotten first.
+const DefaultMaxRequests = 256
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped. Dropped clients reconnect and replay from the buffer.
+const subscriberBuffer = 256
+
+// Event is one update about a request
+type Event struct {
+	ID        uint64
+	RequestID uuid.UUID
+	Type      string
+	Data      interface{}
+}
+
+// StatusChange is the data of a status event. Component is set on the
+// events a blueprint request gets about one of its components.
+type StatusChange struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Component string `json:"component,omitempty"`
+}
+
+// Decision is the data of an approval event
+type Decision struct {
+	ApprovalID uuid.UUID `json:"approval_id"`
+	Stage      int       `json:"stage"`
+	StageName  string    `json:"stage_name,omitempty"`
+	Status     string    `json:"status"`
+	ApproverID uuid.UUID `json:"approver_id"`
+	Comment    string    `json:"comment,omitempty"`
+}
+
+// Log is the data of a log event. Component is set on the output a
+// blueprint request gets from one of its components.
+type Log struct {
+	Line      string `json:"line"`
+	Component string `json:"component,omitempty"`
+}
+
+// Reset is the data of a reset event. It is replayed first to a client
+// whose last event is older than anything still buffered for the request;
+// the client has missed events and should reload the request and its run
+// logs.
+type Reset struct{}
+
+type subscriber struct {
+	ch chan Event
+}
+
+// ring holds the recent events of one request
+type ring struct {
+	requestID uuid.UUID
+	events    []Event
+	next      int // index the next event is written to
+	full      bool
+	since     uint64 // events after this ID are all buffered
+	element   *list.Element
+}
+
+// Broker publishes events to the subscribers of a request. A nil Broker
+// drops everything, so publishers need not check for one.
+type Broker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	size        int
+	maxRequests int
+	rings       map[uuid.UUID]*ring
+	recent      *list.List // rings, most recently published to first
+	subs        map[uuid.UUID]map[*subscriber]struct{}
+	closed      bool
+}
+
+// NewBroker creates a broker that keeps the last size events of each of
+// the last maxRequests requests for replay
+func NewBroker(size, maxRequests int) *Broker {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	if maxRequests <= 0 {
+		maxRequests = DefaultMaxRequests
+	}
+	return &Broker{
+		// IDs start from the clock so that they keep increasing across
+		// restarts and a stale Last-Event-ID never hides new events
+		lastID:      uint64(time.Now().UnixMicro()),
+		size:        size,
+		maxRequests: maxRequests,
+		rings:       map[uuid.UUID]*ring{},
+		recent:      list.New(),
+		subs:        map[uuid.UUID]map[*subscriber]struct{}{},
+	}
+}
+
+// Publish records an event for a request and sends it to its subscribers
+func (b *Broker) Publish(requestID uuid.UUID, eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	r := b.ring(requestID)
+	b.lastID++
+	event := Event{ID: b.lastID, RequestID: requestID, Type: eventType, Data: data}
+	if r.full {
+		r.since = r.events[r.next].ID
+	}
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+
+	for sub := range b.subs[requestID] {
+		select {
+		case sub.ch <- event:
+		default:
+			// Too far behind; the client reconnects and replays
+			b.remove(requestID, sub)
+		}
+	}
+}
+
+// ring returns the buffer of a request, creating it and forgetting the
+// longest quiet request if there are too many. b.mu must be held.
+func (b *Broker) ring(requestID uuid.UUID) *ring {
+	if r, ok := b.rings[requestID]; ok {
+		b.recent.MoveToFront(r.element)
+		return r
+	}
+
+	if b.recent.Len() >= b.maxRequests {
+		oldest := b.recent.Remove(b.recent.Back()).(*ring)
+		delete(b.rings, oldest.requestID)
+	}
+	// Anything published for the request before now is gone
+	r := &ring{requestID: requestID, events: make([]Event, b.size), since: b.lastID}
+	r.element = b.recent.PushFront(r)
+	b.rings[requestID] = r
+	return r
+}
+
+// Subscribe returns the buffered events for a request after lastID and a
+// channel with everything published from then on. If events after lastID
+// are no longer buffered, the replay starts with a reset event. The
+// channel is closed when the subscriber falls too far behind or the broker
+// is closed; call cancel once done. A nil Broker has nothing to replay and
+// its channel never receives.
+func (b *Broker) Subscribe(requestID uuid.UUID, lastID uint64) (replay []Event, ch <-chan Event, cancel func()) {
+	if b == nil {
+		return nil, nil, func() {}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	since := b.lastID
+	if r, ok := b.rings[requestID]; ok {
+		since = r.since
+		start, n := 0, r.next
+		if r.full {
+			start, n = r.next, len(r.events)
+		}
+		for i := 0; i < n; i++ {
+			if event := r.events[(start+i)%len(r.events)]; event.ID > lastID {
+				replay = append(replay, event)
+			}
+		}
+	}
+	if lastID > 0 && lastID < since {
+		// Reconnecting with the reset's ID replays the buffer without
+		// another reset
+		reset := Event{ID: since, RequestID: requestID, Type: TypeReset, Data: Reset{}}
+		replay = append([]Event{reset}, replay...)
+	}
+
+	sub := &subscriber{ch: make(chan Event, subscriberBuffer)}
+	if b.closed {
+		close(sub.ch)
+		return replay, sub.ch, func() {}
+	}
+	if b.subs[requestID] == nil {
+		b.subs[requestID] = map[*subscriber]struct{}{}
+	}
+	b.subs[requestID][sub] = struct{}{}
+
+	return replay, sub.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(requestID, sub)
+	}
+}
+
+// Close ends every subscription. Events published afterwards are dropped.
+func (b *Broker) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for requestID, subs := range b.subs {
+		for sub := range subs {
+			b.remove(requestID, sub)
+		}
+	}
+}
+
+// remove drops a subscriber. b.mu must be held.
+func (b *Broker) remove(requestID uuid.UUID, sub *subscriber) {
+	subs := b.subs[requestID]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.ch)
+	if len(subs) == 0 {
+		delete(b.subs, requestID)
+	}
+}
+
+// Write writes an event in the Server-Sent Events format
+func Write(w io.Writer, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// LineWriter publishes everything written to it as log events, one per
+// line. Call Flush to publish a trailing partial line.
+type LineWriter struct {
+	broker    *Broker
+	requestID uuid.UUID
+	parentID  *uuid.UUID
+	component string
+	partial   strings.Builder
+}
+
+// Lines returns a writer that publishes log lines for a request. Lines of
+// a nil Broker are dropped.
+func (b *Broker) Lines(requestID uuid.UUID) *LineWriter {
+	return &LineWriter{broker: b, requestID: requestID}
+}
+
+// Component also publishes every line to the blueprint request parentID,
+// tagged with the component's name
+func (w *LineWriter) Component(parentID uuid.UUID, name string) *LineWriter {
+	w.parentID = &parentID
+	w.component = name
+	return w
+}
+
+// Write publishes every complete line in p
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.partial.Write(p)
+	buffered := w.partial.String()
+	i := strings.LastIndexByte(buffered, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	for _, line := range strings.Split(buffered[:i], "\n") {
+		w.publish(strings.TrimSuffix(line, "\r"))
+	}
+	w.partial.Reset()
+	w.partial.WriteString(buffered[i+1:])
+	return len(p), nil
+}
+
+// Flush publishes a trailing partial line
+func (w *LineWriter) Flush() {
+	if w.partial.Len() == 0 {
+		return
+	}
+	w.publish(w.partial.String())
+	w.partial.Reset()
+}
+
+func (w *LineWriter) publish(line string) {
+	w.broker.Publish(w.requestID, TypeLog, Log{Line: line})
+	if w.parentID != nil {
+		w.broker.Publish(*w.parentID, TypeLog, Log{Line: line, Component: w.component})
+	}
+}
@@ -0,0 +1,263 @@
+package events
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSubscribeReplay(t *testing.T) {
+	b := NewBroker(8, 0)
+	request, other := uuid.New(), uuid.New()
+
+	b.Publish(request, TypeStatus, StatusChange{From: "approved", To: "planning"})
+	b.Publish(other, TypeStatus, StatusChange{From: "approved", To: "planning"})
+	b.Publish(request, TypeLog, Log{Line: "Initializing"})
+
+	replay, _, cancel := b.Subscribe(request, 0)
+	defer cancel()
+	if len(replay) != 2 || replay[0].Type != TypeStatus || replay[1].Type != TypeLog {
+		t.Fatalf("unexpected replay: %+v", replay)
+	}
+
+	// Reconnecting after the first event only replays the second
+	replay, _, cancel2 := b.Subscribe(request, replay[0].ID)
+	defer cancel2()
+	if len(replay) != 1 || replay[0].Type != TypeLog {
+		t.Fatalf("unexpected replay after reconnect: %+v", replay)
+	}
+}
+
+func TestSubscribeReplayWraps(t *testing.T) {
+	b := NewBroker(3, 0)
+	request := uuid.New()
+	for i := 0; i < 5; i++ {
+		b.Publish(request, TypeLog, Log{Line: string(rune('a' + i))})
+	}
+
+	replay, _, cancel := b.Subscribe(request, 0)
+	defer cancel()
+	if len(replay) != 3 {
+		t.Fatalf("expected the last 3 events, got %d", len(replay))
+	}
+	for i, want := range []string{"c", "d", "e"} {
+		if got := replay[i].Data.(Log).Line; got != want {
+			t.Errorf("replay[%d] = %q, want %q", i, got, want)
+		}
+		if i > 0 && replay[i].ID <= replay[i-1].ID {
+			t.Errorf("replay is not in order: %+v", replay)
+		}
+	}
+}
+
+func TestSubscribeReplayPerRequest(t *testing.T) {
+	b := NewBroker(2, 0)
+	request, busy := uuid.New(), uuid.New()
+
+	b.Publish(request, TypeStatus, StatusChange{From: "approved", To: "planning"})
+	for i := 0; i < 10; i++ {
+		b.Publish(busy, TypeLog, Log{Line: "noise"})
+	}
+
+	replay, _, cancel := b.Subscribe(request, 0)
+	defer cancel()
+	if len(replay) != 1 || replay[0].Type != TypeStatus {
+		t.Fatalf("another request's output should not push this one out: %+v", replay)
+	}
+}
+
+func TestSubscribeReset(t *testing.T) {
+	b := NewBroker(2, 0)
+	request := uuid.New()
+
+	b.Publish(request, TypeLog, Log{Line: "a"})
+	seen := b.rings[request].events[0].ID
+	for _, line := range []string{"b", "c", "d"} {
+		b.Publish(request, TypeLog, Log{Line: line})
+	}
+
+	// "b" fell out of the buffer
+	replay, _, cancel := b.Subscribe(request, seen)
+	defer cancel()
+	if len(replay) != 3 || replay[0].Type != TypeReset {
+		t.Fatalf("expected a reset and the buffered events, got %+v", replay)
+	}
+	if replay[1].Data.(Log).Line != "c" {
+		t.Errorf("expected the replay to continue with c, got %+v", replay[1])
+	}
+
+	// Reconnecting after the reset replays without another one
+	replay, _, cancel2 := b.Subscribe(request, replay[0].ID)
+	defer cancel2()
+	if len(replay) != 2 || replay[0].Type == TypeReset {
+		t.Errorf("unexpected replay after a reset: %+v", replay)
+	}
+
+	// A client that saw everything is not reset
+	replay, _, cancel3 := b.Subscribe(request, replay[1].ID)
+	defer cancel3()
+	if len(replay) != 0 {
+		t.Errorf("expected nothing to replay, got %+v", replay)
+	}
+}
+
+func TestSubscribeForgottenRequest(t *testing.T) {
+	b := NewBroker(8, 2)
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+
+	b.Publish(first, TypeLog, Log{Line: "first"})
+	seen := b.lastID
+	b.Publish(second, TypeLog, Log{Line: "second"})
+	b.Publish(third, TypeLog, Log{Line: "third"})
+	if len(b.rings) != 2 {
+		t.Fatalf("expected 2 buffered requests, got %d", len(b.rings))
+	}
+
+	// Whatever the client missed is gone
+	replay, _, cancel := b.Subscribe(first, seen)
+	defer cancel()
+	if len(replay) != 1 || replay[0].Type != TypeReset {
+		t.Fatalf("expected a reset for the forgotten request, got %+v", replay)
+	}
+
+	// So is what was published before the request is buffered again
+	b.Publish(first, TypeLog, Log{Line: "again"})
+	replay, _, cancel2 := b.Subscribe(first, seen)
+	defer cancel2()
+	if len(replay) != 2 || replay[0].Type != TypeReset || replay[1].Data.(Log).Line != "again" {
+		t.Errorf("expected a reset before the new event, got %+v", replay)
+	}
+}
+
+func TestSubscribeLive(t *testing.T) {
+	b := NewBroker(8, 0)
+	request, other := uuid.New(), uuid.New()
+
+	_, ch, cancel := b.Subscribe(request, 0)
+	b.Publish(other, TypeLog, Log{Line: "not mine"})
+	b.Publish(request, TypeApproval, Decision{Stage: 1, Status: "approved"})
+
+	event := <-ch
+	if event.RequestID != request || event.Type != TypeApproval {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("expected the channel to be closed after cancel")
+	}
+	cancel() // cancelling twice is harmless
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	b := NewBroker(8, 0)
+	request := uuid.New()
+
+	_, ch, cancel := b.Subscribe(request, 0)
+	defer cancel()
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.Publish(request, TypeLog, Log{Line: "line"})
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("expected %d buffered events before the drop, got %d", subscriberBuffer, n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker(8, 0)
+	request := uuid.New()
+
+	_, ch, cancel := b.Subscribe(request, 0)
+	defer cancel()
+	b.Close()
+	if _, ok := <-ch; ok {
+		t.Error("expected the channel to be closed")
+	}
+
+	_, ch, _ = b.Subscribe(request, 0)
+	if _, ok := <-ch; ok {
+		t.Error("expected subscriptions to a closed broker to be closed")
+	}
+}
+
+func TestNilBroker(t *testing.T) {
+	var b *Broker
+	b.Publish(uuid.New(), TypeLog, Log{Line: "dropped"})
+
+	w := b.Lines(uuid.New())
+	w.Write([]byte("dropped\npartial"))
+	w.Flush()
+
+	replay, ch, cancel := b.Subscribe(uuid.New(), 0)
+	if len(replay) != 0 {
+		t.Errorf("expected nothing to replay, got %+v", replay)
+	}
+	select {
+	case event := <-ch:
+		t.Errorf("unexpected event %+v", event)
+	default:
+	}
+	cancel()
+	b.Close()
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, Event{ID: 42, Type: TypeStatus, Data: StatusChange{From: "planned", To: "applying"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id: 42\nevent: status\ndata: {\"from\":\"planned\",\"to\":\"applying\"}\n\n"
+	if buf.String() != want {
+		t.Errorf("Write() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	b := NewBroker(16, 0)
+	request := uuid.New()
+
+	w := b.Lines(request)
+	w.Write([]byte("Initializing the backend...\r\nPlan: 3 to add"))
+	w.Write([]byte(", 0 to change\n\nApply"))
+	w.Flush()
+	w.Flush()
+
+	replay, _, cancel := b.Subscribe(request, 0)
+	defer cancel()
+	want := []string{"Initializing the backend...", "Plan: 3 to add, 0 to change", "", "Apply"}
+	if len(replay) != len(want) {
+		t.Fatalf("expected %d lines, got %+v", len(want), replay)
+	}
+	for i, line := range want {
+		if got := replay[i].Data.(Log).Line; got != line {
+			t.Errorf("line %d = %q, want %q", i, got, line)
+		}
+	}
+}
+
+func TestLineWriterComponent(t *testing.T) {
+	b := NewBroker(16, 0)
+	parent, component := uuid.New(), uuid.New()
+
+	w := b.Lines(component).Component(parent, "database")
+	w.Write([]byte("Apply complete!\n"))
+
+	replay, _, cancel := b.Subscribe(component, 0)
+	defer cancel()
+	if len(replay) != 1 || replay[0].Data.(Log) != (Log{Line: "Apply complete!"}) {
+		t.Errorf("unexpected component output: %+v", replay)
+	}
+
+	replay, _, cancel2 := b.Subscribe(parent, 0)
+	defer cancel2()
+	if len(replay) != 1 || replay[0].Data.(Log) != (Log{Line: "Apply complete!", Component: "database"}) {
+		t.Errorf("expected the output tagged on the blueprint, got %+v", replay)
+	}
+}
@@ -0,0 +1,235 @@
+// Package expiry tears down resources whose TTL has run out. Owners are
+// warned ahead of time and can extend the TTL; once it passes, a
+// decommission request is filed on the owner's behalf.
+package expiry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/notify"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/provisioner"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/workflow"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Actions the scheduler takes on a resource
+const (
+	ActionNone   = ""
+	ActionWarn   = "warn"
+	ActionExpire = "expire"
+)
+
+// Due decides what to do with an active resource at now: warn the owner
+// once when the expiry is within warning, and expire it once it has passed
+// unless a decommission has already been filed
+func Due(resource models.Resource, now time.Time, warning time.Duration) string {
+	if resource.Status != models.ResourceActive || resource.ExpiresAt == nil {
+		return ActionNone
+	}
+	if !resource.ExpiresAt.After(now) {
+		if resource.ExpiryRequestID != nil {
+			return ActionNone
+		}
+		return ActionExpire
+	}
+	if resource.ExpiryWarnedAt == nil && resource.ExpiresAt.Sub(now) <= warning {
+		return ActionWarn
+	}
+	return ActionNone
+}
+
+// Scheduler periodically checks resource expiry
+type Scheduler struct {
+	db       *gorm.DB
+	engine   *provisioner.Engine
+	notifier notify.Notifier
+
+	// Interval between checks and how long before expiry owners are warned
+	Interval time.Duration
+	Warning  time.Duration
+
+	// BaseURL is the frontend URL used for links in notifications
+	BaseURL string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler. Call Start to run it.
+func NewScheduler(db *gorm.DB, engine *provisioner.Engine, notifier notify.Notifier) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		engine:   engine,
+		notifier: notifier,
+		Interval: 5 * time.Minute,
+		Warning:  24 * time.Hour,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start runs a check every Interval until Stop is called
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Check(context.Background(), time.Now()); err != nil {
+				log.Printf("Expiry check failed: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the check loop and waits for a running check to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// Check warns and expires every resource that is due at now
+func (s *Scheduler) Check(ctx context.Context, now time.Time) error {
+	var resources []models.Resource
+	err := s.db.Preload("Owner").
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.ResourceActive, now.Add(s.Warning)).
+		Find(&resources).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range resources {
+		resource := &resources[i]
+		switch Due(*resource, now, s.Warning) {
+		case ActionWarn:
+			if err := s.warn(ctx, resource, now); err != nil {
+				log.Printf("Expiry warning for resource %s failed: %v", resource.ID, err)
+			}
+		case ActionExpire:
+			if err := s.expire(ctx, resource); err != nil {
+				log.Printf("Expiring resource %s failed: %v", resource.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) warn(ctx context.Context, resource *models.Resource, now time.Time) error {
+	left := resource.ExpiresAt.Sub(now).Round(time.Minute)
+	err := s.notify(ctx, resource, notify.Message{
+		Subject: fmt.Sprintf("%s expires in %s", resource.Name, left),
+		Body: fmt.Sprintf("%s will be decommissioned at %s. Extend its TTL to keep it.",
+			resource.Name, resource.ExpiresAt.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Model(resource).Update("expiry_warned_at", now).Error; err != nil {
+		return err
+	}
+	return s.audit("expiry_warning", resource, models.JSON{"expires_at": resource.ExpiresAt})
+}
+
+// expire files a decommission request on the owner's behalf and submits it
+// to the environment's approval policy. Choosing a TTL is consent to the
+// teardown, so deletion protection is turned off.
+func (s *Scheduler) expire(ctx context.Context, resource *models.Resource) error {
+	open, err := repository.OpenResourceRequest(s.db, resource.ID, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	if open != nil {
+		// Try again once the other change has finished
+		return nil
+	}
+
+	var env models.Environment
+	if err := s.db.Preload("ApprovalStages").First(&env, "id = ?", resource.EnvironmentID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	request := models.Request{
+		Title:                     "Expired: " + resource.Name,
+		Description:               fmt.Sprintf("Filed automatically when the TTL of %s ran out.", resource.Name),
+		Kind:                      models.RequestKindDecommission,
+		ResourceID:                &resource.ID,
+		RequesterID:               resource.OwnerID,
+		EnvironmentID:             resource.EnvironmentID,
+		ResourceTypeID:            &resource.ResourceTypeID,
+		TeamID:                    resource.TeamID,
+		Configuration:             resource.Configuration,
+		SchemaVersion:             resource.SchemaVersion,
+		Priority:                  "normal",
+		SubmittedAt:               &now,
+		DisableDeletionProtection: true,
+	}
+
+	stages := workflow.Stages(env)
+	if len(stages) > 0 {
+		request.Status = models.StatusPending
+	} else {
+		request.Status = models.StatusApproved
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&request).Error; err != nil {
+			return err
+		}
+		if request.Status == models.StatusPending {
+			approval := workflow.NewApproval(request, stages[0])
+			if err := tx.Create(&approval).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(resource).Update("expiry_request_id", request.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.audit("expire", resource, models.JSON{"request_id": request.ID}); err != nil {
+		return err
+	}
+	if request.Status == models.StatusApproved {
+		s.engine.Start(request.ID)
+	}
+
+	return s.notify(ctx, resource, notify.Message{
+		Subject: fmt.Sprintf("%s has expired and is being decommissioned", resource.Name),
+		Body:    "Extend its TTL before the decommission is applied to keep it.",
+	})
+}
+
+func (s *Scheduler) notify(ctx context.Context, resource *models.Resource, msg notify.Message) error {
+	if resource.Owner != nil {
+		msg.To = []string{resource.Owner.Email}
+	}
+	if s.BaseURL != "" {
+		msg.Link = fmt.Sprintf("%s/resources/%s", s.BaseURL, resource.ID)
+	}
+	return s.notifier.Notify(ctx, msg)
+}
+
+func (s *Scheduler) audit(action string, resource *models.Resource, values models.JSON) error {
+	entry := models.AuditLog{
+		Action:       action,
+		ResourceType: "resource",
+		ResourceID:   &resource.ID,
+		NewValues:    values,
+	}
+	return s.db.Create(&entry).Error
+}
@@ -0,0 +1,41 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestDue(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		v := now.Add(d)
+		return &v
+	}
+	requestID := uuid.New()
+
+	tests := []struct {
+		name     string
+		resource models.Resource
+		want     string
+	}{
+		{"no ttl", models.Resource{Status: models.ResourceActive}, ActionNone},
+		{"far away", models.Resource{Status: models.ResourceActive, ExpiresAt: at(48 * time.Hour)}, ActionNone},
+		{"within warning", models.Resource{Status: models.ResourceActive, ExpiresAt: at(2 * time.Hour)}, ActionWarn},
+		{"already warned", models.Resource{Status: models.ResourceActive, ExpiresAt: at(2 * time.Hour), ExpiryWarnedAt: at(-time.Hour)}, ActionNone},
+		{"expired", models.Resource{Status: models.ResourceActive, ExpiresAt: at(-time.Minute), ExpiryWarnedAt: at(-time.Hour)}, ActionExpire},
+		{"expired unwarned", models.Resource{Status: models.ResourceActive, ExpiresAt: at(0)}, ActionExpire},
+		{"decommission filed", models.Resource{Status: models.ResourceActive, ExpiresAt: at(-time.Hour), ExpiryRequestID: &requestID}, ActionNone},
+		{"destroyed", models.Resource{Status: models.ResourceDestroyed, ExpiresAt: at(-time.Hour)}, ActionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Due(tt.resource, now, 24*time.Hour); got != tt.want {
+				t.Errorf("Due() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,23 +1,31 @@
 package handlers
 
 import (
+	"errors"
 	"time"
 
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/events"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/provisioner"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/workflow"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ApprovalHandler handles approval endpoints
 type ApprovalHandler struct {
-	db *gorm.DB
+	db     *gorm.DB
+	engine *provisioner.Engine
+	events *events.Broker
 }
 
-// NewApprovalHandler creates a new approval handler
-func NewApprovalHandler(db *gorm.DB) *ApprovalHandler {
-	return &ApprovalHandler{db: db}
+// NewApprovalHandler creates a new approval handler. Decisions are
+// published to broker.
+func NewApprovalHandler(db *gorm.DB, engine *provisioner.Engine, broker *events.Broker) *ApprovalHandler {
+	return &ApprovalHandler{db: db, engine: engine, events: broker}
 }
 
 // ApprovalInput represents input for approve/reject
@@ -30,10 +38,11 @@ func (h *ApprovalHandler) List(c *fiber.Ctx) error {
 	var approvals []models.Approval
 	query := h.db.Preload("Request").Preload("Request.Requester").
 		Preload("Request.Environment").Preload("Request.ResourceType").
+		Preload("Request.Components").Preload("Request.Components.ResourceType").
 		Preload("Approver")
 
 	// Filter by status
-	status := c.Query("status", "pending")
+	status := c.Query("status", models.ApprovalPending)
 	query = query.Where("approvals.status = ?", status)
 
 	if err := query.Order("created_at DESC").Find(&approvals).Error; err != nil {
@@ -52,6 +61,7 @@ func (h *ApprovalHandler) Get(c *fiber.Ctx) error {
 	var approval models.Approval
 	if err := h.db.Preload("Request").Preload("Request.Requester").
 		Preload("Request.Environment").Preload("Request.ResourceType").
+		Preload("Request.Components").Preload("Request.Components.ResourceType").
 		Preload("Approver").
 		First(&approval, "id = ?", id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -62,44 +72,100 @@ func (h *ApprovalHandler) Get(c *fiber.Ctx) error {
 	return c.JSON(approval)
 }
 
-// Approve approves a request
+// Approve records an approval for the current stage of a request. The
+// request only becomes approved once every stage of the environment's
+// approval policy is satisfied.
 func (h *ApprovalHandler) Approve(c *fiber.Ctx) error {
 	id := c.Params("id")
 	userID := middleware.GetUserID(c)
 
-	var approval models.Approval
-	if err := h.db.Preload("Request").First(&approval, "id = ?", id).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Approval not found",
+	d, ferr := h.loadDecision(id, userID)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{
+			"error": ferr.Message,
 		})
 	}
 
-	if approval.Status != "pending" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Approval already processed",
+	// Enforce separation of duties before counting the approval
+	editors, err := h.editorsOf(d.approval.RequestID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check separation of duties",
 		})
 	}
+	if err := workflow.CheckSeparation(*d.approval.Request.Environment, *d.approval.Request, userID, editors); err != nil {
+		return h.blocked(c, userID, d.approval, err)
+	}
 
 	var input ApprovalInput
 	if err := c.BodyParser(&input); err != nil {
 		// Comment is optional
 	}
 
+	approval := d.approval
 	now := time.Now()
-	approval.Status = "approved"
-	approval.ApproverID = userID
+	approval.Status = models.ApprovalApproved
+	approval.ApproverID = &userID
 	approval.ApprovedAt = &now
 	approval.Comment = input.Comment
 
-	if err := h.db.Save(&approval).Error; err != nil {
+	var done bool
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockRequest(tx, approval.RequestID); err != nil {
+			return err
+		}
+
+		// Each approval of a submission comes from a different approver
+		prior, err := submissionApprovals(tx, approval.Request)
+		if err != nil {
+			return err
+		}
+		if err := workflow.CheckDistinctApprovers(prior, d.stage.Position, userID); err != nil {
+			return err
+		}
+		approved := 1
+		for _, p := range prior {
+			if p.Stage == d.stage.Position {
+				approved++
+			}
+		}
+
+		if err := decide(tx, &approval); err != nil {
+			return err
+		}
+		var next models.ApprovalStage
+		next, done = workflow.Advance(d.stages, d.stage, approved)
+		if !done {
+			nextApproval := workflow.NewApproval(*approval.Request, next)
+			return tx.Create(&nextApproval).Error
+		}
+		if err := moveStatus(tx, approval.RequestID, models.StatusPending, models.StatusApproved); err != nil {
+			return err
+		}
+		approval.Request.Status = models.StatusApproved
+		return syncComponents(tx, approval.Request)
+	})
+	var policyErr *workflow.PolicyError
+	if errors.As(err, &policyErr) {
+		return h.blocked(c, userID, d.approval, err)
+	}
+	if errors.Is(err, errApprovalDecided) || errors.Is(err, errStatusChanged) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to save approval",
 		})
 	}
 
-	// Update request status
-	approval.Request.Status = models.StatusApproved
-	h.db.Save(approval.Request)
+	h.publishDecision(approval)
+	if done {
+		h.events.Publish(approval.RequestID, events.TypeStatus,
+			events.StatusChange{From: models.StatusPending, To: models.StatusApproved})
+		h.engine.Start(approval.RequestID)
+	}
 
 	// Create audit log
 	h.createAuditLog(c, userID, "approve", "approval", approval.ID)
@@ -111,21 +177,15 @@ func (h *ApprovalHandler) Approve(c *fiber.Ctx) error {
 	return c.JSON(approval)
 }
 
-// Reject rejects a request
+// Reject rejects a request. A single rejection at any stage ends the request.
 func (h *ApprovalHandler) Reject(c *fiber.Ctx) error {
 	id := c.Params("id")
 	userID := middleware.GetUserID(c)
 
-	var approval models.Approval
-	if err := h.db.Preload("Request").First(&approval, "id = ?", id).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Approval not found",
-		})
-	}
-
-	if approval.Status != "pending" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Approval already processed",
+	d, ferr := h.loadDecision(id, userID)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{
+			"error": ferr.Message,
 		})
 	}
 
@@ -134,21 +194,43 @@ func (h *ApprovalHandler) Reject(c *fiber.Ctx) error {
 		// Comment is optional but recommended for rejection
 	}
 
+	approval := d.approval
 	now := time.Now()
-	approval.Status = "rejected"
-	approval.ApproverID = userID
+	approval.Status = models.ApprovalRejected
+	approval.ApproverID = &userID
 	approval.ApprovedAt = &now
 	approval.Comment = input.Comment
 
-	if err := h.db.Save(&approval).Error; err != nil {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockRequest(tx, approval.RequestID); err != nil {
+			return err
+		}
+		if err := decide(tx, &approval); err != nil {
+			return err
+		}
+		if err := moveStatus(tx, approval.RequestID, models.StatusPending, models.StatusRejected); err != nil {
+			return err
+		}
+		approval.Request.Status = models.StatusRejected
+		if err := syncComponents(tx, approval.Request); err != nil {
+			return err
+		}
+		return closePendingApprovals(tx, approval.RequestID)
+	})
+	if errors.Is(err, errApprovalDecided) || errors.Is(err, errStatusChanged) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to save approval",
 		})
 	}
 
-	// Update request status
-	approval.Request.Status = models.StatusRejected
-	h.db.Save(approval.Request)
+	h.publishDecision(approval)
+	h.events.Publish(approval.RequestID, events.TypeStatus,
+		events.StatusChange{From: models.StatusPending, To: models.StatusRejected})
 
 	// Create audit log
 	h.createAuditLog(c, userID, "reject", "approval", approval.ID)
@@ -160,14 +242,168 @@ func (h *ApprovalHandler) Reject(c *fiber.Ctx) error {
 	return c.JSON(approval)
 }
 
+// decision holds everything needed to approve or reject an approval
+type decision struct {
+	approval models.Approval
+	stages   []models.ApprovalStage
+	stage    models.ApprovalStage
+	user     models.User
+}
+
+// loadDecision loads a pending approval and checks that the user may decide on it
+func (h *ApprovalHandler) loadDecision(id string, userID uuid.UUID) (*decision, *fiber.Error) {
+	var approval models.Approval
+	if err := h.db.Preload("Request").Preload("Request.Environment").
+		Preload("Request.Environment.ApprovalStages").
+		First(&approval, "id = ?", id).Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Approval not found")
+	}
+
+	if approval.Status != models.ApprovalPending {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Approval already processed")
+	}
+
+	if approval.Request.Status != models.StatusPending {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Request is no longer pending approval")
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "User not found")
+	}
+
+	stages := workflow.Stages(*approval.Request.Environment)
+	stage, ok := workflow.StageAt(stages, approval.Stage)
+	if !ok {
+		// The policy changed while the request was pending; treat the
+		// stage as a plain single-approval stage so it can still finish
+		stage = models.ApprovalStage{
+			Position:          approval.Stage,
+			Name:              approval.StageName,
+			RequiredApprovals: 1,
+		}
+	}
+
+	if err := workflow.CanDecide(stage, user); err != nil {
+		return nil, fiber.NewError(fiber.StatusForbidden, "Not eligible to decide on stage "+workflow.Describe(stage))
+	}
+
+	return &decision{approval: approval, stages: stages, stage: stage, user: user}, nil
+}
+
+// errApprovalDecided is returned when someone else decided on an approval
+// first
+var errApprovalDecided = errors.New("Approval was decided by someone else; reload it")
+
+// lockRequest locks a request for the rest of the transaction, so decisions
+// on it are made one at a time
+func lockRequest(tx *gorm.DB, requestID uuid.UUID) error {
+	var request models.Request
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		First(&request, "id = ?", requestID).Error
+}
+
+// decide records a decision on a pending approval, failing with
+// errApprovalDecided if it is no longer pending
+func decide(tx *gorm.DB, approval *models.Approval) error {
+	result := tx.Model(&models.Approval{}).
+		Where("id = ? AND status = ?", approval.ID, models.ApprovalPending).
+		Updates(map[string]interface{}{
+			"status":      approval.Status,
+			"approver_id": approval.ApproverID,
+			"approved_at": approval.ApprovedAt,
+			"comment":     approval.Comment,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errApprovalDecided
+	}
+	return nil
+}
+
+// submissionApprovals returns the approved approvals of a request's current
+// submission. Approvals from before it was withdrawn and resubmitted do not
+// count.
+func submissionApprovals(tx *gorm.DB, request *models.Request) ([]models.Approval, error) {
+	query := tx.Where("request_id = ? AND status = ?", request.ID, models.ApprovalApproved)
+	if request.SubmittedAt != nil {
+		query = query.Where("created_at >= ?", *request.SubmittedAt)
+	}
+	var approvals []models.Approval
+	err := query.Find(&approvals).Error
+	return approvals, err
+}
+
+// blocked responds to an approval the policy does not allow and records
+// the attempt
+func (h *ApprovalHandler) blocked(c *fiber.Ctx, userID uuid.UUID, approval models.Approval, err error) error {
+	var policyErr *workflow.PolicyError
+	errors.As(err, &policyErr)
+
+	recordAudit(h.db, c, models.AuditLog{
+		UserID:       &userID,
+		Action:       "approve_blocked",
+		ResourceType: "approval",
+		ResourceID:   &approval.ID,
+		NewValues: models.JSON{
+			"request_id": approval.RequestID,
+			"reason":     policyErr.Code,
+		},
+	})
+
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":  policyErr.Message,
+		"reason": policyErr.Code,
+	})
+}
+
+// publishDecision tells the request's subscribers about a decision
+func (h *ApprovalHandler) publishDecision(approval models.Approval) {
+	h.events.Publish(approval.RequestID, events.TypeApproval, events.Decision{
+		ApprovalID: approval.ID,
+		Stage:      approval.Stage,
+		StageName:  approval.StageName,
+		Status:     approval.Status,
+		ApproverID: *approval.ApproverID,
+		Comment:    approval.Comment,
+	})
+}
+
 func (h *ApprovalHandler) createAuditLog(c *fiber.Ctx, userID uuid.UUID, action, resourceType string, resourceID uuid.UUID) {
-	log := models.AuditLog{
+	recordAudit(h.db, c, models.AuditLog{
 		UserID:       &userID,
 		Action:       action,
 		ResourceType: resourceType,
 		ResourceID:   &resourceID,
-		IPAddress:    c.IP(),
-		UserAgent:    c.Get("User-Agent"),
+	})
+}
+
+// editorsOf returns everyone who wrote a request's configuration: whoever
+// created or edited it and, for a promoted request, the requesters and
+// editors of every request it was promoted from
+func (h *ApprovalHandler) editorsOf(requestID uuid.UUID) ([]uuid.UUID, error) {
+	var editors []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	for id := &requestID; id != nil && !seen[*id]; {
+		seen[*id] = true
+
+		var request models.Request
+		if err := h.db.Unscoped().Select("id", "requester_id", "promoted_from_id").
+			First(&request, "id = ?", *id).Error; err != nil {
+			return nil, err
+		}
+		var users []uuid.UUID
+		if err := h.db.Model(&models.AuditLog{}).
+			Where("resource_type = ? AND resource_id = ? AND action IN ?", "request", request.ID, []string{"create", "update"}).
+			Where("user_id IS NOT NULL").
+			Distinct().Pluck("user_id", &users).Error; err != nil {
+			return nil, err
+		}
+
+		editors = append(append(editors, request.RequesterID), users...)
+		id = request.PromotedFromID
 	}
-	h.db.Create(&log)
+	return editors, nil
 }
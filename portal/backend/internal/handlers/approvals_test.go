@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/workflow"
+	"github.com/gofiber/fiber/v2"
+)
+
+// pendingRequest submits a request to the fixture's environment, which
+// requires the given approval stages, and returns its first approval
+func pendingRequest(f *fixture, stages ...models.ApprovalStage) (models.Request, models.Approval) {
+	f.t.Helper()
+
+	f.db.Model(&f.environment).Update("requires_approval", true)
+	for i := range stages {
+		stages[i].EnvironmentID = f.environment.ID
+		f.create(&stages[i])
+	}
+
+	request := f.request(f.user("requester", models.RoleUser), models.StatusPending, nil)
+	now := time.Now()
+	f.db.Model(&request).Update("submitted_at", now)
+	request.SubmittedAt = &now
+
+	approval := workflow.NewApproval(request, stages[0])
+	f.create(&approval)
+	return request, approval
+}
+
+func approve(f *fixture, approver models.User, approval models.Approval, engine bool) int {
+	f.t.Helper()
+	h := NewApprovalHandler(f.db, nil, nil)
+	if engine {
+		h = NewApprovalHandler(f.db, f.engine(), nil)
+	}
+	app := f.app(approver, http.MethodPost, "/approvals/:id/approve", h.Approve)
+	return call(f.t, app, http.MethodPost, "/approvals/"+approval.ID.String()+"/approve", ApprovalInput{}, nil)
+}
+
+func TestApproveRequiresDistinctApproversAcrossStages(t *testing.T) {
+	f := newFixture(t)
+	request, first := pendingRequest(f,
+		models.ApprovalStage{Position: 1, Name: "Platform", RequiredApprovals: 1},
+		models.ApprovalStage{Position: 2, Name: "Security", RequiredApprovals: 1},
+	)
+	admin := f.user("admin", models.RoleAdmin)
+	other := f.user("other", models.RoleAdmin)
+
+	if code := approve(f, admin, first, false); code != fiber.StatusOK {
+		t.Fatalf("expected stage 1 approval to succeed, got %d", code)
+	}
+
+	var second models.Approval
+	if err := f.db.First(&second, "request_id = ? AND stage = ? AND status = ?",
+		request.ID, 2, models.ApprovalPending).Error; err != nil {
+		t.Fatalf("expected a pending stage 2 approval: %v", err)
+	}
+
+	if code := approve(f, admin, second, false); code != fiber.StatusForbidden {
+		t.Fatalf("expected the stage 1 approver to be refused at stage 2, got %d", code)
+	}
+	var blocked int64
+	f.db.Model(&models.AuditLog{}).Where("action = ?", "approve_blocked").Count(&blocked)
+	if blocked != 1 {
+		t.Errorf("expected the blocked approval to be audited, got %d entries", blocked)
+	}
+
+	if code := approve(f, other, second, true); code != fiber.StatusOK {
+		t.Fatalf("expected a second approver to finish stage 2, got %d", code)
+	}
+	var after models.Approval
+	f.db.First(&after, "id = ?", second.ID)
+	if after.Status != models.ApprovalApproved || *after.ApproverID != other.ID {
+		t.Errorf("expected stage 2 approved by the second approver, got %s by %v", after.Status, after.ApproverID)
+	}
+}
+
+func TestApproveIgnoresEarlierSubmissions(t *testing.T) {
+	f := newFixture(t)
+	request, approval := pendingRequest(f, models.ApprovalStage{Position: 1, Name: "Platform", RequiredApprovals: 2})
+
+	// Approved before the request was withdrawn and resubmitted
+	earlier := f.user("earlier", models.RoleApprover)
+	approvedAt := request.SubmittedAt.Add(-time.Hour)
+	f.create(&models.Approval{
+		RequestID:  request.ID,
+		Stage:      1,
+		StageName:  "Platform",
+		Status:     models.ApprovalApproved,
+		ApproverID: &earlier.ID,
+		ApprovedAt: &approvedAt,
+		CreatedAt:  approvedAt,
+	})
+
+	if code := approve(f, f.user("approver", models.RoleApprover), approval, false); code != fiber.StatusOK {
+		t.Fatalf("expected approval to succeed, got %d", code)
+	}
+
+	var after models.Request
+	f.db.First(&after, "id = ?", request.ID)
+	if after.Status != models.StatusPending {
+		t.Errorf("expected the request to wait for a second approval, got %s", after.Status)
+	}
+	var pending int64
+	f.db.Model(&models.Approval{}).Where("request_id = ? AND status = ?", request.ID, models.ApprovalPending).Count(&pending)
+	if pending != 1 {
+		t.Errorf("expected one pending approval for the second approver, got %d", pending)
+	}
+}
+
+func TestDecideOnlyOnce(t *testing.T) {
+	f := newFixture(t)
+	_, approval := pendingRequest(f, models.ApprovalStage{Position: 1, Name: "Platform", RequiredApprovals: 1})
+	approver := f.user("approver", models.RoleApprover)
+
+	approval.Status = models.ApprovalApproved
+	approval.ApproverID = &approver.ID
+	if err := decide(f.db, &approval); err != nil {
+		t.Fatalf("decide failed: %v", err)
+	}
+	// A concurrent decision that read the approval while it was pending
+	approval.Status = models.ApprovalRejected
+	if err := decide(f.db, &approval); !errors.Is(err, errApprovalDecided) {
+		t.Errorf("expected errApprovalDecided, got %v", err)
+	}
+}
+
+func TestApproveBlocksEditorsOfPromotedRequests(t *testing.T) {
+	for _, block := range []bool{true, false} {
+		t.Run(fmt.Sprintf("block_editor_approval=%v", block), func(t *testing.T) {
+			f := newFixture(t)
+			_, approval := pendingRequest(f, models.ApprovalStage{Position: 1, Name: "Platform", RequiredApprovals: 1})
+			f.db.Model(&f.environment).Update("block_editor_approval", block)
+
+			author := f.user("author", models.RoleApprover)
+			editor := f.user("editor", models.RoleApprover)
+
+			// The approval's request was promoted from one the author filed
+			// and someone else edited
+			source := f.request(author, models.StatusApplied, nil)
+			f.create(&models.AuditLog{UserID: &editor.ID, Action: "update", ResourceType: "request", ResourceID: &source.ID})
+			f.db.Model(&models.Request{}).Where("id = ?", approval.RequestID).Update("promoted_from_id", source.ID)
+
+			if !block {
+				if code := approve(f, author, approval, true); code != fiber.StatusOK {
+					t.Errorf("expected editors to approve when the option is off, got %d", code)
+				}
+				return
+			}
+			for _, user := range []models.User{author, editor} {
+				if code := approve(f, user, approval, false); code != fiber.StatusForbidden {
+					t.Errorf("expected %s to be blocked from approving the promoted copy, got %d", user.Name, code)
+				}
+			}
+			if code := approve(f, f.user("reviewer", models.RoleApprover), approval, true); code != fiber.StatusOK {
+				t.Errorf("expected an independent approver to approve, got %d", code)
+			}
+		})
+	}
+}
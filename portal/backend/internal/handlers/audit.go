@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// recordAudit writes an audit log entry, filling in the acting user and
+// client details from the current request
+func recordAudit(db *gorm.DB, c *fiber.Ctx, entry models.AuditLog) {
+	if entry.UserID == nil {
+		if userID := middleware.GetUserID(c); userID != uuid.Nil {
+			entry.UserID = &userID
+		}
+	}
+	entry.IPAddress = c.IP()
+	entry.UserAgent = c.Get("User-Agent")
+	db.Create(&entry)
+}
@@ -3,13 +3,16 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/config"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tokens"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -18,15 +21,20 @@ import (
 	"gorm.io/gorm"
 )
 
+// googleUserInfoURL is the Google endpoint that returns the signed-in profile
+const googleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
 // AuthHandler handles authentication endpoints
 type AuthHandler struct {
 	db          *gorm.DB
 	cfg         *config.Config
 	oauthConfig *oauth2.Config
+	userInfoURL string
+	keys        *tokens.KeySet
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(db *gorm.DB, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, keys *tokens.KeySet) *AuthHandler {
 	oauthConfig := &oauth2.Config{
 		ClientID:     cfg.GoogleClientID,
 		ClientSecret: cfg.GoogleClientSecret,
@@ -42,26 +50,95 @@ func NewAuthHandler(db *gorm.DB, cfg *config.Config) *AuthHandler {
 		db:          db,
 		cfg:         cfg,
 		oauthConfig: oauthConfig,
+		userInfoURL: googleUserInfoURL,
+		keys:        keys,
 	}
 }
 
 // GoogleUserInfo represents user info from Google
 type GoogleUserInfo struct {
-	ID      string `json:"id"`
-	Email   string `json:"email"`
-	Name    string `json:"name"`
-	Picture string `json:"picture"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	VerifiedEmail bool   `json:"verified_email"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+	HostedDomain  string `json:"hd"`
 }
 
-// GoogleLogin initiates Google OAuth flow
+// Sign-in rejection reasons recorded in the audit log
+const (
+	rejectUnverifiedEmail = "unverified_email"
+	rejectDomain          = "domain_not_allowed"
+	rejectNotInvited      = "not_invited"
+	rejectDeactivated     = "deactivated"
+)
+
+// GoogleLogin initiates Google OAuth flow. The state and PKCE verifier are
+// bound to the browser with a short-lived signed cookie. Sign-in is refused
+// while JWT_SECRET is unset or the default.
 func (h *AuthHandler) GoogleLogin(c *fiber.Ctx) error {
-	state := uuid.New().String()
-	url := h.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	key, err := oauthStateKey(h.cfg)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Sign-in is disabled until JWT_SECRET is set",
+		})
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	state, err := newOAuthState(verifier, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start login",
+		})
+	}
+
+	value, err := state.encode(key)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start login",
+		})
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/api/auth",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HTTPOnly: true,
+		Secure:   h.cfg.Env == "production",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	url := h.oauthConfig.AuthCodeURL(state.State, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
 	return c.Redirect(url)
 }
 
 // GoogleCallback handles OAuth callback
 func (h *AuthHandler) GoogleCallback(c *fiber.Ctx) error {
+	// The state cookie is single use
+	cookie := c.Cookies(oauthStateCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     oauthStateCookie,
+		Path:     "/api/auth",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   h.cfg.Env == "production",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	key, err := oauthStateKey(h.cfg)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Sign-in is disabled until JWT_SECRET is set",
+		})
+	}
+	state, err := decodeOAuthState(key, cookie, c.Query("state"), time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid login state, please sign in again",
+		})
+	}
+
 	code := c.Query("code")
 	if code == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -69,33 +146,46 @@ func (h *AuthHandler) GoogleCallback(c *fiber.Ctx) error {
 		})
 	}
 
-	// Exchange code for token
-	token, err := h.oauthConfig.Exchange(context.Background(), code)
+	userInfo, err := h.fetchUser(c.Context(), code, state.Verifier)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to exchange token",
+			"error": "Failed to get user info",
 		})
 	}
 
-	// Get user info from Google
-	userInfo, err := h.getUserInfo(token.AccessToken)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to get user info",
-		})
+	if reason := checkSignIn(h.cfg.AllowedDomains, userInfo); reason != "" {
+		return h.rejectSignIn(c, userInfo, reason)
 	}
 
-	// Find or create user
+	// Find the user by Google ID, then fall back to a pre-provisioned
+	// account with the same email that has not signed in yet
 	var user models.User
 	result := h.db.Where("google_id = ?", userInfo.ID).First(&user)
 	if result.Error != nil {
+		result = h.db.Where("LOWER(email) = ? AND google_id IS NULL", strings.ToLower(userInfo.Email)).First(&user)
+		if result.Error == nil {
+			user.GoogleID = &userInfo.ID
+			user.AvatarURL = userInfo.Picture
+			if err := h.db.Save(&user).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to link user",
+				})
+			}
+		}
+	}
+	if result.Error != nil {
+		if h.cfg.InviteOnly {
+			return h.rejectSignIn(c, userInfo, rejectNotInvited)
+		}
+
 		// Create new user
 		user = models.User{
 			Email:     userInfo.Email,
 			Name:      userInfo.Name,
-			GoogleID:  userInfo.ID,
+			GoogleID:  &userInfo.ID,
 			AvatarURL: userInfo.Picture,
-			Role:      "user",
+			Role:      models.RoleUser,
+			IsActive:  true,
 		}
 		if err := h.db.Create(&user).Error; err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -104,8 +194,19 @@ func (h *AuthHandler) GoogleCallback(c *fiber.Ctx) error {
 		}
 	}
 
-	// Generate JWT
-	jwtToken, err := h.generateJWT(user)
+	if !user.IsActive {
+		return h.rejectSignIn(c, userInfo, rejectDeactivated)
+	}
+
+	// Start a session and generate JWT
+	session, err := h.startSession(c, user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start session",
+		})
+	}
+
+	jwtToken, err := h.generateJWT(user, session.ID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
@@ -116,6 +217,46 @@ func (h *AuthHandler) GoogleCallback(c *fiber.Ctx) error {
 	return c.Redirect(h.cfg.FrontendURL + "/auth/callback?token=" + jwtToken)
 }
 
+// checkSignIn returns a rejection reason if the Google account may not sign
+// in. When allowed domains are configured, both the Workspace hosted domain
+// and the verified email must belong to one of them.
+func checkSignIn(allowedDomains []string, info *GoogleUserInfo) string {
+	if !info.VerifiedEmail {
+		return rejectUnverifiedEmail
+	}
+	if len(allowedDomains) == 0 {
+		return ""
+	}
+
+	hd := strings.ToLower(info.HostedDomain)
+	_, emailDomain, _ := strings.Cut(strings.ToLower(info.Email), "@")
+	for _, domain := range allowedDomains {
+		domain = strings.ToLower(domain)
+		if hd == domain && emailDomain == domain {
+			return ""
+		}
+	}
+	return rejectDomain
+}
+
+// rejectSignIn records a refused sign-in and responds with 403
+func (h *AuthHandler) rejectSignIn(c *fiber.Ctx, info *GoogleUserInfo, reason string) error {
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "login_rejected",
+		ResourceType: "user",
+		NewValues: models.JSON{
+			"email":         info.Email,
+			"hosted_domain": info.HostedDomain,
+			"reason":        reason,
+		},
+	})
+
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":  "This Google account is not allowed to sign in",
+		"reason": reason,
+	})
+}
+
 // Me returns current user info
 func (h *AuthHandler) Me(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
@@ -130,21 +271,45 @@ func (h *AuthHandler) Me(c *fiber.Ctx) error {
 	return c.JSON(user)
 }
 
-// Logout handles user logout
+// Logout revokes the current session, which invalidates its refresh token
+// and every access token issued for it
 func (h *AuthHandler) Logout(c *fiber.Ctx) error {
-	// For JWT, logout is handled client-side by removing the token
+	sessionID := middleware.GetSessionID(c)
+
+	now := time.Now()
+	if err := h.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", now).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	h.clearRefreshCookie(c)
+
 	return c.JSON(fiber.Map{
 		"message": "Logged out successfully",
 	})
 }
 
-func (h *AuthHandler) getUserInfo(accessToken string) (*GoogleUserInfo, error) {
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + accessToken)
+// fetchUser exchanges the authorization code (with its PKCE verifier) for a
+// token and loads the Google profile
+func (h *AuthHandler) fetchUser(ctx context.Context, code, verifier string) (*GoogleUserInfo, error) {
+	token, err := h.oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange token: %w", err)
+	}
+
+	resp, err := h.oauthConfig.Client(ctx, token).Get(h.userInfoURL)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user info returned %s", resp.Status)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -158,17 +323,25 @@ func (h *AuthHandler) getUserInfo(accessToken string) (*GoogleUserInfo, error) {
 	return &userInfo, nil
 }
 
-func (h *AuthHandler) generateJWT(user models.User) (string, error) {
+// JWKS publishes the public keys used to verify portal access tokens
+func (h *AuthHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(h.keys.JWKS())
+}
+
+func (h *AuthHandler) generateJWT(user models.User, sessionID uuid.UUID) (string, error) {
 	claims := middleware.Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Role:   user.Role,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			Issuer:    h.cfg.JWTIssuer,
+			Subject:   user.ID.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(h.cfg.AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(h.cfg.JWTSecret))
+	return h.keys.Sign(claims)
 }
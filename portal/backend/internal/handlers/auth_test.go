@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/config"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
+)
+
+// fakeGoogle is a stand-in OAuth server that checks the PKCE verifier
+type fakeGoogle struct {
+	server    *httptest.Server
+	challenge string
+}
+
+func newFakeGoogle(t *testing.T) *fakeGoogle {
+	t.Helper()
+
+	g := &fakeGoogle{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "good-code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access-123","token_type":"Bearer","expires_in":3600}`))
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-123" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GoogleUserInfo{ID: "42", Email: "jane@example.com", VerifiedEmail: true, Name: "Jane"})
+	})
+
+	g.server = httptest.NewServer(mux)
+	t.Cleanup(g.server.Close)
+	return g
+}
+
+func newTestAuthHandler(g *fakeGoogle) *AuthHandler {
+	cfg := &config.Config{JWTSecret: "test-secret", FrontendURL: "http://localhost:3000"}
+	return &AuthHandler{
+		cfg: cfg,
+		oauthConfig: &oauth2.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost:8080/api/auth/google/callback",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  g.server.URL + "/auth",
+				TokenURL: g.server.URL + "/token",
+			},
+		},
+		userInfoURL: g.server.URL + "/userinfo",
+	}
+}
+
+// startLogin runs GoogleLogin and returns the redirect URL and state cookie
+func startLogin(t *testing.T, app *fiber.App) (*url.URL, *http.Cookie) {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/auth/google", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("expected redirect, got %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oauthStateCookie {
+			return location, cookie
+		}
+	}
+	t.Fatal("login did not set a state cookie")
+	return nil, nil
+}
+
+func newAuthApp(h *AuthHandler) *fiber.App {
+	app := fiber.New()
+	app.Get("/api/auth/google", h.GoogleLogin)
+	app.Get("/api/auth/google/callback", h.GoogleCallback)
+	return app
+}
+
+func TestGoogleLoginSetsStateAndChallenge(t *testing.T) {
+	g := newFakeGoogle(t)
+	app := newAuthApp(newTestAuthHandler(g))
+
+	location, cookie := startLogin(t, app)
+	query := location.Query()
+
+	if query.Get("state") == "" {
+		t.Error("redirect should carry a state")
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("redirect should carry an S256 PKCE challenge, got %v", query)
+	}
+	if !cookie.HttpOnly {
+		t.Error("state cookie should be HttpOnly")
+	}
+	if strings.Contains(cookie.Value, query.Get("code_challenge")) {
+		t.Error("state cookie should not contain the challenge in clear text")
+	}
+}
+
+func TestGoogleCallbackRejectsBadState(t *testing.T) {
+	g := newFakeGoogle(t)
+	app := newAuthApp(newTestAuthHandler(g))
+	location, cookie := startLogin(t, app)
+	state := location.Query().Get("state")
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie string
+	}{
+		{"missing cookie", state, ""},
+		{"wrong state", "attacker-state", cookie.Value},
+		{"tampered cookie", state, cookie.Value + "x"},
+		{"missing state", "", cookie.Value},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/auth/google/callback?code=good-code&state="+url.QueryEscape(tt.state), nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: tt.cookie})
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Errorf("expected 400, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestFetchUserSendsVerifier(t *testing.T) {
+	g := newFakeGoogle(t)
+	h := newTestAuthHandler(g)
+	app := newAuthApp(h)
+
+	location, cookie := startLogin(t, app)
+	g.challenge = location.Query().Get("code_challenge")
+
+	key, err := oauthStateKey(h.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := decodeOAuthState(key, cookie.Value, location.Query().Get("state"), time.Now())
+	if err != nil {
+		t.Fatalf("state cookie should verify: %v", err)
+	}
+
+	user, err := h.fetchUser(context.Background(), "good-code", state.Verifier)
+	if err != nil {
+		t.Fatalf("fetchUser failed: %v", err)
+	}
+	if user.Email != "jane@example.com" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	if _, err := h.fetchUser(context.Background(), "good-code", "wrong-verifier"); err == nil {
+		t.Error("exchange should fail with the wrong verifier")
+	}
+}
+
+func TestOAuthStateKeyIsNotTheSecret(t *testing.T) {
+	g := newFakeGoogle(t)
+	h := newTestAuthHandler(g)
+	location, cookie := startLogin(t, newAuthApp(h))
+
+	_, err := decodeOAuthState([]byte(h.cfg.JWTSecret), cookie.Value, location.Query().Get("state"), time.Now())
+	if err != errStateInvalid {
+		t.Errorf("state cookie should not be signed with JWT_SECRET itself, got %v", err)
+	}
+}
+
+func TestGoogleLoginRefusesDefaultSecret(t *testing.T) {
+	g := newFakeGoogle(t)
+	for _, secret := range []string{"", "your-secret-key-change-in-production"} {
+		h := newTestAuthHandler(g)
+		h.cfg.JWTSecret = secret
+		app := newAuthApp(h)
+
+		for _, target := range []string{"/api/auth/google", "/api/auth/google/callback?state=s&code=good-code"} {
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusServiceUnavailable {
+				t.Errorf("%s with secret %q: expected 503, got %d", target, secret, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestOAuthStateExpires(t *testing.T) {
+	secret := []byte("test-secret")
+	issued := time.Now()
+
+	state, err := newOAuthState("verifier", issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := state.encode(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decodeOAuthState(secret, value, state.State, issued.Add(time.Minute)); err != nil {
+		t.Errorf("fresh state should verify: %v", err)
+	}
+	if _, err := decodeOAuthState(secret, value, state.State, issued.Add(oauthStateTTL+time.Minute)); err != errStateExpired {
+		t.Errorf("expected errStateExpired, got %v", err)
+	}
+	if _, err := decodeOAuthState([]byte("other-secret"), value, state.State, issued); err != errStateInvalid {
+		t.Errorf("expected errStateInvalid, got %v", err)
+	}
+}
+
+func TestCheckSignIn(t *testing.T) {
+	allowed := []string{"example.com"}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		info    GoogleUserInfo
+		reason  string
+	}{
+		{"any verified account without restriction", nil, GoogleUserInfo{Email: "a@gmail.com", VerifiedEmail: true}, ""},
+		{"unverified email", nil, GoogleUserInfo{Email: "a@gmail.com"}, rejectUnverifiedEmail},
+		{"workspace member", allowed, GoogleUserInfo{Email: "a@Example.com", VerifiedEmail: true, HostedDomain: "example.com"}, ""},
+		{"consumer account", allowed, GoogleUserInfo{Email: "a@gmail.com", VerifiedEmail: true}, rejectDomain},
+		{"email domain without hd claim", allowed, GoogleUserInfo{Email: "a@example.com", VerifiedEmail: true}, rejectDomain},
+		{"hd and email disagree", allowed, GoogleUserInfo{Email: "a@other.com", VerifiedEmail: true, HostedDomain: "example.com"}, rejectDomain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason := checkSignIn(tt.allowed, &tt.info); reason != tt.reason {
+				t.Errorf("expected %q, got %q", tt.reason, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/blueprint"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/schema"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const errBlueprintParent = "Blueprint requests have no resource type of their own; use their components"
+
+// createBlueprint files a blueprint request: a parent request that carries
+// the approval and a create request per component. input.Configuration maps
+// component names to configuration merged over the blueprint's defaults.
+func (h *RequestHandler) createBlueprint(c *fiber.Ctx, input CreateRequestInput) error {
+	userID := middleware.GetUserID(c)
+
+	if input.BlueprintID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "blueprint_id is required to request a blueprint",
+		})
+	}
+
+	var bp models.Blueprint
+	if err := h.db.Preload("Components", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Components.ResourceType").First(&bp, "id = ?", *input.BlueprintID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Blueprint not found",
+		})
+	}
+	if !bp.IsActive {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Blueprint is not active",
+		})
+	}
+	if _, err := blueprint.Order(blueprintComponents(bp.Components)); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var env models.Environment
+	if err := h.db.First(&env, "id = ?", input.EnvironmentID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Environment not found",
+		})
+	}
+	if !env.IsActive {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Environment is not active",
+		})
+	}
+
+	ttlHours, err := env.TTL(input.TTLHours)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	teamID, err := h.resolveTeam(c, input.TeamID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	priority := input.Priority
+	if priority == "" {
+		priority = "normal"
+	}
+
+	parent := models.Request{
+		Title:         input.Title,
+		Description:   input.Description,
+		Kind:          models.RequestKindBlueprint,
+		BlueprintID:   &bp.ID,
+		RequesterID:   userID,
+		EnvironmentID: env.ID,
+		TeamID:        teamID,
+		Configuration: models.JSON{},
+		Status:        models.StatusDraft,
+		Priority:      priority,
+		TTLHours:      ttlHours,
+	}
+
+	var components []models.Request
+	var fieldErrors []schema.FieldError
+	for _, component := range bp.Components {
+		rt := component.ResourceType
+		override, _ := input.Configuration[component.Name].(map[string]interface{})
+		config, errs, err := schema.Validate(rt.ConfigSchema, schema.Merge(component.Configuration, override))
+		if err != nil {
+			return configurationError(c, nil, err)
+		}
+		for _, fe := range errs {
+			fe.Field = component.Name + "." + fe.Field
+			fieldErrors = append(fieldErrors, fe)
+		}
+
+		request := models.Request{
+			Title:          fmt.Sprintf("%s: %s", input.Title, component.Name),
+			Kind:           models.RequestKindCreate,
+			BlueprintID:    &bp.ID,
+			Component:      component.Name,
+			Wiring:         component.Wiring,
+			DependsOn:      component.DependsOn,
+			RequesterID:    userID,
+			EnvironmentID:  env.ID,
+			ResourceTypeID: &rt.ID,
+			TeamID:         teamID,
+			Configuration:  config,
+			SchemaVersion:  rt.CurrentVersion,
+			Status:         models.StatusDraft,
+			Priority:       priority,
+			TTLHours:       ttlHours,
+		}
+		h.estimateCost(c.Context(), &request, &env, rt)
+
+		parent.Configuration[component.Name] = config
+		parent.EstimatedCost += request.EstimatedCost
+		components = append(components, request)
+	}
+	if len(fieldErrors) > 0 {
+		return configurationError(c, fieldErrors, nil)
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&parent).Error; err != nil {
+			return err
+		}
+		for i := range components {
+			components[i].ParentID = &parent.ID
+			if err := tx.Create(&components[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create request",
+		})
+	}
+
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "create",
+		ResourceType: "request",
+		ResourceID:   &parent.ID,
+		NewValues:    models.JSON{"blueprint": bp.Name, "configuration": parent.Configuration},
+	})
+
+	h.loadBlueprintRequest(&parent)
+	return c.Status(fiber.StatusCreated).JSON(parent)
+}
+
+// prepareBlueprint re-validates a blueprint request's components against
+// their current schemas and re-estimates their cost before it is submitted.
+// On failure it returns the status and body to respond with.
+func (h *RequestHandler) prepareBlueprint(c *fiber.Ctx, parent *models.Request) (int, fiber.Map) {
+	var components []models.Request
+	if err := h.db.Preload("ResourceType").Where("parent_id = ?", parent.ID).
+		Find(&components).Error; err != nil {
+		return fiber.StatusInternalServerError, fiber.Map{"error": "Failed to load components"}
+	}
+	if len(components) == 0 {
+		return fiber.StatusBadRequest, fiber.Map{"error": "Blueprint request has no components"}
+	}
+
+	var fieldErrors []schema.FieldError
+	for i := range components {
+		component := &components[i]
+		config, errs, err := schema.Validate(component.ResourceType.ConfigSchema, component.Configuration)
+		if err != nil {
+			return configurationErrorResponse(nil, err)
+		}
+		for _, fe := range errs {
+			fe.Field = component.Component + "." + fe.Field
+			fieldErrors = append(fieldErrors, fe)
+		}
+		component.Configuration = config
+		component.SchemaVersion = component.ResourceType.CurrentVersion
+	}
+	if len(fieldErrors) > 0 {
+		return configurationErrorResponse(fieldErrors, nil)
+	}
+
+	if parent.Configuration == nil {
+		parent.Configuration = models.JSON{}
+	}
+	parent.EstimatedCost = 0
+	for i := range components {
+		component := &components[i]
+		h.estimateCost(c.Context(), component, parent.Environment, component.ResourceType)
+		parent.Configuration[component.Component] = component.Configuration
+		parent.EstimatedCost += component.EstimatedCost
+
+		if err := h.db.Model(component).Select("configuration", "schema_version", "estimated_cost", "cost_breakdown").
+			Updates(component).Error; err != nil {
+			return fiber.StatusInternalServerError, fiber.Map{"error": "Failed to update components"}
+		}
+	}
+	return 0, nil
+}
+
+// syncComponents gives the components of a blueprint request the parent's
+// status while it moves through approval. Components that were already
+// applied keep their status.
+func syncComponents(tx *gorm.DB, request *models.Request) error {
+	if request.Kind != models.RequestKindBlueprint {
+		return nil
+	}
+	return tx.Model(&models.Request{}).
+		Where("parent_id = ? AND status <> ?", request.ID, models.StatusApplied).
+		Update("status", request.Status).Error
+}
+
+// checkComponent refuses actions on a component of a blueprint request,
+// which must be taken on the blueprint request instead
+func checkComponent(request *models.Request) (int, string) {
+	if request.ParentID == nil {
+		return 0, ""
+	}
+	return fiber.StatusBadRequest, "Request is a component of blueprint request " + request.ParentID.String() + "; act on that request instead"
+}
+
+// loadBlueprintRequest loads the relations of a blueprint request for
+// responses
+func (h *RequestHandler) loadBlueprintRequest(request *models.Request) {
+	h.db.Preload("Requester").Preload("Environment").Preload("Team").Preload("Blueprint").
+		Preload("Components", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at")
+		}).Preload("Components.ResourceType").Preload("Components.Resource").
+		First(request, "id = ?", request.ID)
+}
+
+// blueprintComponents returns the ordering view of a blueprint's components
+func blueprintComponents(components []models.BlueprintComponent) []blueprint.Component {
+	result := make([]blueprint.Component, len(components))
+	for i, component := range components {
+		result[i] = blueprint.Component{
+			Name:      component.Name,
+			Wiring:    component.Wiring,
+			DependsOn: component.DependsOn,
+		}
+	}
+	return result
+}
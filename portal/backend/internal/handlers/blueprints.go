@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/blueprint"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BlueprintHandler handles blueprint endpoints
+type BlueprintHandler struct {
+	db *gorm.DB
+}
+
+// NewBlueprintHandler creates a new blueprint handler
+func NewBlueprintHandler(db *gorm.DB) *BlueprintHandler {
+	return &BlueprintHandler{db: db}
+}
+
+// BlueprintInput represents input for creating or updating a blueprint.
+// Omitted fields are left unchanged on update; components, when given,
+// replace the existing ones.
+type BlueprintInput struct {
+	Name        *string                    `json:"name"`
+	DisplayName *string                    `json:"display_name"`
+	Description *string                    `json:"description"`
+	IsActive    *bool                      `json:"is_active"`
+	Components  *[]BlueprintComponentInput `json:"components"`
+}
+
+// BlueprintComponentInput represents one component of a blueprint
+type BlueprintComponentInput struct {
+	Name           string      `json:"name"`
+	ResourceTypeID uuid.UUID   `json:"resource_type_id"`
+	Configuration  models.JSON `json:"configuration"`
+	Wiring         models.JSON `json:"wiring"`
+	DependsOn      []string    `json:"depends_on"`
+}
+
+// List returns active blueprints. Admins can pass all=true to include
+// inactive ones.
+func (h *BlueprintHandler) List(c *fiber.Ctx) error {
+	query := h.db.Preload("Components", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Components.ResourceType").Order("name")
+	if c.Query("all") != "true" || middleware.GetUserRole(c) != models.RoleAdmin {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var blueprints []models.Blueprint
+	if err := query.Find(&blueprints).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch blueprints",
+		})
+	}
+	return c.JSON(blueprints)
+}
+
+// Get returns a single blueprint with its components
+func (h *BlueprintHandler) Get(c *fiber.Ctx) error {
+	blueprint, err := h.load(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Blueprint not found",
+		})
+	}
+	return c.JSON(blueprint)
+}
+
+// Create creates a blueprint
+func (h *BlueprintHandler) Create(c *fiber.Ctx) error {
+	var input BlueprintInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name == nil || !slugPattern.MatchString(strings.TrimSpace(*input.Name)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name must start with a letter and contain only lowercase letters, digits and hyphens",
+		})
+	}
+	if input.Components == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Components are required",
+		})
+	}
+	components, status, message := h.buildComponents(*input.Components)
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	bp := models.Blueprint{
+		Name:     strings.TrimSpace(*input.Name),
+		IsActive: true,
+	}
+	applyBlueprintInput(&bp, input)
+	if bp.DisplayName == "" {
+		bp.DisplayName = bp.Name
+	}
+
+	var count int64
+	h.db.Model(&models.Blueprint{}).Where("name = ?", bp.Name).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A blueprint with this name already exists",
+		})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&bp).Error; err != nil {
+			return err
+		}
+		if !bp.IsActive {
+			if err := tx.Model(&bp).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		return replaceComponents(tx, bp.ID, components)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create blueprint",
+		})
+	}
+
+	created, _ := h.load(bp.ID.String())
+	h.audit(c, "create", bp.ID, nil, blueprintValues(*created))
+
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// Update changes a blueprint. Requests already filed keep the components
+// they were created with.
+func (h *BlueprintHandler) Update(c *fiber.Ctx) error {
+	bp, err := h.load(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Blueprint not found",
+		})
+	}
+
+	var input BlueprintInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name != nil && *input.Name != bp.Name {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Blueprint name cannot be changed",
+		})
+	}
+
+	var components []models.BlueprintComponent
+	if input.Components != nil {
+		var status int
+		var message string
+		components, status, message = h.buildComponents(*input.Components)
+		if status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": message,
+			})
+		}
+	}
+
+	before := blueprintValues(*bp)
+	applyBlueprintInput(bp, input)
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(bp).Select("display_name", "description", "is_active").Updates(bp).Error; err != nil {
+			return err
+		}
+		if input.Components == nil {
+			return nil
+		}
+		return replaceComponents(tx, bp.ID, components)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update blueprint",
+		})
+	}
+
+	updated, _ := h.load(bp.ID.String())
+	oldValues, newValues := diffValues(before, blueprintValues(*updated))
+	if len(newValues) > 0 {
+		h.audit(c, "update", bp.ID, oldValues, newValues)
+	}
+
+	return c.JSON(updated)
+}
+
+// Delete deletes a blueprint that no request was filed from
+func (h *BlueprintHandler) Delete(c *fiber.Ctx) error {
+	bp, err := h.load(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Blueprint not found",
+		})
+	}
+
+	var count int64
+	h.db.Model(&models.Request{}).Where("blueprint_id = ?", bp.ID).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Blueprint has requests; deactivate it instead",
+		})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("blueprint_id = ?", bp.ID).Delete(&models.BlueprintComponent{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(bp).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete blueprint",
+		})
+	}
+
+	h.audit(c, "delete", bp.ID, blueprintValues(*bp), nil)
+
+	return c.JSON(fiber.Map{"message": "Blueprint deleted"})
+}
+
+func (h *BlueprintHandler) load(id string) (*models.Blueprint, error) {
+	var bp models.Blueprint
+	query := h.db.Preload("Components", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Components.ResourceType")
+	if _, err := uuid.Parse(id); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("name = ?", id)
+	}
+	if err := query.First(&bp).Error; err != nil {
+		return nil, err
+	}
+	return &bp, nil
+}
+
+// buildComponents checks component inputs and returns them as components
+// in the given order. On failure it returns the status and message to
+// respond with.
+func (h *BlueprintHandler) buildComponents(inputs []BlueprintComponentInput) ([]models.BlueprintComponent, int, string) {
+	if len(inputs) == 0 {
+		return nil, fiber.StatusBadRequest, "A blueprint needs at least one component"
+	}
+
+	components := make([]models.BlueprintComponent, len(inputs))
+	for i, input := range inputs {
+		var rt models.ResourceType
+		if err := h.db.Select("id").First(&rt, "id = ?", input.ResourceTypeID).Error; err != nil {
+			return nil, fiber.StatusBadRequest, "Resource type for component " + input.Name + " not found"
+		}
+		components[i] = models.BlueprintComponent{
+			Name:           strings.TrimSpace(input.Name),
+			Position:       i + 1,
+			ResourceTypeID: rt.ID,
+			Configuration:  input.Configuration,
+			Wiring:         input.Wiring,
+			DependsOn:      input.DependsOn,
+		}
+	}
+	if _, err := blueprint.Order(blueprintComponents(components)); err != nil {
+		return nil, fiber.StatusBadRequest, err.Error()
+	}
+	return components, 0, ""
+}
+
+// replaceComponents swaps the components of a blueprint
+func replaceComponents(tx *gorm.DB, blueprintID uuid.UUID, components []models.BlueprintComponent) error {
+	if err := tx.Where("blueprint_id = ?", blueprintID).Delete(&models.BlueprintComponent{}).Error; err != nil {
+		return err
+	}
+	for i := range components {
+		components[i].BlueprintID = blueprintID
+	}
+	return tx.Create(&components).Error
+}
+
+func (h *BlueprintHandler) audit(c *fiber.Ctx, action string, blueprintID uuid.UUID, oldValues, newValues models.JSON) {
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       action,
+		ResourceType: "blueprint",
+		ResourceID:   &blueprintID,
+		OldValues:    oldValues,
+		NewValues:    newValues,
+	})
+}
+
+// applyBlueprintInput copies the metadata fields that were provided onto bp
+func applyBlueprintInput(bp *models.Blueprint, input BlueprintInput) {
+	if input.DisplayName != nil {
+		bp.DisplayName = strings.TrimSpace(*input.DisplayName)
+	}
+	if input.Description != nil {
+		bp.Description = *input.Description
+	}
+	if input.IsActive != nil {
+		bp.IsActive = *input.IsActive
+	}
+}
+
+// blueprintValues returns a blueprint and its components for audit entries
+func blueprintValues(bp models.Blueprint) models.JSON {
+	components := make([]interface{}, len(bp.Components))
+	for i, component := range bp.Components {
+		components[i] = map[string]interface{}{
+			"name":             component.Name,
+			"resource_type_id": component.ResourceTypeID.String(),
+			"configuration":    map[string]interface{}(component.Configuration),
+			"wiring":           map[string]interface{}(component.Wiring),
+			"depends_on":       []string(component.DependsOn),
+		}
+	}
+	return models.JSON{
+		"name":         bp.Name,
+		"display_name": bp.DisplayName,
+		"description":  bp.Description,
+		"is_active":    bp.IsActive,
+		"components":   components,
+	}
+}
@@ -1,8 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/workflow"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -16,10 +25,40 @@ func NewEnvironmentHandler(db *gorm.DB) *EnvironmentHandler {
 	return &EnvironmentHandler{db: db}
 }
 
-// List returns all environments
+var (
+	slugPattern         = regexp.MustCompile(`^[a-z][a-z0-9-]{0,30}$`)
+	gcpProjectIDPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	gcpRegionPattern    = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+)
+
+// EnvironmentInput represents input for creating or updating an
+// environment. Omitted fields are left unchanged on update.
+type EnvironmentInput struct {
+	Name             *string `json:"name"`
+	DisplayName      *string `json:"display_name"`
+	Description      *string `json:"description"`
+	GCPProjectID     *string `json:"gcp_project_id"`
+	Region           *string `json:"region"`
+	RequiresApproval *bool   `json:"requires_approval"`
+	IsActive         *bool   `json:"is_active"`
+	MaxTTLHours      *int    `json:"max_ttl_hours"`
+
+	// NextEnvironmentID is where applied requests are promoted to; an empty
+	// string clears it
+	NextEnvironmentID  *string      `json:"next_environment_id"`
+	PromotionOverrides *models.JSON `json:"promotion_overrides"`
+}
+
+// List returns active environments. Admins can pass all=true to include
+// inactive ones.
 func (h *EnvironmentHandler) List(c *fiber.Ctx) error {
+	query := h.db.Order("name")
+	if c.Query("all") != "true" || middleware.GetUserRole(c) != models.RoleAdmin {
+		query = query.Where("is_active = ?", true)
+	}
+
 	var environments []models.Environment
-	if err := h.db.Where("is_active = ?", true).Find(&environments).Error; err != nil {
+	if err := query.Find(&environments).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch environments",
 		})
@@ -31,6 +70,92 @@ func (h *EnvironmentHandler) List(c *fiber.Ctx) error {
 func (h *EnvironmentHandler) Get(c *fiber.Ctx) error {
 	id := c.Params("id")
 
+	var environment models.Environment
+	if err := h.db.Preload("ApprovalStages", orderByPosition).
+		First(&environment, "id = ? OR name = ?", id, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Environment not found",
+		})
+	}
+
+	return c.JSON(environment)
+}
+
+// Create creates a new environment
+func (h *EnvironmentHandler) Create(c *fiber.Ctx) error {
+	var input EnvironmentInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required",
+		})
+	}
+
+	environment := models.Environment{
+		Name:     strings.TrimSpace(*input.Name),
+		Region:   "asia-southeast1",
+		IsActive: true,
+	}
+	if err := applyEnvironmentInput(&environment, input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if environment.DisplayName == "" {
+		environment.DisplayName = environment.Name
+	}
+
+	if err := validateEnvironment(environment); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := h.checkPromotionChain(environment); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var count int64
+	h.db.Model(&models.Environment{}).Where("name = ?", environment.Name).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "An environment with this name already exists",
+		})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&environment).Error; err != nil {
+			return err
+		}
+		// is_active defaults to true, so an inactive environment needs an
+		// explicit update
+		if !environment.IsActive {
+			return tx.Model(&environment).Update("is_active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create environment",
+		})
+	}
+
+	h.audit(c, "create", environment.ID, nil, environmentValues(environment))
+
+	return c.Status(fiber.StatusCreated).JSON(environment)
+}
+
+// Update changes an environment's settings. The name is part of every
+// provisioned resource's state path, so it cannot be changed. Deactivating
+// is refused while requests in the environment are still in flight.
+func (h *EnvironmentHandler) Update(c *fiber.Ctx) error {
+	id := c.Params("id")
+
 	var environment models.Environment
 	if err := h.db.First(&environment, "id = ? OR name = ?", id, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -38,5 +163,354 @@ func (h *EnvironmentHandler) Get(c *fiber.Ctx) error {
 		})
 	}
 
+	var input EnvironmentInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name != nil && *input.Name != environment.Name {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Environment name cannot be changed",
+		})
+	}
+
+	before := environmentValues(environment)
+	if err := applyEnvironmentInput(&environment, input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := validateEnvironment(environment); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := h.checkPromotionChain(environment); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if before["is_active"] == true && !environment.IsActive {
+		inFlight, err := h.countInFlight(environment.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check requests",
+			})
+		}
+		if inFlight > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":              "Environment has requests in flight",
+				"in_flight_requests": inFlight,
+			})
+		}
+	}
+
+	oldValues, newValues := diffValues(before, environmentValues(environment))
+	if len(newValues) == 0 {
+		return c.JSON(environment)
+	}
+
+	if err := h.db.Model(&environment).Updates(map[string]interface{}(newValues)).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update environment",
+		})
+	}
+
+	h.audit(c, "update", environment.ID, oldValues, newValues)
+
 	return c.JSON(environment)
 }
+
+// Delete removes an environment that has never been used. Environments
+// with requests can only be deactivated.
+func (h *EnvironmentHandler) Delete(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var environment models.Environment
+	if err := h.db.First(&environment, "id = ? OR name = ?", id, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Environment not found",
+		})
+	}
+
+	var count int64
+	if err := h.db.Unscoped().Model(&models.Request{}).Where("environment_id = ?", environment.ID).Count(&count).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check requests",
+		})
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Environment has requests, deactivate it instead",
+		})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("environment_id = ?", environment.ID).Delete(&models.ApprovalStage{}).Error; err != nil {
+			return err
+		}
+		// Environments that promoted into this one become the end of their chain
+		if err := tx.Model(&models.Environment{}).Where("next_environment_id = ?", environment.ID).
+			Update("next_environment_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&environment).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete environment",
+		})
+	}
+
+	h.audit(c, "delete", environment.ID, environmentValues(environment), nil)
+
+	return c.JSON(fiber.Map{
+		"message": "Environment deleted",
+	})
+}
+
+// ApprovalStageInput represents one stage of an approval policy
+type ApprovalStageInput struct {
+	Name              string   `json:"name"`
+	RequiredApprovals int      `json:"required_approvals"`
+	RequiredRoles     []string `json:"required_roles"`
+	RequiredGroups    []string `json:"required_groups"`
+}
+
+// ApprovalPolicyInput represents input for replacing an approval policy
+type ApprovalPolicyInput struct {
+	Stages              []ApprovalStageInput `json:"stages"`
+	AllowSelfApproval   *bool                `json:"allow_self_approval"`
+	BlockEditorApproval *bool                `json:"block_editor_approval"`
+}
+
+// GetApprovalPolicy returns the effective approval stages for an environment
+func (h *EnvironmentHandler) GetApprovalPolicy(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var environment models.Environment
+	if err := h.db.Preload("ApprovalStages").
+		First(&environment, "id = ? OR name = ?", id, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Environment not found",
+		})
+	}
+
+	return c.JSON(approvalPolicy(environment))
+}
+
+// UpdateApprovalPolicy replaces the approval stages for an environment.
+// Stages are ordered as given.
+func (h *EnvironmentHandler) UpdateApprovalPolicy(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var environment models.Environment
+	if err := h.db.First(&environment, "id = ? OR name = ?", id, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Environment not found",
+		})
+	}
+
+	var input ApprovalPolicyInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+
+	stages := make([]models.ApprovalStage, len(input.Stages))
+	for i, in := range input.Stages {
+		if in.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Every stage needs a name",
+			})
+		}
+		if in.RequiredApprovals < 1 {
+			in.RequiredApprovals = 1
+		}
+		stages[i] = models.ApprovalStage{
+			EnvironmentID:     environment.ID,
+			Position:          i + 1,
+			Name:              in.Name,
+			RequiredApprovals: in.RequiredApprovals,
+			RequiredRoles:     in.RequiredRoles,
+			RequiredGroups:    in.RequiredGroups,
+		}
+	}
+
+	if input.AllowSelfApproval != nil {
+		environment.AllowSelfApproval = *input.AllowSelfApproval
+	}
+	if input.BlockEditorApproval != nil {
+		environment.BlockEditorApproval = *input.BlockEditorApproval
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&environment).Select("allow_self_approval", "block_editor_approval").
+			Updates(&environment).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("environment_id = ?", environment.ID).Delete(&models.ApprovalStage{}).Error; err != nil {
+			return err
+		}
+		if len(stages) == 0 {
+			return nil
+		}
+		return tx.Create(&stages).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update approval policy",
+		})
+	}
+
+	environment.ApprovalStages = stages
+	policy := approvalPolicy(environment)
+	h.audit(c, "approval_policy_update", environment.ID, nil, models.JSON(policy))
+
+	return c.JSON(policy)
+}
+
+func (h *EnvironmentHandler) countInFlight(environmentID uuid.UUID) (int64, error) {
+	var count int64
+	err := h.db.Model(&models.Request{}).
+		Where("environment_id = ? AND status IN ?", environmentID, models.InFlightStatuses).
+		Count(&count).Error
+	return count, err
+}
+
+func (h *EnvironmentHandler) audit(c *fiber.Ctx, action string, environmentID uuid.UUID, oldValues, newValues models.JSON) {
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       action,
+		ResourceType: "environment",
+		ResourceID:   &environmentID,
+		OldValues:    oldValues,
+		NewValues:    newValues,
+	})
+}
+
+// checkPromotionChain makes sure the environment's next environment exists
+// and that following the chain never leads back to it
+func (h *EnvironmentHandler) checkPromotionChain(environment models.Environment) error {
+	seen := map[uuid.UUID]bool{environment.ID: true}
+	next := environment.NextEnvironmentID
+	for next != nil {
+		if seen[*next] {
+			return errors.New("Promotion would loop back to an earlier environment")
+		}
+		seen[*next] = true
+
+		var env models.Environment
+		if err := h.db.Select("id", "next_environment_id").First(&env, "id = ?", *next).Error; err != nil {
+			return errors.New("Next environment not found")
+		}
+		next = env.NextEnvironmentID
+	}
+	return nil
+}
+
+// applyEnvironmentInput copies the fields that were provided onto environment
+func applyEnvironmentInput(environment *models.Environment, input EnvironmentInput) error {
+	if input.DisplayName != nil {
+		environment.DisplayName = strings.TrimSpace(*input.DisplayName)
+	}
+	if input.Description != nil {
+		environment.Description = *input.Description
+	}
+	if input.GCPProjectID != nil {
+		environment.GCPProjectID = strings.TrimSpace(*input.GCPProjectID)
+	}
+	if input.Region != nil {
+		environment.Region = strings.TrimSpace(*input.Region)
+	}
+	if input.RequiresApproval != nil {
+		environment.RequiresApproval = *input.RequiresApproval
+	}
+	if input.IsActive != nil {
+		environment.IsActive = *input.IsActive
+	}
+	if input.MaxTTLHours != nil {
+		environment.MaxTTLHours = *input.MaxTTLHours
+	}
+	if input.NextEnvironmentID != nil {
+		environment.NextEnvironmentID = nil
+		if *input.NextEnvironmentID != "" {
+			next, err := uuid.Parse(*input.NextEnvironmentID)
+			if err != nil {
+				return errors.New("Invalid next environment ID")
+			}
+			environment.NextEnvironmentID = &next
+		}
+	}
+	if input.PromotionOverrides != nil {
+		environment.PromotionOverrides = *input.PromotionOverrides
+	}
+	return nil
+}
+
+// validateEnvironment checks the name, GCP project ID and region formats,
+// the max TTL and the shape of the promotion overrides
+func validateEnvironment(environment models.Environment) error {
+	if !slugPattern.MatchString(environment.Name) {
+		return errors.New("Name must start with a letter and contain only lowercase letters, digits and hyphens")
+	}
+	if environment.GCPProjectID != "" && !gcpProjectIDPattern.MatchString(environment.GCPProjectID) {
+		return errors.New("Invalid GCP project ID")
+	}
+	if !gcpRegionPattern.MatchString(environment.Region) {
+		return errors.New("Invalid GCP region")
+	}
+	if environment.MaxTTLHours < 0 {
+		return errors.New("Max TTL must not be negative")
+	}
+	for resourceType, override := range environment.PromotionOverrides {
+		if _, ok := override.(map[string]interface{}); !ok {
+			return fmt.Errorf("Promotion overrides for %s must be an object", resourceType)
+		}
+	}
+	return nil
+}
+
+// environmentValues returns the editable fields of an environment, keyed
+// by column name, for audit entries
+func environmentValues(environment models.Environment) models.JSON {
+	return models.JSON{
+		"name":                environment.Name,
+		"display_name":        environment.DisplayName,
+		"description":         environment.Description,
+		"gcp_project_id":      environment.GCPProjectID,
+		"region":              environment.Region,
+		"requires_approval":   environment.RequiresApproval,
+		"is_active":           environment.IsActive,
+		"max_ttl_hours":       environment.MaxTTLHours,
+		"next_environment_id": environment.NextEnvironmentID,
+		"promotion_overrides": environment.PromotionOverrides,
+	}
+}
+
+// diffValues returns the old and new values of the keys that changed
+func diffValues(before, after models.JSON) (models.JSON, models.JSON) {
+	oldValues, newValues := models.JSON{}, models.JSON{}
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			oldValues[key] = before[key]
+			newValues[key] = value
+		}
+	}
+	return oldValues, newValues
+}
+
+func approvalPolicy(environment models.Environment) fiber.Map {
+	return fiber.Map{
+		"requires_approval":     environment.RequiresApproval,
+		"allow_self_approval":   environment.AllowSelfApproval,
+		"block_editor_approval": environment.BlockEditorApproval,
+		"stages":                workflow.Stages(environment),
+	}
+}
+
+func orderByPosition(db *gorm.DB) *gorm.DB {
+	return db.Order("position")
+}
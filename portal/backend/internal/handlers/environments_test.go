@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+func TestValidateEnvironment(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment models.Environment
+		valid       bool
+	}{
+		{"valid", models.Environment{Name: "qa", GCPProjectID: "acme-qa-123", Region: "europe-west1"}, true},
+		{"no project yet", models.Environment{Name: "sandbox-2", Region: "us-central1"}, true},
+		{"uppercase name", models.Environment{Name: "QA", Region: "europe-west1"}, false},
+		{"empty name", models.Environment{Region: "europe-west1"}, false},
+		{"short project id", models.Environment{Name: "qa", GCPProjectID: "abc", Region: "europe-west1"}, false},
+		{"project id ends with hyphen", models.Environment{Name: "qa", GCPProjectID: "acme-qa-", Region: "europe-west1"}, false},
+		{"zone instead of region", models.Environment{Name: "qa", Region: "europe-west1-b"}, false},
+		{"max ttl", models.Environment{Name: "sandbox", Region: "us-central1", MaxTTLHours: 72}, true},
+		{"negative max ttl", models.Environment{Name: "sandbox", Region: "us-central1", MaxTTLHours: -1}, false},
+		{"promotion overrides", models.Environment{Name: "prod", Region: "us-central1", PromotionOverrides: models.JSON{
+			"cloudsql": map[string]interface{}{"high_availability": true},
+		}}, true},
+		{"override is not an object", models.Environment{Name: "prod", Region: "us-central1", PromotionOverrides: models.JSON{
+			"cloudsql": true,
+		}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEnvironment(tt.environment)
+			if tt.valid && err != nil {
+				t.Errorf("expected valid, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	before := models.JSON{"region": "asia-southeast1", "is_active": true, "description": "old"}
+	after := models.JSON{"region": "europe-west1", "is_active": true, "description": "old"}
+
+	oldValues, newValues := diffValues(before, after)
+	if len(newValues) != 1 || newValues["region"] != "europe-west1" {
+		t.Errorf("unexpected new values: %v", newValues)
+	}
+	if len(oldValues) != 1 || oldValues["region"] != "asia-southeast1" {
+		t.Errorf("unexpected old values: %v", oldValues)
+	}
+}
+
+func TestDiffValuesNested(t *testing.T) {
+	before := models.JSON{"promotion_overrides": models.JSON{"cloudsql": map[string]interface{}{"high_availability": true}}}
+	after := models.JSON{"promotion_overrides": models.JSON{"cloudsql": map[string]interface{}{"high_availability": true}}}
+
+	if _, newValues := diffValues(before, after); len(newValues) != 0 {
+		t.Errorf("expected no changes, got %v", newValues)
+	}
+
+	after["promotion_overrides"] = models.JSON{}
+	if _, newValues := diffValues(before, after); len(newValues) != 1 {
+		t.Errorf("expected the overrides to change, got %v", newValues)
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/provisioner"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/testdb"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// fixture is a test database with an environment and resource type to
+// file requests against
+type fixture struct {
+	t            *testing.T
+	db           *gorm.DB
+	environment  models.Environment
+	resourceType models.ResourceType
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	f := &fixture{t: t, db: testdb.New(t)}
+	f.environment = models.Environment{Name: "dev", DisplayName: "Development", Region: "us-central1"}
+	f.resourceType = models.ResourceType{
+		Name:         "redis",
+		DisplayName:  "Memorystore Redis",
+		ModulePath:   "terraform/modules/redis",
+		ConfigSchema: models.JSON{"type": "object"},
+	}
+	f.create(&f.environment)
+	f.create(&f.resourceType)
+	return f
+}
+
+func (f *fixture) create(value interface{}) {
+	f.t.Helper()
+	if err := f.db.Create(value).Error; err != nil {
+		f.t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+func (f *fixture) user(name, role string) models.User {
+	f.t.Helper()
+	user := models.User{Email: name + "@example.com", Name: name, Role: role}
+	f.create(&user)
+	return user
+}
+
+func (f *fixture) team(name string, members ...models.User) models.Team {
+	f.t.Helper()
+	team := models.Team{Name: name, DisplayName: name}
+	f.create(&team)
+	for _, member := range members {
+		f.create(&models.TeamMembership{TeamID: team.ID, UserID: member.ID})
+	}
+	return team
+}
+
+// engine returns a provisioner that runs against a fake Terraform and an
+// empty module for the fixture's resource type. Runs finish before the
+// database is closed.
+func (f *fixture) engine() *provisioner.Engine {
+	repo := f.t.TempDir()
+	module := filepath.Join(repo, f.resourceType.ModulePath)
+	if err := os.MkdirAll(module, 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(module, "main.tf"), []byte("# main\n"), 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+
+	engine := provisioner.NewEngine(f.db, &provisioner.Pipeline{
+		Workspaces: &provisioner.Workspaces{BaseDir: f.t.TempDir(), RepoDir: repo},
+		Runner:     provisioner.NewFakeRunner(),
+	})
+	f.t.Cleanup(engine.Wait)
+	return engine
+}
+
+// request files a request by requester in the fixture's environment
+func (f *fixture) request(requester models.User, status string, team *models.Team) models.Request {
+	f.t.Helper()
+	request := models.Request{
+		Title:          "cache",
+		RequesterID:    requester.ID,
+		EnvironmentID:  f.environment.ID,
+		ResourceTypeID: &f.resourceType.ID,
+		Configuration:  models.JSON{"memory_size_gb": 1},
+		Status:         status,
+	}
+	if team != nil {
+		request.TeamID = &team.ID
+	}
+	f.create(&request)
+	return request
+}
+
+// resource records an active resource owned by owner, created by an
+// applied request
+func (f *fixture) resource(owner models.User, team *models.Team) models.Resource {
+	f.t.Helper()
+	request := f.request(owner, models.StatusApplied, team)
+	resource := models.Resource{
+		Name:           "dev-" + request.ID.String()[:8],
+		RequestID:      request.ID,
+		EnvironmentID:  f.environment.ID,
+		ResourceTypeID: f.resourceType.ID,
+		OwnerID:        owner.ID,
+		TeamID:         request.TeamID,
+		Configuration:  request.Configuration,
+		StatePrefix:    "dev/" + request.ID.String(),
+		Status:         models.ResourceActive,
+	}
+	f.create(&resource)
+	return resource
+}
+
+// app serves a route as user, standing in for the auth middleware
+func (f *fixture) app(user models.User, method, path string, handler fiber.Handler) *fiber.App {
+	app := fiber.New()
+	app.Add(method, path, func(c *fiber.Ctx) error {
+		c.Locals("userID", user.ID)
+		c.Locals("role", user.Role)
+		return c.Next()
+	}, handler)
+	return app
+}
+
+// call sends a request to app and decodes the JSON response into out, if
+// given
+func call(t *testing.T, app *fiber.App, method, target string, body interface{}, out interface{}) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < http.StatusBadRequest {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode %s %s: %v", method, target, err)
+		}
+	}
+	return resp.StatusCode
+}
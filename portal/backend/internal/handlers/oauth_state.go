@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/config"
+)
+
+// oauthStateCookie holds the login state between GoogleLogin and GoogleCallback
+const oauthStateCookie = "oauth_state"
+
+// oauthStateTTL is how long a user has to complete the Google login
+const oauthStateTTL = 10 * time.Minute
+
+// oauthStateKeyLabel derives the state cookie key from JWT_SECRET, so the
+// secret itself never signs anything a client can see
+const oauthStateKeyLabel = "infra-portal oauth state"
+
+var (
+	errStateKey      = errors.New("JWT_SECRET is not set")
+	errStateMissing  = errors.New("missing login state")
+	errStateInvalid  = errors.New("invalid login state")
+	errStateExpired  = errors.New("login state expired")
+	errStateMismatch = errors.New("login state does not match")
+)
+
+// oauthState is bound to the browser through a signed cookie. The cookie is
+// signed, not encrypted: the PKCE verifier is stored in it and so is held by
+// the browser until the callback, though scripts cannot read it.
+type oauthState struct {
+	State     string `json:"s"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// oauthStateKey derives the key state cookies are signed with. It refuses
+// an unset or default secret, with which anyone could forge a login state.
+func oauthStateKey(cfg *config.Config) ([]byte, error) {
+	if cfg.DefaultJWTSecret() {
+		return nil, errStateKey
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.JWTSecret))
+	mac.Write([]byte(oauthStateKeyLabel))
+	return mac.Sum(nil), nil
+}
+
+// newOAuthState creates a fresh random state and PKCE verifier
+func newOAuthState(verifier string, now time.Time) (oauthState, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return oauthState{}, err
+	}
+	return oauthState{
+		State:     base64.RawURLEncoding.EncodeToString(b),
+		Verifier:  verifier,
+		ExpiresAt: now.Add(oauthStateTTL).Unix(),
+	}, nil
+}
+
+// encode serialises and signs the state for storage in a cookie
+func (s oauthState) encode(secret []byte) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + sign(secret, payload), nil
+}
+
+// decodeOAuthState verifies the cookie signature and expiry, then checks
+// that the state returned by Google matches the one issued to this browser
+func decodeOAuthState(secret []byte, cookie, state string, now time.Time) (oauthState, error) {
+	if cookie == "" {
+		return oauthState{}, errStateMissing
+	}
+
+	payload, signature, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(secret, payload))) {
+		return oauthState{}, errStateInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return oauthState{}, errStateInvalid
+	}
+
+	var s oauthState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return oauthState{}, errStateInvalid
+	}
+
+	if now.Unix() > s.ExpiresAt {
+		return oauthState{}, errStateExpired
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(s.State), []byte(state)) != 1 {
+		return oauthState{}, errStateMismatch
+	}
+
+	return s, nil
+}
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/events"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// eventsKeepAlive is how often an idle stream sends a comment, which
+	// keeps proxies from closing it and notices clients that went away
+	eventsKeepAlive = 15 * time.Second
+
+	// eventsRetry is how long clients wait before reconnecting
+	eventsRetry = 3 * time.Second
+)
+
+// Events streams a request's progress as Server-Sent Events: status
+// transitions, approval decisions and Terraform output line by line.
+// Clients that reconnect with a Last-Event-ID header get the events they
+// missed, as long as they are still buffered.
+func (h *RequestHandler) Events(c *fiber.Ctx) error {
+	var request models.Request
+	if err := h.db.First(&request, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	allowed, err := canViewRequest(h.db, c, &request)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	var lastID uint64
+	if v := c.Get("Last-Event-ID"); v != "" {
+		lastID, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid Last-Event-ID",
+			})
+		}
+	}
+
+	replay, ch, cancel := h.events.Subscribe(request.ID, lastID)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+		for _, event := range replay {
+			if err := events.Write(w, event); err != nil {
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					// Dropped or shutting down; the client reconnects
+					return
+				}
+				if err := events.Write(w, event); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := w.WriteString(": keep-alive\n\n"); err != nil {
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// canViewRequest reports whether the caller filed the request, belongs to
+// its team or is an approver or admin
+func canViewRequest(db *gorm.DB, c *fiber.Ctx, request *models.Request) (bool, error) {
+	userID := middleware.GetUserID(c)
+	role := middleware.GetUserRole(c)
+
+	if request.RequesterID == userID || role == models.RoleAdmin || role == models.RoleApprover {
+		return true, nil
+	}
+	if request.TeamID == nil {
+		return false, nil
+	}
+	return repository.IsTeamMember(db, *request.TeamID, userID)
+}
@@ -1,10 +1,23 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/cost"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/events"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/provisioner"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/schema"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/workflow"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -12,12 +25,16 @@ import (
 
 // RequestHandler handles request endpoints
 type RequestHandler struct {
-	db *gorm.DB
+	db        *gorm.DB
+	engine    *provisioner.Engine
+	estimator cost.Estimator
+	events    *events.Broker
 }
 
-// NewRequestHandler creates a new request handler
-func NewRequestHandler(db *gorm.DB) *RequestHandler {
-	return &RequestHandler{db: db}
+// NewRequestHandler creates a new request handler. Status changes are
+// published to broker.
+func NewRequestHandler(db *gorm.DB, engine *provisioner.Engine, estimator cost.Estimator, broker *events.Broker) *RequestHandler {
+	return &RequestHandler{db: db, engine: engine, estimator: estimator, events: broker}
 }
 
 // CreateRequestInput represents input for creating a request
@@ -28,19 +45,41 @@ type CreateRequestInput struct {
 	ResourceTypeID uuid.UUID   `json:"resource_type_id" validate:"required"`
 	Configuration  models.JSON `json:"configuration" validate:"required"`
 	Priority       string      `json:"priority"`
+	TeamID         *uuid.UUID  `json:"team_id"` // defaults to the requester's only team
+
+	// Kind is create (default), modify, decommission or blueprint. A modify
+	// request changes the resource in ResourceID; its configuration is
+	// overlaid on the resource's current one, and the environment, resource
+	// type and team are taken from the resource. A decommission request
+	// destroys it. A blueprint request provisions every component of
+	// BlueprintID; its configuration maps component names to configuration.
+	Kind        string     `json:"kind"`
+	ResourceID  *uuid.UUID `json:"resource_id"`
+	BlueprintID *uuid.UUID `json:"blueprint_id"`
+
+	// Decommission options
+	FinalBackup               bool `json:"final_backup"`
+	DisableDeletionProtection bool `json:"disable_deletion_protection"`
+
+	// TTLHours is how long a new resource lives before it is decommissioned.
+	// It defaults to the environment's max TTL; 0 keeps it forever where
+	// the environment has no maximum.
+	TTLHours int `json:"ttl_hours"`
 }
 
-// List returns all requests
+// List returns requests. Admins and approvers see all of them; other users
+// see their own and those of their teams.
 func (h *RequestHandler) List(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 	role := middleware.GetUserRole(c)
 
 	var requests []models.Request
-	query := h.db.Preload("Requester").Preload("Environment").Preload("ResourceType")
+	query := h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team")
 
-	// Non-admins can only see their own requests
-	if role != "admin" && role != "approver" {
-		query = query.Where("requester_id = ?", userID)
+	// Other users see their own requests and their teams' requests
+	if role != models.RoleAdmin && role != models.RoleApprover {
+		query = query.Where("requester_id = ? OR team_id IN (?)", userID,
+			h.db.Model(&models.TeamMembership{}).Select("team_id").Where("user_id = ?", userID))
 	}
 
 	// Apply filters
@@ -50,6 +89,21 @@ func (h *RequestHandler) List(c *fiber.Ctx) error {
 	if envID := c.Query("environment_id"); envID != "" {
 		query = query.Where("environment_id = ?", envID)
 	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if resourceID := c.Query("resource_id"); resourceID != "" {
+		query = query.Where("resource_id = ?", resourceID)
+	}
+	if promotedFromID := c.Query("promoted_from_id"); promotedFromID != "" {
+		query = query.Where("promoted_from_id = ?", promotedFromID)
+	}
+	// Blueprint components are listed under their blueprint request
+	if parentID := c.Query("parent_id"); parentID != "" {
+		query = query.Where("parent_id = ?", parentID)
+	} else if c.Query("resource_id") == "" {
+		query = query.Where("parent_id IS NULL")
+	}
 
 	if err := query.Order("created_at DESC").Find(&requests).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -71,6 +125,54 @@ func (h *RequestHandler) Create(c *fiber.Ctx) error {
 		})
 	}
 
+	kind := input.Kind
+	if kind == "" {
+		kind = models.RequestKindCreate
+		if input.ResourceID != nil {
+			kind = models.RequestKindModify
+		}
+	}
+
+	var resource *models.Resource
+	switch kind {
+	case models.RequestKindCreate:
+		input.ResourceID = nil
+	case models.RequestKindModify:
+		if input.ResourceID == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "resource_id is required to modify a resource",
+			})
+		}
+		var err error
+		if resource, err = h.targetResource(c, *input.ResourceID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		input.EnvironmentID = resource.EnvironmentID
+		input.ResourceTypeID = resource.ResourceTypeID
+		input.Configuration = schema.Merge(resource.Configuration, input.Configuration)
+	case models.RequestKindDecommission:
+		if input.ResourceID == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "resource_id is required to decommission a resource",
+			})
+		}
+		resource, err := h.targetResource(c, *input.ResourceID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return h.createDecommission(c, input, resource)
+	case models.RequestKindBlueprint:
+		return h.createBlueprint(c, input)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request kind",
+		})
+	}
+
 	// Verify environment exists
 	var env models.Environment
 	if err := h.db.First(&env, "id = ?", input.EnvironmentID).Error; err != nil {
@@ -78,6 +180,23 @@ func (h *RequestHandler) Create(c *fiber.Ctx) error {
 			"error": "Environment not found",
 		})
 	}
+	if !env.IsActive {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Environment is not active",
+		})
+	}
+
+	// Only new resources get a TTL; a modify keeps the resource's expiry
+	ttlHours := 0
+	if kind == models.RequestKindCreate {
+		hours, err := env.TTL(input.TTLHours)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		ttlHours = hours
+	}
 
 	// Verify resource type exists
 	var rt models.ResourceType
@@ -87,6 +206,25 @@ func (h *RequestHandler) Create(c *fiber.Ctx) error {
 		})
 	}
 
+	config, fieldErrors, err := schema.Validate(rt.ConfigSchema, input.Configuration)
+	if err != nil || len(fieldErrors) > 0 {
+		return configurationError(c, fieldErrors, err)
+	}
+
+	var teamID *uuid.UUID
+	if resource != nil {
+		if status, message := checkModification(resource, config); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": message,
+			})
+		}
+		teamID = resource.TeamID
+	} else if teamID, err = h.resolveTeam(c, input.TeamID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	priority := input.Priority
 	if priority == "" {
 		priority = "normal"
@@ -95,13 +233,19 @@ func (h *RequestHandler) Create(c *fiber.Ctx) error {
 	request := models.Request{
 		Title:          input.Title,
 		Description:    input.Description,
+		Kind:           kind,
+		ResourceID:     input.ResourceID,
 		RequesterID:    userID,
 		EnvironmentID:  input.EnvironmentID,
-		ResourceTypeID: input.ResourceTypeID,
-		Configuration:  input.Configuration,
+		ResourceTypeID: &input.ResourceTypeID,
+		TeamID:         teamID,
+		Configuration:  config,
+		SchemaVersion:  rt.CurrentVersion,
 		Status:         models.StatusDraft,
 		Priority:       priority,
+		TTLHours:       ttlHours,
 	}
+	h.estimateCost(c.Context(), &request, &env, &rt)
 
 	if err := h.db.Create(&request).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -109,27 +253,401 @@ func (h *RequestHandler) Create(c *fiber.Ctx) error {
 		})
 	}
 
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "create",
+		ResourceType: "request",
+		ResourceID:   &request.ID,
+		NewValues:    models.JSON{"configuration": request.Configuration},
+	})
+
 	// Load relations
-	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").First(&request, "id = ?", request.ID)
+	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team").First(&request, "id = ?", request.ID)
 
 	return c.Status(fiber.StatusCreated).JSON(request)
 }
 
-// Get returns a single request
+// createDecommission files a request to destroy a resource. The request
+// carries the resource's configuration as last applied so the destroy is
+// planned with the same variables.
+func (h *RequestHandler) createDecommission(c *fiber.Ctx, input CreateRequestInput, resource *models.Resource) error {
+	var env models.Environment
+	if err := h.db.First(&env, "id = ?", resource.EnvironmentID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Environment not found",
+		})
+	}
+	var rt models.ResourceType
+	if err := h.db.First(&rt, "id = ?", resource.ResourceTypeID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Resource type not found",
+		})
+	}
+
+	if input.FinalBackup && !provisioner.BackupSupported(filepath.Base(rt.ModulePath)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Final backups are not supported for " + rt.DisplayName,
+		})
+	}
+
+	priority := input.Priority
+	if priority == "" {
+		priority = "normal"
+	}
+
+	request := models.Request{
+		Title:                     input.Title,
+		Description:               input.Description,
+		Kind:                      models.RequestKindDecommission,
+		ResourceID:                &resource.ID,
+		RequesterID:               middleware.GetUserID(c),
+		EnvironmentID:             resource.EnvironmentID,
+		Environment:               &env,
+		ResourceTypeID:            &resource.ResourceTypeID,
+		ResourceType:              &rt,
+		TeamID:                    resource.TeamID,
+		Configuration:             resource.Configuration,
+		SchemaVersion:             resource.SchemaVersion,
+		Status:                    models.StatusDraft,
+		Priority:                  priority,
+		FinalBackup:               input.FinalBackup,
+		DisableDeletionProtection: input.DisableDeletionProtection,
+	}
+
+	if status, message := h.checkProtection(&request); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	if err := h.db.Omit("Environment", "ResourceType").Create(&request).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create request",
+		})
+	}
+
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "create",
+		ResourceType: "request",
+		ResourceID:   &request.ID,
+		NewValues: models.JSON{
+			"kind":                        request.Kind,
+			"resource_id":                 resource.ID,
+			"final_backup":                request.FinalBackup,
+			"disable_deletion_protection": request.DisableDeletionProtection,
+		},
+	})
+
+	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team").Preload("Resource").
+		First(&request, "id = ?", request.ID)
+	return c.Status(fiber.StatusCreated).JSON(request)
+}
+
+// checkProtection refuses to destroy a resource with deletion protection
+// unless the request explicitly turns it off. Environment and ResourceType
+// must be loaded.
+func (h *RequestHandler) checkProtection(request *models.Request) (int, string) {
+	protected, err := h.engine.DeletionProtected(request)
+	if err != nil {
+		return fiber.StatusInternalServerError, "Failed to check deletion protection"
+	}
+	if protected && !request.DisableDeletionProtection {
+		return fiber.StatusUnprocessableEntity,
+			"Resource has deletion protection enabled; set disable_deletion_protection to destroy it anyway"
+	}
+	return 0, ""
+}
+
+// Get returns a single request to its requester, their team, approvers
+// and admins
 func (h *RequestHandler) Get(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var request models.Request
-	if err := h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").
+	if err := h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team").
+		Preload("Resource").Preload("PromotedFrom").
 		First(&request, "id = ?", id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Request not found",
 		})
 	}
 
+	allowed, err := canViewRequest(h.db, c, &request)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	if request.Kind == models.RequestKindBlueprint {
+		h.loadBlueprintRequest(&request)
+	}
+
 	return c.JSON(request)
 }
 
+// Changes lists the configuration fields a request changes. Modify requests
+// are compared with the resource's current configuration, create requests
+// with an empty one.
+func (h *RequestHandler) Changes(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var request models.Request
+	if err := h.db.Preload("Resource").First(&request, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	allowed, err := canViewRequest(h.db, c, &request)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	current := models.JSON{}
+	if request.Kind == models.RequestKindModify && request.Resource != nil {
+		current = request.Resource.Configuration
+	}
+	changes, err := schema.Diff(current, request.Configuration)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to compare configurations",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"kind":        request.Kind,
+		"resource_id": request.ResourceID,
+		"changes":     changes,
+	})
+}
+
+// Validate re-validates a request's configuration without changing it.
+// By default the schema version the request was validated against is used;
+// ?version= selects another version and "current" the latest one.
+func (h *RequestHandler) Validate(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var request models.Request
+	if err := h.db.Preload("ResourceType").First(&request, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	allowed, err := canViewRequest(h.db, c, &request)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	if request.Kind == models.RequestKindBlueprint {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": errBlueprintParent,
+		})
+	}
+
+	version := request.SchemaVersion
+	switch v := c.Query("version"); v {
+	case "":
+	case "current":
+		version = request.ResourceType.CurrentVersion
+	default:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid version",
+			})
+		}
+		version = n
+	}
+
+	schemaVersion, err := repository.SchemaVersion(h.db, *request.ResourceTypeID, version)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Schema version not found",
+		})
+	}
+
+	config, fieldErrors, err := schema.Validate(schemaVersion.ConfigSchema, request.Configuration)
+	if err != nil {
+		return configurationError(c, nil, err)
+	}
+	if fieldErrors == nil {
+		fieldErrors = []schema.FieldError{}
+	}
+
+	return c.JSON(fiber.Map{
+		"valid":          len(fieldErrors) == 0,
+		"schema_version": version,
+		"fields":         fieldErrors,
+		"configuration":  config,
+	})
+}
+
+// Rendered previews the terraform.tfvars.json a request is provisioned
+// with. ?format=raw returns the file itself.
+func (h *RequestHandler) Rendered(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var request models.Request
+	if err := h.db.Preload("Environment").First(&request, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	allowed, err := canViewRequest(h.db, c, &request)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	if request.Kind == models.RequestKindBlueprint {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": errBlueprintParent,
+		})
+	}
+
+	variables, err := repository.RequestVariables(h.db, &request)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if c.Query("format") == "raw" {
+		data, err := tfvars.Encode(variables)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to render variables",
+			})
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="terraform.tfvars.json"`)
+		return c.Send(data)
+	}
+
+	return c.JSON(fiber.Map{
+		"schema_version": request.SchemaVersion,
+		"variables":      variables,
+	})
+}
+
+// Budget shows how the request affects its team's budget in the request's
+// environment. The body is null when there is no budget to check against.
+func (h *RequestHandler) Budget(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var request models.Request
+	if err := h.db.First(&request, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	allowed, err := canViewRequest(h.db, c, &request)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	budget, err := repository.CheckBudget(h.db, &request)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check budget",
+		})
+	}
+	return c.JSON(budget)
+}
+
+// ImportInfracost stores Infracost JSON output for the request's plan, e.g.
+// from the CI run, so approvers see the priced diff before deciding. The
+// upload is kept apart from the cost budgets are checked with.
+func (h *RequestHandler) ImportInfracost(c *fiber.Ctx) error {
+	id := c.Params("id")
+	userID := middleware.GetUserID(c)
+	role := middleware.GetUserRole(c)
+
+	var request models.Request
+	if err := h.db.First(&request, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	if request.RequesterID != userID && role != models.RoleAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	if request.Kind == models.RequestKindBlueprint {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": errBlueprintParent,
+		})
+	}
+
+	switch request.Status {
+	case models.StatusApplied, models.StatusRejected, models.StatusCancelled:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Request is already closed",
+		})
+	}
+
+	planCost, err := cost.ParseInfracost(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	planCost.Source = cost.PlanCostSourceUpload
+
+	if err := repository.SaveImportedCost(h.db, request.ID, planCost); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save plan cost",
+		})
+	}
+
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "cost_import",
+		ResourceType: "request",
+		ResourceID:   &request.ID,
+		NewValues:    models.JSON{"delta": planCost.Delta, "monthly": planCost.Monthly},
+	})
+
+	return c.JSON(planCost)
+}
+
 // Update updates a request
 func (h *RequestHandler) Update(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -155,6 +673,17 @@ func (h *RequestHandler) Update(c *fiber.Ctx) error {
 		})
 	}
 
+	if request.Kind == models.RequestKindDecommission || request.Kind == models.RequestKindBlueprint {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Decommission and blueprint requests cannot be updated; delete it and file a new one",
+		})
+	}
+	if status, message := checkComponent(&request); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
 	var input CreateRequestInput
 	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -162,20 +691,87 @@ func (h *RequestHandler) Update(c *fiber.Ctx) error {
 		})
 	}
 
+	var rt models.ResourceType
+	if err := h.db.First(&rt, "id = ?", request.ResourceTypeID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Resource type not found",
+		})
+	}
+
+	var resource *models.Resource
+	if request.Kind == models.RequestKindModify && request.ResourceID != nil {
+		resource = &models.Resource{}
+		if err := h.db.First(resource, "id = ?", *request.ResourceID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Resource not found",
+			})
+		}
+		input.Configuration = schema.Merge(resource.Configuration, input.Configuration)
+		input.TeamID = nil // billed to the resource's team
+	}
+
+	config, fieldErrors, err := schema.Validate(rt.ConfigSchema, input.Configuration)
+	if err != nil || len(fieldErrors) > 0 {
+		return configurationError(c, fieldErrors, err)
+	}
+
+	if resource != nil {
+		if status, message := checkModification(resource, config); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": message,
+			})
+		}
+	}
+
+	if input.TeamID != nil {
+		teamID, err := h.resolveTeam(c, input.TeamID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		request.TeamID = teamID
+	}
+
+	oldConfig := request.Configuration
+
 	request.Title = input.Title
 	request.Description = input.Description
-	request.Configuration = input.Configuration
+	request.Configuration = config
+	request.SchemaVersion = rt.CurrentVersion
 	if input.Priority != "" {
 		request.Priority = input.Priority
 	}
 
+	var env models.Environment
+	if err := h.db.First(&env, "id = ?", request.EnvironmentID).Error; err == nil {
+		if request.Kind == models.RequestKindCreate {
+			hours, err := env.TTL(input.TTLHours)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			request.TTLHours = hours
+		}
+		h.estimateCost(c.Context(), &request, &env, &rt)
+	}
+
 	if err := h.db.Save(&request).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update request",
 		})
 	}
 
-	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").First(&request, "id = ?", request.ID)
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "update",
+		ResourceType: "request",
+		ResourceID:   &request.ID,
+		OldValues:    models.JSON{"configuration": oldConfig},
+		NewValues:    models.JSON{"configuration": request.Configuration},
+	})
+
+	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team").First(&request, "id = ?", request.ID)
 	return c.JSON(request)
 }
 
@@ -185,7 +781,8 @@ func (h *RequestHandler) Submit(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 
 	var request models.Request
-	if err := h.db.Preload("Environment").First(&request, "id = ?", id).Error; err != nil {
+	if err := h.db.Preload("Environment").Preload("Environment.ApprovalStages").Preload("ResourceType").
+		First(&request, "id = ?", id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Request not found",
 		})
@@ -197,32 +794,346 @@ func (h *RequestHandler) Submit(c *fiber.Ctx) error {
 		})
 	}
 
-	if request.Status != models.StatusDraft && request.Status != models.StatusPlanned {
+	if request.Status != models.StatusDraft {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Request must be in draft or planned status",
+			"error": "Only draft requests can be submitted",
+		})
+	}
+	if status, message := checkComponent(&request); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
 		})
 	}
 
+	budget, status, response := h.submit(c, &request)
+	if status != 0 {
+		return c.Status(status).JSON(response)
+	}
+
+	if request.Kind == models.RequestKindBlueprint {
+		h.loadBlueprintRequest(&request)
+	} else {
+		h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team").First(&request, "id = ?", request.ID)
+	}
+	request.Budget = budget
+	return c.JSON(request)
+}
+
+// submit checks a draft request against its environment, schema
+// and budget and sends it to the first approval stage, or straight to the
+// provisioner when the environment needs no approval. The request must be
+// loaded with its environment, approval stages and resource type. On
+// failure it returns the status and body to respond with.
+func (h *RequestHandler) submit(c *fiber.Ctx, request *models.Request) (*models.BudgetStatus, int, fiber.Map) {
+	// Resources in an inactive environment can still be torn down
+	if !request.Environment.IsActive && request.Kind != models.RequestKindDecommission {
+		return nil, fiber.StatusBadRequest, fiber.Map{"error": "Environment is not active"}
+	}
+
+	if request.Kind == models.RequestKindModify || request.Kind == models.RequestKindDecommission {
+		if status, message := h.checkTarget(request); status != 0 {
+			return nil, status, fiber.Map{"error": message}
+		}
+	}
+
+	switch request.Kind {
+	case models.RequestKindDecommission:
+		if status, message := h.checkProtection(request); status != 0 {
+			return nil, status, fiber.Map{"error": message}
+		}
+	case models.RequestKindBlueprint:
+		if status, response := h.prepareBlueprint(c, request); status != 0 {
+			return nil, status, response
+		}
+	default:
+		// The schema may have changed since the draft was saved
+		config, fieldErrors, err := schema.Validate(request.ResourceType.ConfigSchema, request.Configuration)
+		if err != nil || len(fieldErrors) > 0 {
+			status, response := configurationErrorResponse(fieldErrors, err)
+			return nil, status, response
+		}
+		request.Configuration = config
+		request.SchemaVersion = request.ResourceType.CurrentVersion
+		h.estimateCost(c.Context(), request, request.Environment, request.ResourceType)
+	}
+
+	budget, err := repository.CheckBudget(h.db, request)
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to check budget"}
+	}
+	if budget != nil && budget.Blocked {
+		return nil, fiber.StatusConflict, fiber.Map{
+			"error":  "Request would exceed the team's monthly budget",
+			"budget": budget,
+		}
+	}
+
 	now := time.Now()
 	request.SubmittedAt = &now
+	from := request.Status
 
 	// If environment requires approval, set to pending
-	if request.Environment.RequiresApproval {
+	stages := workflow.Stages(*request.Environment)
+	if len(stages) > 0 {
 		request.Status = models.StatusPending
 	} else {
 		request.Status = models.StatusApproved
 	}
 
-	if err := h.db.Save(&request).Error; err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := moveStatus(tx, request.ID, from, request.Status); err != nil {
+			return err
+		}
+		if err := tx.Save(request).Error; err != nil {
+			return err
+		}
+		if err := syncComponents(tx, request); err != nil {
+			return err
+		}
+		if request.Status != models.StatusPending {
+			return nil
+		}
+		approval := workflow.NewApproval(*request, stages[0])
+		return tx.Create(&approval).Error
+	})
+	if errors.Is(err, errStatusChanged) {
+		return nil, fiber.StatusConflict, fiber.Map{"error": err.Error()}
+	}
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to submit request"}
+	}
+	h.events.Publish(request.ID, events.TypeStatus, events.StatusChange{From: from, To: request.Status})
+
+	if budget != nil && budget.Exceeded {
+		values, _ := toJSON(budget)
+		recordAudit(h.db, c, models.AuditLog{
+			Action:       "budget_exceeded",
+			ResourceType: "request",
+			ResourceID:   &request.ID,
+			NewValues:    values,
+		})
+	}
+
+	if request.Status == models.StatusApproved {
+		h.engine.Start(request.ID)
+	}
+
+	return budget, 0, nil
+}
+
+// Promote clones an applied request into the environment its environment
+// promotes to, applies that environment's overrides and submits the clone
+// to its approval policy. If the clone cannot be submitted, for example
+// because it would exceed the team's budget, it is left as a draft there.
+func (h *RequestHandler) Promote(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	var source models.Request
+	if err := h.db.Preload("Environment").Preload("ResourceType").
+		First(&source, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	if source.RequesterID != userID && middleware.GetUserRole(c) != models.RoleAdmin {
+		member := false
+		if source.TeamID != nil {
+			member, _ = repository.IsTeamMember(h.db, *source.TeamID, userID)
+		}
+		if !member {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the requester, their team or an admin can promote a request",
+			})
+		}
+	}
+
+	if source.Kind != models.RequestKindCreate || source.Status != models.StatusApplied {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Only applied create requests can be promoted",
+		})
+	}
+	if status, message := checkComponent(&source); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+	if source.Environment.NextEnvironmentID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": source.Environment.DisplayName + " does not promote to another environment",
+		})
+	}
+
+	var env models.Environment
+	if err := h.db.Preload("ApprovalStages").
+		First(&env, "id = ?", *source.Environment.NextEnvironmentID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Next environment not found",
+		})
+	}
+	if !env.IsActive {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Environment is not active",
+		})
+	}
+
+	var existing models.Request
+	err := h.db.Where("promoted_from_id = ? AND status NOT IN ?", source.ID,
+		[]string{models.StatusRejected, models.StatusCancelled}).First(&existing).Error
+	if err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":      "Request has already been promoted",
+			"request_id": existing.ID,
+		})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check promotions",
+		})
+	}
+
+	rt := source.ResourceType
+	config, fieldErrors, err := schema.Validate(rt.ConfigSchema,
+		schema.Merge(source.Configuration, env.PromotionOverride(rt.Name)))
+	if err != nil || len(fieldErrors) > 0 {
+		return configurationError(c, fieldErrors, err)
+	}
+
+	// Keep the TTL where the next environment allows it
+	ttlHours, err := env.TTL(source.TTLHours)
+	if err != nil {
+		ttlHours = env.MaxTTLHours
+	}
+
+	request := models.Request{
+		Title:          source.Title,
+		Description:    source.Description,
+		Kind:           models.RequestKindCreate,
+		RequesterID:    userID,
+		EnvironmentID:  env.ID,
+		ResourceTypeID: &rt.ID,
+		TeamID:         source.TeamID,
+		Configuration:  config,
+		SchemaVersion:  rt.CurrentVersion,
+		Status:         models.StatusDraft,
+		Priority:       source.Priority,
+		TTLHours:       ttlHours,
+		PromotedFromID: &source.ID,
+	}
+	if err := h.db.Create(&request).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to submit request",
+			"error": "Failed to create request",
 		})
 	}
 
-	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").First(&request, "id = ?", request.ID)
+	changes, _ := schema.Diff(source.Configuration, config)
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "promote",
+		ResourceType: "request",
+		ResourceID:   &request.ID,
+		NewValues: models.JSON{
+			"promoted_from_id": source.ID,
+			"environment_id":   env.ID,
+			"configuration":    request.Configuration,
+			"overrides":        changes,
+		},
+	})
+
+	request.Environment = &env
+	request.ResourceType = rt
+	budget, status, response := h.submit(c, &request)
+	if status != 0 {
+		response["request_id"] = request.ID
+		return c.Status(status).JSON(response)
+	}
+
+	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team").
+		Preload("PromotedFrom").First(&request, "id = ?", request.ID)
+	request.Budget = budget
+	return c.Status(fiber.StatusCreated).JSON(request)
+}
+
+// Withdraw pulls a pending request back to draft so it can be edited
+func (h *RequestHandler) Withdraw(c *fiber.Ctx) error {
+	id := c.Params("id")
+	userID := middleware.GetUserID(c)
+
+	var request models.Request
+	if err := h.db.First(&request, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	if request.RequesterID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You can only withdraw your own requests",
+		})
+	}
+
+	if request.Status != models.StatusPending {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Only pending requests can be withdrawn",
+		})
+	}
+	if status, message := checkComponent(&request); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	request.Status = models.StatusDraft
+	request.SubmittedAt = nil
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&request).Error; err != nil {
+			return err
+		}
+		if err := syncComponents(tx, &request); err != nil {
+			return err
+		}
+		return closePendingApprovals(tx, request.ID)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to withdraw request",
+		})
+	}
+	h.events.Publish(request.ID, events.TypeStatus, events.StatusChange{From: models.StatusPending, To: models.StatusDraft})
+
+	h.db.Preload("Requester").Preload("Environment").Preload("ResourceType").Preload("Team").First(&request, "id = ?", request.ID)
 	return c.JSON(request)
 }
 
+// Provision starts (or retries) plan and apply for an approved request
+func (h *RequestHandler) Provision(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var request models.Request
+	if err := h.db.First(&request, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Request not found",
+		})
+	}
+
+	if !provisioner.Provisionable(request.Status) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Request must be in approved or failed status",
+		})
+	}
+	if status, message := checkComponent(&request); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	h.engine.Start(request.ID)
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Provisioning started",
+	})
+}
+
 // Delete cancels/deletes a request
 func (h *RequestHandler) Delete(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -242,15 +1153,52 @@ func (h *RequestHandler) Delete(c *fiber.Ctx) error {
 			"error": "You can only delete your own requests",
 		})
 	}
+	if status, message := checkComponent(&request); status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
 
-	// Can only delete draft or rejected requests
+	// Drafts and rejected requests are deleted; anything else is cancelled
 	if request.Status != models.StatusDraft && request.Status != models.StatusRejected {
+		if status, message := checkCancel(&request); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": message,
+			})
+		}
+
+		from := request.Status
 		request.Status = models.StatusCancelled
-		h.db.Save(&request)
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			if err := moveStatus(tx, request.ID, from, request.Status); err != nil {
+				return err
+			}
+			if err := syncComponents(tx, &request); err != nil {
+				return err
+			}
+			return closePendingApprovals(tx, request.ID)
+		})
+		if errors.Is(err, errStatusChanged) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to cancel request",
+			})
+		}
+		h.events.Publish(request.ID, events.TypeStatus, events.StatusChange{From: from, To: models.StatusCancelled})
 		return c.JSON(fiber.Map{"message": "Request cancelled"})
 	}
 
-	if err := h.db.Delete(&request).Error; err != nil {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("parent_id = ?", request.ID).Delete(&models.Request{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&request).Error
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete request",
 		})
@@ -258,3 +1206,205 @@ func (h *RequestHandler) Delete(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{"message": "Request deleted"})
 }
+
+// configurationError responds with the field-level validation errors for a
+// request configuration, or a server error if the schema is unusable
+func configurationError(c *fiber.Ctx, fieldErrors []schema.FieldError, err error) error {
+	status, response := configurationErrorResponse(fieldErrors, err)
+	return c.Status(status).JSON(response)
+}
+
+// configurationErrorResponse returns the status and body configurationError
+// responds with
+func configurationErrorResponse(fieldErrors []schema.FieldError, err error) (int, fiber.Map) {
+	if err != nil {
+		return fiber.StatusInternalServerError, fiber.Map{
+			"error": "Resource type has an invalid configuration schema",
+		}
+	}
+	return fiber.StatusUnprocessableEntity, fiber.Map{
+		"error":  "Invalid configuration",
+		"fields": fieldErrors,
+	}
+}
+
+// errStatusChanged is returned when a request changed status while it was
+// being updated, for example because the provisioner picked it up
+var errStatusChanged = errors.New("Request status changed; reload it and try again")
+
+// moveStatus moves a request from one status to another, failing with
+// errStatusChanged if it is no longer in from. Inside a transaction this
+// also locks the request until the transaction ends.
+func moveStatus(tx *gorm.DB, requestID uuid.UUID, from, to string) error {
+	result := tx.Model(&models.Request{}).
+		Where("id = ? AND status = ?", requestID, from).
+		Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errStatusChanged
+	}
+	return nil
+}
+
+// checkCancel refuses to cancel requests the provisioner is working on or
+// has finished. Cancelling those would leave Terraform running, or a live
+// resource, behind a cancelled request.
+func checkCancel(request *models.Request) (int, string) {
+	switch request.Status {
+	case models.StatusPlanning, models.StatusApplying:
+		return fiber.StatusConflict, "Request is being provisioned; wait for the run to finish"
+	case models.StatusApplied:
+		return fiber.StatusConflict, "Request has been applied; file a decommission request to remove the resource"
+	case models.StatusCancelled:
+		return fiber.StatusConflict, "Request is already cancelled"
+	}
+	return 0, ""
+}
+
+// checkModification refuses a modify request whose configuration is the
+// resource's current one, since applying it would change nothing
+func checkModification(resource *models.Resource, config models.JSON) (int, string) {
+	changes, err := schema.Diff(resource.Configuration, config)
+	if err != nil {
+		return fiber.StatusInternalServerError, "Failed to compare the configuration with the resource"
+	}
+	if len(changes) == 0 {
+		return fiber.StatusBadRequest, "The configuration does not change the resource"
+	}
+	return 0, ""
+}
+
+// closePendingApprovals cancels any approvals still waiting on a decision
+func closePendingApprovals(tx *gorm.DB, requestID uuid.UUID) error {
+	return tx.Model(&models.Approval{}).
+		Where("request_id = ? AND status = ?", requestID, models.ApprovalPending).
+		Update("status", models.ApprovalCancelled).Error
+}
+
+// targetResource loads an active resource the caller may change: its owner,
+// a member of its team or an admin
+func (h *RequestHandler) targetResource(c *fiber.Ctx, resourceID uuid.UUID) (*models.Resource, error) {
+	userID := middleware.GetUserID(c)
+
+	var resource models.Resource
+	if err := h.db.First(&resource, "id = ?", resourceID).Error; err != nil {
+		return nil, errors.New("Resource not found")
+	}
+	if resource.Status != models.ResourceActive {
+		return nil, errors.New("Resource is not active")
+	}
+	if resource.OwnerID == userID || middleware.GetUserRole(c) == models.RoleAdmin {
+		return &resource, nil
+	}
+	if resource.TeamID != nil {
+		if member, err := repository.IsTeamMember(h.db, *resource.TeamID, userID); err == nil && member {
+			return &resource, nil
+		}
+	}
+	return nil, errors.New("You can only change your own or your team's resources")
+}
+
+// checkTarget makes sure the resource a request changes is still active and
+// not being changed by another request. It returns the response status and
+// message when it is not.
+func (h *RequestHandler) checkTarget(request *models.Request) (int, string) {
+	if request.ResourceID == nil {
+		return fiber.StatusBadRequest, "Request has no target resource"
+	}
+
+	var resource models.Resource
+	if err := h.db.First(&resource, "id = ?", *request.ResourceID).Error; err != nil {
+		return fiber.StatusBadRequest, "Resource not found"
+	}
+	if resource.Status != models.ResourceActive {
+		return fiber.StatusBadRequest, "Resource is not active"
+	}
+
+	open, err := repository.OpenResourceRequest(h.db, resource.ID, request.ID)
+	if err != nil {
+		return fiber.StatusInternalServerError, "Failed to check the resource"
+	}
+	if open != nil {
+		return fiber.StatusConflict, "Another request is already changing this resource"
+	}
+	return 0, ""
+}
+
+// resolveTeam returns the team a request is billed to. Without an explicit
+// team, the requester's team is used if they belong to exactly one.
+func (h *RequestHandler) resolveTeam(c *fiber.Ctx, teamID *uuid.UUID) (*uuid.UUID, error) {
+	userID := middleware.GetUserID(c)
+
+	if teamID == nil {
+		teams, err := repository.UserTeams(h.db, userID)
+		if err != nil || len(teams) != 1 {
+			return nil, nil
+		}
+		return &teams[0].ID, nil
+	}
+
+	var team models.Team
+	if err := h.db.First(&team, "id = ?", *teamID).Error; err != nil {
+		return nil, errors.New("Team not found")
+	}
+	if !team.IsActive {
+		return nil, errors.New("Team is not active")
+	}
+	if middleware.GetUserRole(c) != models.RoleAdmin {
+		member, err := repository.IsTeamMember(h.db, team.ID, userID)
+		if err != nil || !member {
+			return nil, errors.New("You are not a member of this team")
+		}
+	}
+	return &team.ID, nil
+}
+
+// estimateCost prices the request's rendered variables. A failed estimate is
+// logged and leaves the previous one in place; it never blocks the request.
+func (h *RequestHandler) estimateCost(ctx context.Context, request *models.Request, env *models.Environment, rt *models.ResourceType) {
+	if h.estimator == nil {
+		return
+	}
+
+	rendered := *request
+	rendered.Environment = env
+	variables, err := repository.RequestVariables(h.db, &rendered)
+	if err != nil {
+		log.Printf("Cost estimate for request %s skipped: %v", request.ID, err)
+		return
+	}
+
+	estimate, err := h.estimator.Estimate(ctx, cost.Input{
+		ModulePath: rt.ModulePath,
+		Region:     env.Region,
+		BaseCost:   rt.BaseCost,
+		Variables:  variables,
+	})
+	if err != nil {
+		log.Printf("Cost estimate for request %s failed: %v", request.ID, err)
+		return
+	}
+
+	breakdown, err := toJSON(estimate)
+	if err != nil {
+		log.Printf("Cost estimate for request %s failed: %v", request.ID, err)
+		return
+	}
+	request.EstimatedCost = estimate.Monthly
+	request.CostBreakdown = breakdown
+}
+
+// toJSON converts a struct to a models.JSON for storing in a jsonb column
+func toJSON(v interface{}) (models.JSON, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out models.JSON
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
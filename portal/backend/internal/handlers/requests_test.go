@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDeleteCancelsRequests(t *testing.T) {
+	tests := []struct {
+		status string
+		code   int
+		after  string
+	}{
+		{models.StatusDraft, fiber.StatusOK, ""},
+		{models.StatusRejected, fiber.StatusOK, ""},
+		{models.StatusPending, fiber.StatusOK, models.StatusCancelled},
+		{models.StatusApproved, fiber.StatusOK, models.StatusCancelled},
+		{models.StatusPlanned, fiber.StatusOK, models.StatusCancelled},
+		{models.StatusFailed, fiber.StatusOK, models.StatusCancelled},
+		{models.StatusPlanning, fiber.StatusConflict, models.StatusPlanning},
+		{models.StatusApplying, fiber.StatusConflict, models.StatusApplying},
+		{models.StatusApplied, fiber.StatusConflict, models.StatusApplied},
+		{models.StatusCancelled, fiber.StatusConflict, models.StatusCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			f := newFixture(t)
+			requester := f.user("jane", models.RoleUser)
+			request := f.request(requester, tt.status, nil)
+			app := f.app(requester, http.MethodDelete, "/requests/:id", NewRequestHandler(f.db, nil, nil, nil).Delete)
+
+			if code := call(t, app, http.MethodDelete, "/requests/"+request.ID.String(), nil, nil); code != tt.code {
+				t.Fatalf("expected %d, got %d", tt.code, code)
+			}
+
+			var after models.Request
+			err := f.db.First(&after, "id = ?", request.ID).Error
+			if tt.after == "" {
+				if err == nil {
+					t.Error("expected the request to be deleted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Status != tt.after {
+				t.Errorf("expected status %s, got %s", tt.after, after.Status)
+			}
+		})
+	}
+}
+
+func TestSubmitOnlyAcceptsDrafts(t *testing.T) {
+	for _, status := range []string{models.StatusPlanned, models.StatusRejected, models.StatusApplying} {
+		t.Run(status, func(t *testing.T) {
+			f := newFixture(t)
+			requester := f.user("jane", models.RoleUser)
+			request := f.request(requester, status, nil)
+			app := f.app(requester, http.MethodPost, "/requests/:id/submit", NewRequestHandler(f.db, nil, nil, nil).Submit)
+
+			if code := call(t, app, http.MethodPost, "/requests/"+request.ID.String()+"/submit", nil, nil); code != fiber.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", code)
+			}
+		})
+	}
+}
+
+func TestMoveStatus(t *testing.T) {
+	f := newFixture(t)
+	request := f.request(f.user("jane", models.RoleUser), models.StatusApproved, nil)
+
+	if err := moveStatus(f.db, request.ID, models.StatusApproved, models.StatusCancelled); err != nil {
+		t.Fatalf("moveStatus failed: %v", err)
+	}
+	// The provisioner lost the race
+	if err := moveStatus(f.db, request.ID, models.StatusApproved, models.StatusPlanning); !errors.Is(err, errStatusChanged) {
+		t.Errorf("expected errStatusChanged, got %v", err)
+	}
+}
+
+func TestRequestViewsFollowRequestAccess(t *testing.T) {
+	f := newFixture(t)
+	requester := f.user("jane", models.RoleUser)
+	member := f.user("joe", models.RoleUser)
+	outsider := f.user("eve", models.RoleUser)
+	team := f.team("payments", requester, member)
+	request := f.request(requester, models.StatusDraft, &team)
+	f.create(&models.ResourceTypeVersion{
+		ResourceTypeID: f.resourceType.ID,
+		ConfigSchema:   f.resourceType.ConfigSchema,
+		Checksum:       "test",
+		Source:         models.SchemaSourceSeed,
+	})
+
+	handler := NewRequestHandler(f.db, nil, nil, nil)
+	views := map[string]fiber.Handler{
+		"changes":  handler.Changes,
+		"validate": handler.Validate,
+		"rendered": handler.Rendered,
+		"budget":   handler.Budget,
+	}
+	users := []struct {
+		user models.User
+		code int
+	}{
+		{requester, fiber.StatusOK},
+		{member, fiber.StatusOK},
+		{outsider, fiber.StatusForbidden},
+	}
+
+	for view, h := range views {
+		for _, u := range users {
+			t.Run(view+"/"+u.user.Name, func(t *testing.T) {
+				app := f.app(u.user, http.MethodGet, "/requests/:id/"+view, h)
+				if code := call(t, app, http.MethodGet, "/requests/"+request.ID.String()+"/"+view, nil, nil); code != u.code {
+					t.Errorf("expected %d, got %d", u.code, code)
+				}
+			})
+		}
+	}
+}
+
+func TestListShowsTeamRequests(t *testing.T) {
+	f := newFixture(t)
+	requester := f.user("jane", models.RoleUser)
+	member := f.user("joe", models.RoleUser)
+	outsider := f.user("eve", models.RoleUser)
+	approver := f.user("amy", models.RoleApprover)
+	team := f.team("payments", requester, member)
+	shared := f.request(requester, models.StatusPending, &team)
+	personal := f.request(requester, models.StatusPending, nil)
+	own := f.request(member, models.StatusDraft, nil)
+
+	handler := NewRequestHandler(f.db, nil, nil, nil)
+	tests := []struct {
+		user     models.User
+		expected []models.Request
+	}{
+		{requester, []models.Request{shared, personal}},
+		{member, []models.Request{shared, own}},
+		{outsider, nil},
+		{approver, []models.Request{shared, personal, own}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user.Name, func(t *testing.T) {
+			var listed []models.Request
+			app := f.app(tt.user, http.MethodGet, "/requests", handler.List)
+			if code := call(t, app, http.MethodGet, "/requests", nil, &listed); code != fiber.StatusOK {
+				t.Fatalf("expected 200, got %d", code)
+			}
+
+			ids := map[string]bool{}
+			for _, request := range listed {
+				ids[request.ID.String()] = true
+			}
+			if len(ids) != len(tt.expected) {
+				t.Errorf("expected %d requests, got %d", len(tt.expected), len(ids))
+			}
+			for _, request := range tt.expected {
+				if !ids[request.ID.String()] {
+					t.Errorf("expected request %s in the list", request.ID)
+				}
+			}
+		})
+	}
+}
+
+const infracostUpload = `{
+  "currency": "USD",
+  "projects": [{"breakdown": {"resources": [
+    {"name": "google_redis_instance.main", "resourceType": "google_redis_instance", "monthlyCost": "0.01"}
+  ]}}],
+  "totalMonthlyCost": "0.01",
+  "pastTotalMonthlyCost": "0",
+  "diffTotalMonthlyCost": "0.01"
+}`
+
+func TestImportInfracostDoesNotChangeBudgetCost(t *testing.T) {
+	f := newFixture(t)
+	requester := f.user("jane", models.RoleUser)
+	approver := f.user("ann", models.RoleApprover)
+	request := f.request(requester, models.StatusPending, nil)
+	f.db.Model(&request).Update("estimated_cost", 120)
+	approval := models.Approval{RequestID: request.ID, Stage: 1, Status: models.ApprovalPending}
+	f.create(&approval)
+
+	app := f.app(requester, http.MethodPost, "/requests/:id/infracost", NewRequestHandler(f.db, nil, nil, nil).ImportInfracost)
+	req := httptest.NewRequest(http.MethodPost, "/requests/"+request.ID.String()+"/infracost", strings.NewReader(infracostUpload))
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var after models.Request
+	if err := f.db.First(&after, "id = ?", request.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if after.CostDelta != nil || after.MonthlyCost() != 120 {
+		t.Errorf("an upload should not change the budgeted cost, got delta %v and cost %v", after.CostDelta, after.MonthlyCost())
+	}
+
+	// Approvers see the upload on the approval
+	var shown models.Approval
+	app = f.app(approver, http.MethodGet, "/approvals/:id", NewApprovalHandler(f.db, nil, nil).Get)
+	if code := call(t, app, http.MethodGet, "/approvals/"+approval.ID.String(), nil, &shown); code != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if shown.Request == nil || shown.Request.ImportedCost["delta"] != 0.01 {
+		t.Errorf("expected the imported cost on the approval, got %+v", shown.Request)
+	}
+}
+
+func TestModifyMustChangeTheResource(t *testing.T) {
+	f := newFixture(t)
+	owner := f.user("owner", models.RoleUser)
+	resource := f.resource(owner, nil)
+	handler := NewRequestHandler(f.db, nil, nil, nil)
+
+	create := f.app(owner, http.MethodPost, "/requests", handler.Create)
+	update := f.app(owner, http.MethodPut, "/requests/:id", handler.Update)
+	modify := func(config map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"kind":          models.RequestKindModify,
+			"title":         "resize",
+			"resource_id":   resource.ID,
+			"configuration": config,
+		}
+	}
+
+	// Sending the current value, or nothing, changes nothing
+	if code := call(t, create, http.MethodPost, "/requests", modify(map[string]interface{}{"memory_size_gb": 1}), nil); code != fiber.StatusBadRequest {
+		t.Errorf("create with the current configuration: expected 400, got %d", code)
+	}
+	if code := call(t, create, http.MethodPost, "/requests", modify(nil), nil); code != fiber.StatusBadRequest {
+		t.Errorf("create without changes: expected 400, got %d", code)
+	}
+
+	var draft models.Request
+	if code := call(t, create, http.MethodPost, "/requests", modify(map[string]interface{}{"memory_size_gb": 2}), &draft); code != fiber.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", code)
+	}
+
+	// Editing the draft back to the resource's configuration is refused too
+	target := "/requests/" + draft.ID.String()
+	if code := call(t, update, http.MethodPut, target, modify(map[string]interface{}{"memory_size_gb": 1}), nil); code != fiber.StatusBadRequest {
+		t.Errorf("update to the current configuration: expected 400, got %d", code)
+	}
+	if code := call(t, update, http.MethodPut, target, modify(map[string]interface{}{"memory_size_gb": 3}), nil); code != fiber.StatusOK {
+		t.Errorf("update: expected 200, got %d", code)
+	}
+}
@@ -1,25 +1,56 @@
 package handlers
 
 import (
+	"strconv"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/provisioner"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/schema"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfmodule"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 // ResourceTypeHandler handles resource type endpoints
 type ResourceTypeHandler struct {
-	db *gorm.DB
+	db         *gorm.DB
+	workspaces *provisioner.Workspaces
+}
+
+// NewResourceTypeHandler creates a new resource type handler. Module paths
+// are resolved through workspaces.
+func NewResourceTypeHandler(db *gorm.DB, workspaces *provisioner.Workspaces) *ResourceTypeHandler {
+	return &ResourceTypeHandler{db: db, workspaces: workspaces}
 }
 
-// NewResourceTypeHandler creates a new resource type handler
-func NewResourceTypeHandler(db *gorm.DB) *ResourceTypeHandler {
-	return &ResourceTypeHandler{db: db}
+// ResourceTypeInput represents input for creating or updating a resource
+// type. Omitted fields are left unchanged on update.
+type ResourceTypeInput struct {
+	Name         *string     `json:"name"`
+	DisplayName  *string     `json:"display_name"`
+	Description  *string     `json:"description"`
+	ModulePath   *string     `json:"module_path"`
+	ConfigSchema models.JSON `json:"config_schema"`
+	InputMapping models.JSON `json:"input_mapping"`
+	BaseCost     *float64    `json:"base_cost"`
+	IsActive     *bool       `json:"is_active"`
 }
 
-// List returns all resource types
+// List returns active resource types. Admins can pass all=true to include
+// inactive ones.
 func (h *ResourceTypeHandler) List(c *fiber.Ctx) error {
+	query := h.db.Order("name")
+	if c.Query("all") != "true" || middleware.GetUserRole(c) != models.RoleAdmin {
+		query = query.Where("is_active = ?", true)
+	}
+
 	var resourceTypes []models.ResourceType
-	if err := h.db.Where("is_active = ?", true).Find(&resourceTypes).Error; err != nil {
+	if err := query.Find(&resourceTypes).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch resource types",
 		})
@@ -41,7 +72,8 @@ func (h *ResourceTypeHandler) Get(c *fiber.Ctx) error {
 	return c.JSON(resourceType)
 }
 
-// GetSchema returns the configuration schema for a resource type
+// GetSchema returns the configuration schema for a resource type, or the
+// schema of an older version when ?version= is given
 func (h *ResourceTypeHandler) GetSchema(c *fiber.Ctx) error {
 	id := c.Params("id")
 
@@ -52,5 +84,287 @@ func (h *ResourceTypeHandler) GetSchema(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(resourceType.ConfigSchema)
+	if c.Query("version") == "" {
+		return c.JSON(resourceType.ConfigSchema)
+	}
+
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid version",
+		})
+	}
+	v, err := repository.SchemaVersion(h.db, resourceType.ID, version)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Schema version not found",
+		})
+	}
+
+	return c.JSON(v.ConfigSchema)
+}
+
+// ImportSchemaInput represents input for generating a schema from a module
+type ImportSchemaInput struct {
+	ModulePath string `json:"module_path"`
+}
+
+// ImportSchema generates a config schema and input mapping from a Terraform
+// module's variables. Nothing is saved; publish the result with Create or
+// Update.
+func (h *ResourceTypeHandler) ImportSchema(c *fiber.Ctx) error {
+	var input ImportSchemaInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+
+	dir, err := h.workspaces.ModuleDir(input.ModulePath)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	variables, err := tfmodule.Load(dir)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Failed to read module variables: " + err.Error(),
+		})
+	}
+
+	return c.JSON(tfmodule.Generate(variables))
+}
+
+// ListVersions returns every schema version of a resource type, newest first
+func (h *ResourceTypeHandler) ListVersions(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var resourceType models.ResourceType
+	if err := h.db.First(&resourceType, "id = ? OR name = ?", id, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Resource type not found",
+		})
+	}
+
+	var versions []models.ResourceTypeVersion
+	if err := h.db.Where("resource_type_id = ?", resourceType.ID).
+		Order("version DESC").Find(&versions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch schema versions",
+		})
+	}
+
+	return c.JSON(versions)
+}
+
+// Create creates a resource type with its first schema version
+func (h *ResourceTypeHandler) Create(c *fiber.Ctx) error {
+	var input ResourceTypeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name == nil || !slugPattern.MatchString(strings.TrimSpace(*input.Name)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name must start with a letter and contain only lowercase letters, digits and hyphens",
+		})
+	}
+	if input.ModulePath == nil || strings.TrimSpace(*input.ModulePath) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Module path is required",
+		})
+	}
+	if input.ConfigSchema == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Config schema is required",
+		})
+	}
+	if err := schema.Check(input.ConfigSchema); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := tfvars.Check(input.InputMapping, input.ConfigSchema); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	resourceType := models.ResourceType{
+		Name:     strings.TrimSpace(*input.Name),
+		IsActive: true,
+	}
+	applyResourceTypeInput(&resourceType, input)
+	if resourceType.DisplayName == "" {
+		resourceType.DisplayName = resourceType.Name
+	}
+
+	var count int64
+	h.db.Model(&models.ResourceType{}).Where("name = ?", resourceType.Name).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A resource type with this name already exists",
+		})
+	}
+
+	userID := middleware.GetUserID(c)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("config_schema", "input_mapping").Create(&resourceType).Error; err != nil {
+			return err
+		}
+		if !resourceType.IsActive {
+			if err := tx.Model(&resourceType).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		_, _, err := repository.PublishSchema(tx, &resourceType, input.ConfigSchema, input.InputMapping, models.SchemaSourceAdmin, &userID)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create resource type",
+		})
+	}
+
+	h.audit(c, "create", resourceType.ID, nil, resourceTypeValues(resourceType))
+
+	return c.Status(fiber.StatusCreated).JSON(resourceType)
+}
+
+// Update changes a resource type. A changed config schema or input mapping
+// is published as a new version; requests keep the version they were
+// validated against.
+func (h *ResourceTypeHandler) Update(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var resourceType models.ResourceType
+	if err := h.db.First(&resourceType, "id = ? OR name = ?", id, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Resource type not found",
+		})
+	}
+
+	var input ResourceTypeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name != nil && *input.Name != resourceType.Name {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Resource type name cannot be changed",
+		})
+	}
+	if input.ModulePath != nil && strings.TrimSpace(*input.ModulePath) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Module path is required",
+		})
+	}
+	publish := input.ConfigSchema != nil || input.InputMapping != nil
+	if input.ConfigSchema == nil {
+		input.ConfigSchema = resourceType.ConfigSchema
+	}
+	if input.InputMapping == nil {
+		input.InputMapping = resourceType.InputMapping
+	}
+	if publish {
+		if err := schema.Check(input.ConfigSchema); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err := tfvars.Check(input.InputMapping, input.ConfigSchema); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	before := resourceTypeValues(resourceType)
+	applyResourceTypeInput(&resourceType, input)
+	oldValues, newValues := diffValues(before, resourceTypeValues(resourceType))
+
+	userID := middleware.GetUserID(c)
+	var published *models.ResourceTypeVersion
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if len(newValues) > 0 {
+			if err := tx.Model(&resourceType).Updates(map[string]interface{}(newValues)).Error; err != nil {
+				return err
+			}
+		}
+		if !publish {
+			return nil
+		}
+		previous := resourceType.CurrentVersion
+		version, created, err := repository.PublishSchema(tx, &resourceType, input.ConfigSchema, input.InputMapping, models.SchemaSourceAdmin, &userID)
+		if err != nil {
+			return err
+		}
+		if created {
+			published = version
+			oldValues["schema_version"] = previous
+			newValues["schema_version"] = version.Version
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update resource type",
+		})
+	}
+
+	if len(newValues) > 0 {
+		h.audit(c, "update", resourceType.ID, oldValues, newValues)
+	}
+
+	return c.JSON(fiber.Map{
+		"resource_type":     resourceType,
+		"published_version": published,
+	})
+}
+
+func (h *ResourceTypeHandler) audit(c *fiber.Ctx, action string, resourceTypeID uuid.UUID, oldValues, newValues models.JSON) {
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       action,
+		ResourceType: "resource_type",
+		ResourceID:   &resourceTypeID,
+		OldValues:    oldValues,
+		NewValues:    newValues,
+	})
+}
+
+// applyResourceTypeInput copies the metadata fields that were provided onto
+// resourceType. The schema is published separately.
+func applyResourceTypeInput(resourceType *models.ResourceType, input ResourceTypeInput) {
+	if input.DisplayName != nil {
+		resourceType.DisplayName = strings.TrimSpace(*input.DisplayName)
+	}
+	if input.Description != nil {
+		resourceType.Description = *input.Description
+	}
+	if input.ModulePath != nil {
+		resourceType.ModulePath = strings.TrimSpace(*input.ModulePath)
+	}
+	if input.BaseCost != nil {
+		resourceType.BaseCost = *input.BaseCost
+	}
+	if input.IsActive != nil {
+		resourceType.IsActive = *input.IsActive
+	}
+}
+
+// resourceTypeValues returns the editable metadata of a resource type, keyed
+// by column name, for audit entries
+func resourceTypeValues(resourceType models.ResourceType) models.JSON {
+	return models.JSON{
+		"name":         resourceType.Name,
+		"display_name": resourceType.DisplayName,
+		"description":  resourceType.Description,
+		"module_path":  resourceType.ModulePath,
+		"base_cost":    resourceType.BaseCost,
+		"is_active":    resourceType.IsActive,
+	}
 }
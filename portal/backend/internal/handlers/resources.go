@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ResourceHandler handles the provisioned resource inventory
+type ResourceHandler struct {
+	db *gorm.DB
+}
+
+// NewResourceHandler creates a new resource handler
+func NewResourceHandler(db *gorm.DB) *ResourceHandler {
+	return &ResourceHandler{db: db}
+}
+
+// List returns provisioned resources. Non-admins see the resources they own
+// and those of their teams.
+func (h *ResourceHandler) List(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+	role := middleware.GetUserRole(c)
+
+	query := h.db.Preload("Environment").Preload("ResourceType").Preload("Owner").Preload("Team")
+
+	if role != models.RoleAdmin && role != models.RoleApprover {
+		query = query.Where("owner_id = ? OR team_id IN (?)", userID,
+			h.db.Model(&models.TeamMembership{}).Select("team_id").Where("user_id = ?", userID))
+	}
+
+	// Apply filters
+	if c.Query("mine") == "true" {
+		query = query.Where("owner_id = ?", userID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if envID := c.Query("environment_id"); envID != "" {
+		query = query.Where("environment_id = ?", envID)
+	}
+	if rtID := c.Query("resource_type_id"); rtID != "" {
+		query = query.Where("resource_type_id = ?", rtID)
+	}
+	if teamID := c.Query("team_id"); teamID != "" {
+		query = query.Where("team_id = ?", teamID)
+	}
+
+	var resources []models.Resource
+	if err := query.Order("created_at DESC").Find(&resources).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch resources",
+		})
+	}
+
+	return c.JSON(resources)
+}
+
+// Get returns a single resource with the request that created it
+func (h *ResourceHandler) Get(c *fiber.Ctx) error {
+	var resource models.Resource
+	if err := h.db.Preload("Environment").Preload("ResourceType").Preload("Owner").Preload("Team").
+		Preload("Request").
+		First(&resource, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Resource not found",
+		})
+	}
+
+	allowed, err := h.canView(c, &resource)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+		})
+	}
+
+	return c.JSON(resource)
+}
+
+// ExtendInput is the body of an extend request
+type ExtendInput struct {
+	Hours int `json:"hours"`
+}
+
+// Extend pushes back the expiry of a resource with a TTL. The extension is
+// capped at the environment's max TTL from now. A decommission filed
+// because the resource expired is cancelled if it has not started yet.
+func (h *ResourceHandler) Extend(c *fiber.Ctx) error {
+	var resource models.Resource
+	if err := h.db.Preload("Environment").First(&resource, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Resource not found",
+		})
+	}
+
+	allowed, err := h.canChange(c, &resource)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check access",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the owner, its team or an admin can extend a resource",
+		})
+	}
+
+	if resource.Status != models.ResourceActive {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Resource is not active",
+		})
+	}
+	if resource.ExpiresAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Resource does not expire",
+		})
+	}
+
+	var input ExtendInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+
+	expiresAt, err := resource.Environment.ExtendExpiry(*resource.ExpiresAt, input.Hours, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var expiryRequest *models.Request
+	if resource.ExpiryRequestID != nil {
+		var request models.Request
+		if err := h.db.First(&request, "id = ?", *resource.ExpiryRequestID).Error; err == nil {
+			switch request.Status {
+			case models.StatusPlanning, models.StatusApplying:
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Resource is already being decommissioned",
+				})
+			case models.StatusPending, models.StatusApproved, models.StatusPlanned, models.StatusFailed:
+				expiryRequest = &request
+			}
+		}
+	}
+
+	oldExpiresAt := *resource.ExpiresAt
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if expiryRequest != nil {
+			if err := tx.Model(expiryRequest).Update("status", models.StatusCancelled).Error; err != nil {
+				return err
+			}
+			if err := closePendingApprovals(tx, expiryRequest.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&resource).Updates(map[string]interface{}{
+			"expires_at":        expiresAt,
+			"expiry_warned_at":  nil,
+			"expiry_request_id": nil,
+		}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to extend resource",
+		})
+	}
+
+	newValues := models.JSON{"expires_at": expiresAt}
+	if expiryRequest != nil {
+		newValues["cancelled_request_id"] = expiryRequest.ID
+	}
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       "extend",
+		ResourceType: "resource",
+		ResourceID:   &resource.ID,
+		OldValues:    models.JSON{"expires_at": oldExpiresAt},
+		NewValues:    newValues,
+	})
+
+	h.db.Preload("Environment").Preload("ResourceType").Preload("Owner").Preload("Team").First(&resource, "id = ?", resource.ID)
+	return c.JSON(resource)
+}
+
+// canView reports whether the caller owns the resource, belongs to its team
+// or is an approver or admin
+func (h *ResourceHandler) canView(c *fiber.Ctx, resource *models.Resource) (bool, error) {
+	userID := middleware.GetUserID(c)
+	role := middleware.GetUserRole(c)
+
+	if resource.OwnerID == userID || role == models.RoleAdmin || role == models.RoleApprover {
+		return true, nil
+	}
+	if resource.TeamID == nil {
+		return false, nil
+	}
+	return repository.IsTeamMember(h.db, *resource.TeamID, userID)
+}
+
+// canChange reports whether the caller owns the resource, belongs to its
+// team or is an admin
+func (h *ResourceHandler) canChange(c *fiber.Ctx, resource *models.Resource) (bool, error) {
+	userID := middleware.GetUserID(c)
+
+	if resource.OwnerID == userID || middleware.GetUserRole(c) == models.RoleAdmin {
+		return true, nil
+	}
+	if resource.TeamID == nil {
+		return false, nil
+	}
+	return repository.IsTeamMember(h.db, *resource.TeamID, userID)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestResourceAccess(t *testing.T) {
+	f := newFixture(t)
+	owner := f.user("jane", models.RoleUser)
+	member := f.user("joe", models.RoleUser)
+	outsider := f.user("eve", models.RoleUser)
+	approver := f.user("ann", models.RoleApprover)
+	team := f.team("payments", owner, member)
+
+	shared := f.resource(owner, &team)
+	private := f.resource(owner, nil)
+
+	tests := []struct {
+		name    string
+		user    models.User
+		visible map[string]bool
+	}{
+		{"owner", owner, map[string]bool{shared.Name: true, private.Name: true}},
+		{"team member", member, map[string]bool{shared.Name: true}},
+		{"outsider", outsider, map[string]bool{}},
+		{"approver", approver, map[string]bool{shared.Name: true, private.Name: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewResourceHandler(f.db)
+
+			var listed []models.Resource
+			app := f.app(tt.user, http.MethodGet, "/resources", handler.List)
+			if code := call(t, app, http.MethodGet, "/resources", nil, &listed); code != fiber.StatusOK {
+				t.Fatalf("list: expected 200, got %d", code)
+			}
+			if len(listed) != len(tt.visible) {
+				t.Errorf("expected %d resources, got %d", len(tt.visible), len(listed))
+			}
+			for _, resource := range listed {
+				if !tt.visible[resource.Name] {
+					t.Errorf("%s should not be listed", resource.Name)
+				}
+			}
+
+			app = f.app(tt.user, http.MethodGet, "/resources/:id", handler.Get)
+			for _, resource := range []models.Resource{shared, private} {
+				expected := fiber.StatusForbidden
+				if tt.visible[resource.Name] {
+					expected = fiber.StatusOK
+				}
+				if code := call(t, app, http.MethodGet, "/resources/"+resource.ID.String(), nil, nil); code != expected {
+					t.Errorf("get %s: expected %d, got %d", resource.Name, expected, code)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceChangesWithOpenRequest(t *testing.T) {
+	for _, kind := range []string{models.RequestKindModify, models.RequestKindDecommission} {
+		t.Run(kind, func(t *testing.T) {
+			f := newFixture(t)
+			owner := f.user("jane", models.RoleUser)
+			resource := f.resource(owner, nil)
+			f.db.Model(&f.environment).Update("requires_approval", true)
+
+			// A change that is waiting for approval
+			open := f.request(owner, models.StatusPending, nil)
+			open.Kind = models.RequestKindModify
+			open.ResourceID = &resource.ID
+			if err := f.db.Save(&open).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			handler := NewRequestHandler(f.db, f.engine(), nil, nil)
+
+			// Drafts may be prepared while another change is open...
+			var draft models.Request
+			body := map[string]interface{}{
+				"kind":          kind,
+				"title":         "another change",
+				"resource_id":   resource.ID,
+				"configuration": map[string]interface{}{"memory_size_gb": 2},
+			}
+			app := f.app(owner, http.MethodPost, "/requests", handler.Create)
+			if code := call(t, app, http.MethodPost, "/requests", body, &draft); code != fiber.StatusCreated {
+				t.Fatalf("create: expected 201, got %d", code)
+			}
+			if draft.Status != models.StatusDraft {
+				t.Fatalf("expected a draft, got %s", draft.Status)
+			}
+
+			// ...but only one can be in flight
+			app = f.app(owner, http.MethodPost, "/requests/:id/submit", handler.Submit)
+			if code := call(t, app, http.MethodPost, "/requests/"+draft.ID.String()+"/submit", nil, nil); code != fiber.StatusConflict {
+				t.Errorf("submit: expected 409, got %d", code)
+			}
+
+			// Once the open request is done the draft goes through
+			if err := f.db.Model(&open).Update("status", models.StatusRejected).Error; err != nil {
+				t.Fatal(err)
+			}
+			if code := call(t, app, http.MethodPost, "/requests/"+draft.ID.String()+"/submit", nil, nil); code != fiber.StatusOK {
+				t.Errorf("submit after the open request closed: expected 200, got %d", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/artifacts"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RunHandler handles the Terraform runs of a request
+type RunHandler struct {
+	db    *gorm.DB
+	store artifacts.Store
+}
+
+// NewRunHandler creates a new run handler. Artifacts are read from store.
+func NewRunHandler(db *gorm.DB, store artifacts.Store) *RunHandler {
+	return &RunHandler{db: db, store: store}
+}
+
+// List returns the runs of a request with their artifacts, newest first
+func (h *RunHandler) List(c *fiber.Ctx) error {
+	request, status, message := h.loadRequest(c)
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	var runs []models.Run
+	if err := h.db.Preload("Artifacts").Where("request_id = ?", request.ID).
+		Order("started_at DESC").Find(&runs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch runs",
+		})
+	}
+
+	return c.JSON(runs)
+}
+
+// Log returns the log chunks of a run after ?after= (a chunk sequence
+// number), so clients can follow a run in progress by polling with the last
+// seq they saw. ?format=raw returns the whole log as text.
+func (h *RunHandler) Log(c *fiber.Ctx) error {
+	run, status, message := h.loadRun(c)
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	after := 0
+	if v := c.Query("after"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid after",
+			})
+		}
+		after = n
+	}
+
+	var chunks []models.RunLogChunk
+	if err := h.db.Where("run_id = ? AND seq > ?", run.ID, after).
+		Order("seq").Find(&chunks).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch log",
+		})
+	}
+
+	if c.Query("format") == "raw" {
+		var log strings.Builder
+		for _, chunk := range chunks {
+			log.WriteString(chunk.Data)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		return c.SendString(log.String())
+	}
+
+	return c.JSON(fiber.Map{
+		"run":    run,
+		"chunks": chunks,
+	})
+}
+
+// Artifact downloads an artifact of a run
+func (h *RunHandler) Artifact(c *fiber.Ctx) error {
+	run, status, message := h.loadRun(c)
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	var artifact models.RunArtifact
+	if err := h.db.First(&artifact, "run_id = ? AND name = ?", run.ID, c.Params("name")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Artifact not found",
+		})
+	}
+
+	r, err := h.store.Open(c.Context(), artifact.StorageKey)
+	if errors.Is(err, artifacts.ErrNotFound) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "Artifact is no longer stored",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read artifact",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, artifact.ContentType)
+	c.Set(fiber.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="%s-%d-%s"`, run.Operation, run.Attempt, artifact.Name))
+	// Fiber closes the reader once the body has been sent
+	return c.SendStream(r, int(artifact.Size))
+}
+
+// loadRequest loads the request in :id and checks the caller may see it.
+// On failure it returns the status and message to respond with.
+func (h *RunHandler) loadRequest(c *fiber.Ctx) (*models.Request, int, string) {
+	var request models.Request
+	if err := h.db.First(&request, "id = ?", c.Params("id")).Error; err != nil {
+		return nil, fiber.StatusNotFound, "Request not found"
+	}
+
+	allowed, err := canViewRequest(h.db, c, &request)
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, "Failed to check access"
+	}
+	if !allowed {
+		return nil, fiber.StatusForbidden, "Access denied"
+	}
+	return &request, 0, ""
+}
+
+// loadRun loads the run in :runId of the request in :id
+func (h *RunHandler) loadRun(c *fiber.Ctx) (*models.Run, int, string) {
+	request, status, message := h.loadRequest(c)
+	if status != 0 {
+		return nil, status, message
+	}
+
+	var run models.Run
+	if err := h.db.First(&run, "id = ? AND request_id = ?", c.Params("runId"), request.ID).Error; err != nil {
+		return nil, fiber.StatusNotFound, "Run not found"
+	}
+	return &run, 0, ""
+}
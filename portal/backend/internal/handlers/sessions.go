@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// refreshTokenCookie carries the refresh token for browser clients
+const refreshTokenCookie = "refresh_token"
+
+// RefreshInput represents input for refreshing an access token. Browser
+// clients send the refresh token as a cookie instead.
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh rotates the refresh token and issues a new access token. Using a
+// refresh token that was already rotated revokes the whole session, since
+// it means the token was stolen or replayed.
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
+	token := c.Cookies(refreshTokenCookie)
+	if token == "" {
+		var input RefreshInput
+		if err := c.BodyParser(&input); err == nil {
+			token = input.RefreshToken
+		}
+	}
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Missing refresh token",
+		})
+	}
+
+	hash := hashToken(token)
+	now := time.Now()
+
+	var session models.Session
+	err := h.db.Preload("User").First(&session, "refresh_token_hash = ?", hash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		h.revokeReusedToken(c, hash)
+		h.clearRefreshCookie(c)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh session",
+		})
+	}
+
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) || session.User == nil || !session.User.IsActive {
+		h.clearRefreshCookie(c)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Session expired, please sign in again",
+		})
+	}
+
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh session",
+		})
+	}
+
+	// Only rotate if nobody else rotated this token in the meantime
+	result := h.db.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  hashToken(newToken),
+			"previous_token_hash": hash,
+			"last_used_at":        now,
+		})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh session",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
+	accessToken, err := h.generateJWT(*session.User, session.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+
+	h.setRefreshCookie(c, newToken, session.ExpiresAt)
+
+	return c.JSON(fiber.Map{
+		"access_token":  accessToken,
+		"refresh_token": newToken,
+		"expires_in":    int(h.cfg.AccessTokenTTL.Seconds()),
+	})
+}
+
+// startSession creates a session for a user who just signed in and sets
+// the refresh token cookie
+func (h *AuthHandler) startSession(c *fiber.Ctx, user models.User) (*models.Session, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(token),
+		ExpiresAt:        time.Now().Add(h.cfg.RefreshTokenTTL),
+		IPAddress:        c.IP(),
+		UserAgent:        c.Get("User-Agent"),
+	}
+	if err := h.db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	h.setRefreshCookie(c, token, session.ExpiresAt)
+	return &session, nil
+}
+
+// revokeReusedToken revokes the session a rotated refresh token belonged to
+func (h *AuthHandler) revokeReusedToken(c *fiber.Ctx, hash string) {
+	var session models.Session
+	if err := h.db.First(&session, "previous_token_hash = ?", hash).Error; err != nil {
+		return
+	}
+
+	now := time.Now()
+	h.db.Model(&session).Update("revoked_at", now)
+
+	recordAudit(h.db, c, models.AuditLog{
+		UserID:       &session.UserID,
+		Action:       "refresh_token_reuse",
+		ResourceType: "session",
+		ResourceID:   &session.ID,
+	})
+}
+
+func (h *AuthHandler) setRefreshCookie(c *fiber.Ctx, token string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    token,
+		Path:     "/api/auth",
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   h.cfg.Env == "production",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+func (h *AuthHandler) clearRefreshCookie(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     refreshTokenCookie,
+		Path:     "/api/auth",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   h.cfg.Env == "production",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a refresh token for storage. Refresh tokens are random,
+// so a plain SHA-256 is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TeamHandler handles team, membership and budget endpoints
+type TeamHandler struct {
+	db *gorm.DB
+}
+
+// NewTeamHandler creates a new team handler
+func NewTeamHandler(db *gorm.DB) *TeamHandler {
+	return &TeamHandler{db: db}
+}
+
+// TeamInput represents input for creating or updating a team. Omitted
+// fields are left unchanged on update.
+type TeamInput struct {
+	Name        *string `json:"name"`
+	DisplayName *string `json:"display_name"`
+	CostCenter  *string `json:"cost_center"`
+	IsActive    *bool   `json:"is_active"`
+}
+
+// BudgetInput represents input for setting a team's budget in an environment
+type BudgetInput struct {
+	MonthlyAmount float64 `json:"monthly_amount"`
+	Policy        string  `json:"policy"`
+}
+
+// BudgetUsage is a budget with the spend currently committed against it
+type BudgetUsage struct {
+	models.Budget
+	Committed float64 `json:"committed"`
+	Remaining float64 `json:"remaining"`
+}
+
+// List returns active teams. mine=true limits the list to the caller's
+// teams; admins can pass all=true to include inactive ones.
+func (h *TeamHandler) List(c *fiber.Ctx) error {
+	query := h.db.Order("name")
+	if c.Query("all") != "true" || middleware.GetUserRole(c) != models.RoleAdmin {
+		query = query.Where("is_active = ?", true)
+	}
+	if c.Query("mine") == "true" {
+		query = query.Where("id IN (?)", h.db.Model(&models.TeamMembership{}).
+			Select("team_id").Where("user_id = ?", middleware.GetUserID(c)))
+	}
+
+	var teams []models.Team
+	if err := query.Find(&teams).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch teams",
+		})
+	}
+	return c.JSON(teams)
+}
+
+// Get returns a team with its members and budgets
+func (h *TeamHandler) Get(c *fiber.Ctx) error {
+	var team models.Team
+	if err := h.db.Preload("Members.User").Preload("Budgets.Environment").
+		First(&team, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Team not found",
+		})
+	}
+	return c.JSON(team)
+}
+
+// Create creates a new team
+func (h *TeamHandler) Create(c *fiber.Ctx) error {
+	var input TeamInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required",
+		})
+	}
+
+	team := models.Team{
+		Name:     strings.TrimSpace(*input.Name),
+		IsActive: true,
+	}
+	applyTeamInput(&team, input)
+	if team.DisplayName == "" {
+		team.DisplayName = team.Name
+	}
+	if !slugPattern.MatchString(team.Name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name must start with a letter and contain only lowercase letters, digits and hyphens",
+		})
+	}
+
+	var count int64
+	h.db.Model(&models.Team{}).Where("name = ?", team.Name).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A team with this name already exists",
+		})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&team).Error; err != nil {
+			return err
+		}
+		// is_active defaults to true, so an inactive team needs an
+		// explicit update
+		if !team.IsActive {
+			return tx.Model(&team).Update("is_active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create team",
+		})
+	}
+
+	h.audit(c, "create", team.ID, nil, teamValues(team))
+
+	return c.Status(fiber.StatusCreated).JSON(team)
+}
+
+// Update changes a team's display name, cost center or active flag
+func (h *TeamHandler) Update(c *fiber.Ctx) error {
+	var team models.Team
+	if err := h.db.First(&team, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Team not found",
+		})
+	}
+
+	var input TeamInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Name != nil && *input.Name != team.Name {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Team name cannot be changed",
+		})
+	}
+
+	before := teamValues(team)
+	applyTeamInput(&team, input)
+	oldValues, newValues := diffValues(before, teamValues(team))
+	if len(newValues) == 0 {
+		return c.JSON(team)
+	}
+
+	if err := h.db.Model(&team).Updates(map[string]interface{}(newValues)).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update team",
+		})
+	}
+
+	h.audit(c, "update", team.ID, oldValues, newValues)
+
+	return c.JSON(team)
+}
+
+// AddMember adds a user to a team
+func (h *TeamHandler) AddMember(c *fiber.Ctx) error {
+	var team models.Team
+	if err := h.db.First(&team, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Team not found",
+		})
+	}
+
+	var input struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", input.UserID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	var count int64
+	h.db.Model(&models.TeamMembership{}).Where("team_id = ? AND user_id = ?", team.ID, user.ID).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "User is already a member of this team",
+		})
+	}
+
+	membership := models.TeamMembership{TeamID: team.ID, UserID: user.ID}
+	if err := h.db.Create(&membership).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add member",
+		})
+	}
+
+	h.audit(c, "member_add", team.ID, nil, models.JSON{"user_id": user.ID, "email": user.Email})
+
+	membership.User = &user
+	return c.Status(fiber.StatusCreated).JSON(membership)
+}
+
+// RemoveMember removes a user from a team
+func (h *TeamHandler) RemoveMember(c *fiber.Ctx) error {
+	teamID := c.Params("id")
+	userID := c.Params("userId")
+
+	result := h.db.Where("team_id = ? AND user_id = ?", teamID, userID).Delete(&models.TeamMembership{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove member",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Membership not found",
+		})
+	}
+
+	if id, err := uuid.Parse(teamID); err == nil {
+		h.audit(c, "member_remove", id, models.JSON{"user_id": userID}, nil)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Member removed",
+	})
+}
+
+// ListBudgets returns a team's budgets with the spend committed against
+// each. Only members and admins can see them.
+func (h *TeamHandler) ListBudgets(c *fiber.Ctx) error {
+	var team models.Team
+	if err := h.db.First(&team, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Team not found",
+		})
+	}
+
+	if middleware.GetUserRole(c) != models.RoleAdmin {
+		member, err := repository.IsTeamMember(h.db, team.ID, middleware.GetUserID(c))
+		if err != nil || !member {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied",
+			})
+		}
+	}
+
+	var budgets []models.Budget
+	if err := h.db.Preload("Environment").Where("team_id = ?", team.ID).Find(&budgets).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch budgets",
+		})
+	}
+
+	usage := make([]BudgetUsage, len(budgets))
+	for i, budget := range budgets {
+		committed, err := repository.CommittedSpend(h.db, team.ID, budget.EnvironmentID, uuid.Nil)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to compute committed spend",
+			})
+		}
+		status := budget.Evaluate(committed, 0)
+		usage[i] = BudgetUsage{Budget: budget, Committed: committed, Remaining: status.Remaining}
+	}
+
+	return c.JSON(usage)
+}
+
+// SetBudget creates or replaces a team's budget in an environment
+func (h *TeamHandler) SetBudget(c *fiber.Ctx) error {
+	var team models.Team
+	if err := h.db.First(&team, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Team not found",
+		})
+	}
+
+	var environment models.Environment
+	if err := h.db.First(&environment, "id = ?", c.Params("environmentId")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Environment not found",
+		})
+	}
+
+	var input BudgetInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if input.Policy == "" {
+		input.Policy = models.BudgetPolicyWarn
+	}
+	if !models.ValidBudgetPolicy(input.Policy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Policy must be warn or block",
+		})
+	}
+	if input.MonthlyAmount < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Monthly amount cannot be negative",
+		})
+	}
+
+	var budget models.Budget
+	err := h.db.First(&budget, "team_id = ? AND environment_id = ?", team.ID, environment.ID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch budget",
+		})
+	}
+
+	var oldValues models.JSON
+	if budget.ID != uuid.Nil {
+		oldValues = budgetValues(budget)
+	}
+	budget.TeamID = team.ID
+	budget.EnvironmentID = environment.ID
+	budget.MonthlyAmount = input.MonthlyAmount
+	budget.Policy = input.Policy
+
+	if err := h.db.Save(&budget).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save budget",
+		})
+	}
+
+	h.audit(c, "budget_update", team.ID, oldValues, budgetValues(budget))
+
+	budget.Environment = &environment
+	return c.JSON(budget)
+}
+
+// DeleteBudget removes a team's budget in an environment
+func (h *TeamHandler) DeleteBudget(c *fiber.Ctx) error {
+	var budget models.Budget
+	if err := h.db.First(&budget, "team_id = ? AND environment_id = ?",
+		c.Params("id"), c.Params("environmentId")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Budget not found",
+		})
+	}
+
+	if err := h.db.Delete(&budget).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete budget",
+		})
+	}
+
+	h.audit(c, "budget_delete", budget.TeamID, budgetValues(budget), nil)
+
+	return c.JSON(fiber.Map{
+		"message": "Budget deleted",
+	})
+}
+
+func (h *TeamHandler) audit(c *fiber.Ctx, action string, teamID uuid.UUID, oldValues, newValues models.JSON) {
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       action,
+		ResourceType: "team",
+		ResourceID:   &teamID,
+		OldValues:    oldValues,
+		NewValues:    newValues,
+	})
+}
+
+// applyTeamInput copies the fields that were provided onto team
+func applyTeamInput(team *models.Team, input TeamInput) {
+	if input.DisplayName != nil {
+		team.DisplayName = strings.TrimSpace(*input.DisplayName)
+	}
+	if input.CostCenter != nil {
+		team.CostCenter = strings.TrimSpace(*input.CostCenter)
+	}
+	if input.IsActive != nil {
+		team.IsActive = *input.IsActive
+	}
+}
+
+// teamValues returns the editable fields of a team, keyed by column name,
+// for audit entries
+func teamValues(team models.Team) models.JSON {
+	return models.JSON{
+		"name":         team.Name,
+		"display_name": team.DisplayName,
+		"cost_center":  team.CostCenter,
+		"is_active":    team.IsActive,
+	}
+}
+
+func budgetValues(budget models.Budget) models.JSON {
+	return models.JSON{
+		"environment_id": budget.EnvironmentID,
+		"monthly_amount": budget.MonthlyAmount,
+		"policy":         budget.Policy,
+	}
+}
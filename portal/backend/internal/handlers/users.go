@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserHandler handles user administration endpoints
+type UserHandler struct {
+	db *gorm.DB
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(db *gorm.DB) *UserHandler {
+	return &UserHandler{db: db}
+}
+
+// CreateUserInput represents input for pre-creating a user, e.g. to invite
+// them when sign-in is invite-only
+type CreateUserInput struct {
+	Email  string   `json:"email"`
+	Name   string   `json:"name"`
+	Role   string   `json:"role"`
+	Groups []string `json:"groups"`
+}
+
+// UpdateRoleInput represents input for changing a user's role
+type UpdateRoleInput struct {
+	Role string `json:"role"`
+}
+
+// UpdateGroupsInput represents input for replacing a user's groups
+type UpdateGroupsInput struct {
+	Groups []string `json:"groups"`
+}
+
+// List returns users, optionally filtered by a search term, role or status
+func (h *UserHandler) List(c *fiber.Ctx) error {
+	query := h.db.Model(&models.User{})
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := "%" + strings.ToLower(q) + "%"
+		query = query.Where("LOWER(email) LIKE ? OR LOWER(name) LIKE ?", pattern, pattern)
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if active := c.Query("active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+
+	var users []models.User
+	if err := query.Order("email").Find(&users).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch users",
+		})
+	}
+
+	return c.JSON(users)
+}
+
+// Get returns a single user
+func (h *UserHandler) Get(c *fiber.Ctx) error {
+	var user models.User
+	if err := h.db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(user)
+}
+
+// Create pre-creates a user. The account is linked to a Google identity
+// by email the first time they sign in.
+func (h *UserHandler) Create(c *fiber.Ctx) error {
+	var input CreateUserInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+
+	input.Email = strings.TrimSpace(input.Email)
+	if !strings.Contains(input.Email, "@") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A valid email is required",
+		})
+	}
+	if input.Role == "" {
+		input.Role = models.RoleUser
+	}
+	if !models.ValidRole(input.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
+	}
+
+	var count int64
+	h.db.Unscoped().Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(input.Email)).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A user with this email already exists",
+		})
+	}
+
+	name := input.Name
+	if name == "" {
+		name = input.Email
+	}
+	user := models.User{
+		Email:    input.Email,
+		Name:     name,
+		Role:     input.Role,
+		Groups:   input.Groups,
+		IsActive: true,
+	}
+	if err := h.db.Create(&user).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create user",
+		})
+	}
+
+	h.audit(c, "create", user.ID, nil, models.JSON{
+		"email":  user.Email,
+		"role":   user.Role,
+		"groups": user.Groups,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(user)
+}
+
+// UpdateRole changes a user's role. The auth middleware reads the role from
+// the database, so the change applies to the user's next request.
+func (h *UserHandler) UpdateRole(c *fiber.Ctx) error {
+	var input UpdateRoleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+	if !models.ValidRole(input.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
+	}
+
+	user, ferr := h.loadOther(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	if user.Role == input.Role {
+		return c.JSON(user)
+	}
+
+	oldRole := user.Role
+	if err := h.db.Model(user).Update("role", input.Role).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update role",
+		})
+	}
+
+	h.audit(c, "role_change", user.ID, models.JSON{"role": oldRole}, models.JSON{"role": input.Role})
+
+	return c.JSON(user)
+}
+
+// UpdateGroups replaces a user's groups, which approval stages with
+// required groups check when the user decides
+func (h *UserHandler) UpdateGroups(c *fiber.Ctx) error {
+	var input UpdateGroupsInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input",
+		})
+	}
+
+	groups := models.StringList{}
+	for _, group := range input.Groups {
+		group = strings.TrimSpace(group)
+		if group == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Group names cannot be empty",
+			})
+		}
+		if !groups.Contains(group) {
+			groups = append(groups, group)
+		}
+	}
+
+	user, ferr := h.loadOther(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	oldGroups := user.Groups
+	if err := h.db.Model(user).Update("groups", groups).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update groups",
+		})
+	}
+
+	h.audit(c, "groups_change", user.ID, models.JSON{"groups": oldGroups}, models.JSON{"groups": groups})
+
+	return c.JSON(user)
+}
+
+// Deactivate blocks a user from signing in and revokes their sessions
+func (h *UserHandler) Deactivate(c *fiber.Ctx) error {
+	return h.setActive(c, false)
+}
+
+// Reactivate lets a deactivated user sign in again
+func (h *UserHandler) Reactivate(c *fiber.Ctx) error {
+	return h.setActive(c, true)
+}
+
+func (h *UserHandler) setActive(c *fiber.Ctx, active bool) error {
+	user, ferr := h.loadOther(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	if user.IsActive == active {
+		return c.JSON(user)
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("is_active", active).Error; err != nil {
+			return err
+		}
+		if active {
+			return nil
+		}
+		return tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update user",
+		})
+	}
+
+	action := "deactivate"
+	if active {
+		action = "reactivate"
+	}
+	h.audit(c, action, user.ID, models.JSON{"is_active": !active}, models.JSON{"is_active": active})
+
+	return c.JSON(user)
+}
+
+// loadOther loads the user from the route and refuses changes to the
+// caller's own account, so an admin cannot lock themselves out
+func (h *UserHandler) loadOther(c *fiber.Ctx) (*models.User, *fiber.Error) {
+	var user models.User
+	if err := h.db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+	if user.ID == middleware.GetUserID(c) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "You cannot change your own account")
+	}
+	return &user, nil
+}
+
+func (h *UserHandler) audit(c *fiber.Ctx, action string, userID uuid.UUID, oldValues, newValues models.JSON) {
+	recordAudit(h.db, c, models.AuditLog{
+		Action:       action,
+		ResourceType: "user",
+		ResourceID:   &userID,
+		OldValues:    oldValues,
+		NewValues:    newValues,
+	})
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUpdateGroupsLetsUsersDecideGroupStages(t *testing.T) {
+	f := newFixture(t)
+	_, approval := pendingRequest(f, models.ApprovalStage{
+		Position:          1,
+		Name:              "Security",
+		RequiredApprovals: 1,
+		RequiredGroups:    models.StringList{"security"},
+	})
+	admin := f.user("admin", models.RoleAdmin)
+	approver := f.user("ann", models.RoleApprover)
+
+	if code := approve(f, approver, approval, false); code != fiber.StatusForbidden {
+		t.Fatalf("expected an approver outside the group to be refused, got %d", code)
+	}
+
+	var updated models.User
+	app := f.app(admin, http.MethodPut, "/admin/users/:id/groups", NewUserHandler(f.db).UpdateGroups)
+	body := UpdateGroupsInput{Groups: []string{" security ", "security", "platform"}}
+	if code := call(t, app, http.MethodPut, "/admin/users/"+approver.ID.String()+"/groups", body, &updated); code != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(updated.Groups) != 2 || !updated.Groups.Contains("security") || !updated.Groups.Contains("platform") {
+		t.Errorf("expected groups [security platform], got %v", updated.Groups)
+	}
+
+	var audit models.AuditLog
+	if err := f.db.First(&audit, "action = ? AND resource_id = ?", "groups_change", approver.ID).Error; err != nil {
+		t.Errorf("expected the change to be audited: %v", err)
+	} else if audit.UserID == nil || *audit.UserID != admin.ID {
+		t.Errorf("expected the audit entry to name the admin, got %v", audit.UserID)
+	}
+
+	if code := approve(f, approver, approval, true); code != fiber.StatusOK {
+		t.Errorf("expected the approver to decide once in the group, got %d", code)
+	}
+
+	// Admins cannot change their own groups
+	if code := call(t, app, http.MethodPut, "/admin/users/"+admin.ID.String()+"/groups", body, nil); code != fiber.StatusBadRequest {
+		t.Errorf("expected 400 for the admin's own account, got %d", code)
+	}
+}
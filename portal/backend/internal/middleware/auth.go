@@ -10,14 +10,34 @@ import (
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	Role   string    `json:"role"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	SessionID uuid.UUID `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// AuthMiddleware validates JWT tokens
-func AuthMiddleware(jwtSecret string) fiber.Handler {
+// SessionState is the current state of a session and the user behind it
+type SessionState struct {
+	Revoked bool   // revoked or expired
+	Role    string // the user's role right now, which may differ from the token
+	Active  bool   // false once the user has been deactivated
+}
+
+// SessionStore looks up the live state of a session. It returns nil for
+// unknown sessions.
+type SessionStore interface {
+	Lookup(sessionID uuid.UUID) (*SessionState, error)
+}
+
+// AuthMiddleware validates JWT tokens with keyfunc and checks the session
+// against the store. Tokens must be signed with one of methods and issued
+// by issuer, so a token from another service that shares a verification
+// key is rejected. The role is taken from the store rather than the
+// token, so role changes and deactivations apply to the next request.
+func AuthMiddleware(keyfunc jwt.Keyfunc, issuer string, methods []string, sessions SessionStore) fiber.Handler {
+	parser := jwt.NewParser(jwt.WithIssuer(issuer), jwt.WithValidMethods(methods))
+
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -36,9 +56,7 @@ func AuthMiddleware(jwtSecret string) fiber.Handler {
 		tokenString := parts[1]
 		claims := &Claims{}
 
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecret), nil
-		})
+		token, err := parser.ParseWithClaims(tokenString, claims, keyfunc)
 
 		if err != nil || !token.Valid {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -46,10 +64,28 @@ func AuthMiddleware(jwtSecret string) fiber.Handler {
 			})
 		}
 
+		session, err := sessions.Lookup(claims.SessionID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify session",
+			})
+		}
+		if session == nil || session.Revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Session has been revoked",
+			})
+		}
+		if !session.Active {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Account has been deactivated",
+			})
+		}
+
 		// Store user info in context
 		c.Locals("userID", claims.UserID)
+		c.Locals("sessionID", claims.SessionID)
 		c.Locals("email", claims.Email)
-		c.Locals("role", claims.Role)
+		c.Locals("role", session.Role)
 
 		return c.Next()
 	}
@@ -86,6 +122,15 @@ func GetUserID(c *fiber.Ctx) uuid.UUID {
 	return userID
 }
 
+// GetSessionID extracts session ID from context
+func GetSessionID(c *fiber.Ctx) uuid.UUID {
+	sessionID, ok := c.Locals("sessionID").(uuid.UUID)
+	if !ok {
+		return uuid.Nil
+	}
+	return sessionID
+}
+
 // GetUserRole extracts user role from context
 func GetUserRole(c *fiber.Ctx) string {
 	role, ok := c.Locals("role").(string)
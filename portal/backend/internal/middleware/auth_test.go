@@ -1,13 +1,50 @@
 package middleware
 
 import (
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// fakeSessionStore serves session states from memory
+type fakeSessionStore map[uuid.UUID]*SessionState
+
+func (s fakeSessionStore) Lookup(sessionID uuid.UUID) (*SessionState, error) {
+	return s[sessionID], nil
+}
+
+const testIssuer = "infra-portal"
+
+// testMethods are the signing methods the test middleware accepts
+var testMethods = []string{jwt.SigningMethodHS256.Alg()}
+
+func signTestToken(t *testing.T, secret string, sessionID uuid.UUID, role string) string {
+	t.Helper()
+	return signToken(t, jwt.SigningMethodHS256, secret, testIssuer, sessionID, role)
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, secret, issuer string, sessionID uuid.UUID, role string) string {
+	t.Helper()
+	claims := Claims{
+		UserID:    uuid.New(),
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	tokenString, err := jwt.NewWithClaims(method, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
 func TestClaimsStructure(t *testing.T) {
 	userID := uuid.New()
 	claims := Claims{
@@ -172,3 +209,115 @@ func TestEmptyClaims(t *testing.T) {
 		t.Error("empty Role should be empty string")
 	}
 }
+
+func TestAuthMiddlewareSessionRevocation(t *testing.T) {
+	secret := "test-secret"
+	active := uuid.New()
+	revoked := uuid.New()
+	deactivated := uuid.New()
+	store := fakeSessionStore{
+		active:      {Role: "user", Active: true},
+		revoked:     {Revoked: true, Role: "user", Active: true},
+		deactivated: {Role: "user", Active: false},
+	}
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}
+
+	app := fiber.New()
+	app.Get("/me", AuthMiddleware(keyfunc, testIssuer, testMethods, store), func(c *fiber.Ctx) error {
+		if GetSessionID(c) != active {
+			t.Errorf("expected session %v in context, got %v", active, GetSessionID(c))
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		name      string
+		sessionID uuid.UUID
+		expected  int
+	}{
+		{"active session", active, fiber.StatusOK},
+		{"revoked session", revoked, fiber.StatusUnauthorized},
+		{"deactivated user", deactivated, fiber.StatusUnauthorized},
+		{"unknown session", uuid.New(), fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/me", nil)
+			req.Header.Set("Authorization", "Bearer "+signTestToken(t, secret, tt.sessionID, "user"))
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareUsesCurrentRole(t *testing.T) {
+	secret := "test-secret"
+	demoted := uuid.New()
+	store := fakeSessionStore{demoted: {Role: "user", Active: true}}
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}
+
+	app := fiber.New()
+	app.Get("/admin", AuthMiddleware(keyfunc, testIssuer, testMethods, store), RequireRole("admin"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// The token still says admin, but the role was changed since it was issued
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.Header.Set("Authorization", "Bearer "+signTestToken(t, secret, demoted, "admin"))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected 403 after demotion, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthMiddlewareRejectsForeignTokens(t *testing.T) {
+	secret := "test-secret"
+	session := uuid.New()
+	store := fakeSessionStore{session: {Role: "user", Active: true}}
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}
+
+	app := fiber.New()
+	app.Get("/me", AuthMiddleware(keyfunc, testIssuer, testMethods, store), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{"portal token", signToken(t, jwt.SigningMethodHS256, secret, testIssuer, session, "user"), fiber.StatusOK},
+		{"other issuer", signToken(t, jwt.SigningMethodHS256, secret, "other-service", session, "user"), fiber.StatusUnauthorized},
+		{"no issuer", signToken(t, jwt.SigningMethodHS256, secret, "", session, "user"), fiber.StatusUnauthorized},
+		{"other method", signToken(t, jwt.SigningMethodHS384, secret, testIssuer, session, "user"), fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/me", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
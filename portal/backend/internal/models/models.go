@@ -1,6 +1,11 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,13 +18,45 @@ type User struct {
 	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
 	Name      string         `gorm:"not null" json:"name"`
 	Role      string         `gorm:"default:user" json:"role"` // user, approver, admin
-	GoogleID  string         `gorm:"uniqueIndex" json:"-"`
+	GoogleID  *string        `gorm:"uniqueIndex" json:"-"`     // nil until a pre-provisioned user first signs in
 	AvatarURL string         `json:"avatar_url,omitempty"`
+	Groups    StringList     `gorm:"type:jsonb" json:"groups,omitempty"`
+	IsActive  bool           `gorm:"default:true" json:"is_active"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// User roles
+const (
+	RoleUser     = "user"
+	RoleApprover = "approver"
+	RoleAdmin    = "admin"
+)
+
+// ValidRole reports whether role is one of the portal roles
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleApprover || role == RoleAdmin
+}
+
+// Session is a signed-in browser or client. It holds the current refresh
+// token; access tokens carry the session ID so revoking the session
+// invalidates them immediately.
+type Session struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID            uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	User              *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	RefreshTokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	PreviousTokenHash string     `gorm:"index" json:"-"` // detects reuse of a rotated token
+	ExpiresAt         time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+	IPAddress         string     `json:"ip_address,omitempty"`
+	UserAgent         string     `json:"user_agent,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
 // Environment represents a deployment environment
 type Environment struct {
 	ID               uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -30,44 +67,133 @@ type Environment struct {
 	Region           string    `gorm:"default:asia-southeast1" json:"region"`
 	RequiresApproval bool      `gorm:"default:false" json:"requires_approval"`
 	IsActive         bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	MaxTTLHours      int       `gorm:"default:0" json:"max_ttl_hours"` // lifetime limit for new resources, 0 for none
+
+	// Separation of duties
+	AllowSelfApproval   bool `gorm:"default:false" json:"allow_self_approval"`
+	BlockEditorApproval bool `gorm:"default:false" json:"block_editor_approval"`
+
+	// Promotion. Applied requests are promoted to NextEnvironmentID;
+	// PromotionOverrides maps a resource type name to configuration that is
+	// forced on requests promoted into this environment.
+	NextEnvironmentID  *uuid.UUID `gorm:"type:uuid" json:"next_environment_id,omitempty"`
+	PromotionOverrides JSON       `gorm:"type:jsonb" json:"promotion_overrides,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ApprovalStages []ApprovalStage `gorm:"foreignKey:EnvironmentID" json:"approval_stages,omitempty"`
+}
+
+// ApprovalStage is one ordered step of an environment's approval policy.
+// A request moves to the next stage once RequiredApprovals distinct
+// approvers have approved the current one.
+type ApprovalStage struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	EnvironmentID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"environment_id"`
+	Position          int        `gorm:"not null" json:"position"` // 1-based order
+	Name              string     `gorm:"not null" json:"name"`
+	RequiredApprovals int        `gorm:"default:1" json:"required_approvals"`
+	RequiredRoles     StringList `gorm:"type:jsonb" json:"required_roles,omitempty"`  // any of these roles
+	RequiredGroups    StringList `gorm:"type:jsonb" json:"required_groups,omitempty"` // any of these groups
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
 // ResourceType represents a type of infrastructure resource
 type ResourceType struct {
-	ID           uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	Name         string          `gorm:"uniqueIndex;not null" json:"name"` // gke, cloudsql, redis
-	DisplayName  string          `json:"display_name"`
-	Description  string          `json:"description,omitempty"`
-	ModulePath   string          `json:"module_path"`
-	ConfigSchema JSON            `gorm:"type:jsonb" json:"config_schema"` // JSON Schema
-	BaseCost     float64         `gorm:"default:0" json:"base_cost"`
-	IsActive     bool            `gorm:"default:true" json:"is_active"`
-	CreatedAt    time.Time       `json:"created_at"`
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name         string    `gorm:"uniqueIndex;not null" json:"name"` // gke, cloudsql, redis
+	DisplayName  string    `json:"display_name"`
+	Description  string    `json:"description,omitempty"`
+	ModulePath   string    `json:"module_path"`
+	ConfigSchema JSON      `gorm:"type:jsonb" json:"config_schema"` // JSON Schema of the current version
+	BaseCost     float64   `gorm:"default:0" json:"base_cost"`
+	IsActive     bool      `gorm:"default:true" json:"is_active"`
+
+	CurrentVersion int  `gorm:"default:0" json:"current_version"`
+	InputMapping   JSON `gorm:"type:jsonb" json:"input_mapping,omitempty"` // module variable -> configuration field or environment value
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// ResourceTypeVersion is an immutable snapshot of a resource type's
+// configuration schema. A new version is created whenever the schema changes.
+type ResourceTypeVersion struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ResourceTypeID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_resource_type_version" json:"resource_type_id"`
+	Version        int        `gorm:"not null;uniqueIndex:idx_resource_type_version" json:"version"`
+	ConfigSchema   JSON       `gorm:"type:jsonb;not null" json:"config_schema"`
+	InputMapping   JSON       `gorm:"type:jsonb" json:"input_mapping,omitempty"`
+	Checksum       string     `gorm:"not null" json:"checksum"`
+	Source         string     `gorm:"not null" json:"source"` // seed, admin
+	CreatedByID    *uuid.UUID `gorm:"type:uuid" json:"created_by_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Schema version sources
+const (
+	SchemaSourceSeed  = "seed"
+	SchemaSourceAdmin = "admin"
+)
+
 // Request represents an infrastructure provisioning request
 type Request struct {
 	ID             uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	Title          string         `gorm:"not null" json:"title"`
 	Description    string         `json:"description,omitempty"`
+	Kind           string         `gorm:"default:create" json:"kind"`                                   // create, modify, decommission
+	ResourceID     *uuid.UUID     `gorm:"type:uuid;index" json:"resource_id,omitempty"`                 // the inventoried resource it creates or changes
+	Resource       *Resource      `gorm:"foreignKey:ResourceID;constraint:-" json:"resource,omitempty"` // no constraint: resources also reference requests
 	RequesterID    uuid.UUID      `gorm:"type:uuid;not null" json:"requester_id"`
 	Requester      *User          `gorm:"foreignKey:RequesterID" json:"requester,omitempty"`
 	EnvironmentID  uuid.UUID      `gorm:"type:uuid;not null" json:"environment_id"`
 	Environment    *Environment   `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
-	ResourceTypeID uuid.UUID      `gorm:"type:uuid;not null" json:"resource_type_id"`
+	ResourceTypeID *uuid.UUID     `gorm:"type:uuid" json:"resource_type_id,omitempty"` // nil for blueprint requests
 	ResourceType   *ResourceType  `gorm:"foreignKey:ResourceTypeID" json:"resource_type,omitempty"`
+	TeamID         *uuid.UUID     `gorm:"type:uuid;index" json:"team_id,omitempty"` // who pays for it
+	Team           *Team          `gorm:"foreignKey:TeamID" json:"team,omitempty"`
 	Configuration  JSON           `gorm:"type:jsonb;not null" json:"configuration"`
+	SchemaVersion  int            `gorm:"default:0" json:"schema_version"` // resource type version the configuration was validated against
 	TerraformPlan  string         `json:"terraform_plan,omitempty"`
-	EstimatedCost  float64        `json:"estimated_cost"`
+	EstimatedCost  float64        `json:"estimated_cost"` // monthly
+	CostBreakdown  JSON           `gorm:"type:jsonb" json:"cost_breakdown,omitempty"`
+	PlanCost       JSON           `gorm:"type:jsonb" json:"plan_cost,omitempty"`     // Infracost breakdown of the plan
+	ImportedCost   JSON           `gorm:"type:jsonb" json:"imported_cost,omitempty"` // uploaded Infracost breakdown, never used for budgets
+	CostDelta      *float64       `json:"cost_delta,omitempty"`                      // monthly change from the plan
+	Budget         *BudgetStatus  `gorm:"-" json:"budget,omitempty"`                 // set when the request is checked against a budget
 	Status         string         `gorm:"default:draft" json:"status"`
 	Priority       string         `gorm:"default:normal" json:"priority"`
+	TTLHours       int            `gorm:"default:0" json:"ttl_hours,omitempty"` // lifetime of the resource it creates, 0 for none
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 	SubmittedAt    *time.Time     `json:"submitted_at,omitempty"`
 	CompletedAt    *time.Time     `json:"completed_at,omitempty"`
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Decommission options
+	FinalBackup               bool `gorm:"default:false" json:"final_backup,omitempty"`
+	DisableDeletionProtection bool `gorm:"default:false" json:"disable_deletion_protection,omitempty"`
+
+	// PromotedFromID is the applied request in the previous environment
+	// this one was promoted from
+	PromotedFromID *uuid.UUID `gorm:"type:uuid;index" json:"promoted_from_id,omitempty"`
+	PromotedFrom   *Request   `gorm:"foreignKey:PromotedFromID" json:"promoted_from,omitempty"`
+
+	// Blueprint requests. The parent request has kind blueprint and no
+	// resource type; each component is a create request under it that is
+	// provisioned once the parent is approved. Wiring and DependsOn are
+	// copied from the blueprint when the request is filed; WiredVariables
+	// holds the values the wiring resolved to when it was provisioned.
+	BlueprintID    *uuid.UUID `gorm:"type:uuid;index" json:"blueprint_id,omitempty"`
+	Blueprint      *Blueprint `gorm:"foreignKey:BlueprintID" json:"blueprint,omitempty"`
+	ParentID       *uuid.UUID `gorm:"type:uuid;index" json:"parent_id,omitempty"`
+	Component      string     `json:"component,omitempty"`
+	Wiring         JSON       `gorm:"type:jsonb" json:"wiring,omitempty"`
+	DependsOn      StringList `gorm:"type:jsonb" json:"depends_on,omitempty"`
+	WiredVariables JSON       `gorm:"type:jsonb" json:"wired_variables,omitempty"`
+	Components     []Request  `gorm:"foreignKey:ParentID" json:"components,omitempty"`
 }
 
 // Request statuses
@@ -84,19 +210,305 @@ const (
 	StatusCancelled = "cancelled"
 )
 
+// Request kinds
+const (
+	RequestKindCreate       = "create"
+	RequestKindModify       = "modify"
+	RequestKindDecommission = "decommission"
+	RequestKindBlueprint    = "blueprint"
+)
+
+// InFlightStatuses are the statuses of requests that are waiting for a
+// decision or are being provisioned
+var InFlightStatuses = []string{StatusPending, StatusApproved, StatusPlanning, StatusPlanned, StatusApplying}
+
+// Blueprint groups resource types that are requested, approved and
+// provisioned together, such as a service's cluster, database and cache
+type Blueprint struct {
+	ID          uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name        string         `gorm:"uniqueIndex;not null" json:"name"`
+	DisplayName string         `json:"display_name"`
+	Description string         `json:"description,omitempty"`
+	IsActive    bool           `gorm:"default:true" json:"is_active"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Components []BlueprintComponent `gorm:"foreignKey:BlueprintID" json:"components,omitempty"`
+}
+
+// BlueprintComponent is one resource of a blueprint. Configuration holds
+// defaults the requester's configuration is merged over; Wiring maps module
+// variables to outputs of other components ("<component>.<output>").
+type BlueprintComponent struct {
+	ID             uuid.UUID     `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	BlueprintID    uuid.UUID     `gorm:"type:uuid;not null;index" json:"blueprint_id"`
+	Name           string        `gorm:"not null" json:"name"`
+	Position       int           `gorm:"not null" json:"position"` // 1-based order
+	ResourceTypeID uuid.UUID     `gorm:"type:uuid;not null" json:"resource_type_id"`
+	ResourceType   *ResourceType `gorm:"foreignKey:ResourceTypeID" json:"resource_type,omitempty"`
+	Configuration  JSON          `gorm:"type:jsonb" json:"configuration,omitempty"`
+	Wiring         JSON          `gorm:"type:jsonb" json:"wiring,omitempty"`
+	DependsOn      StringList    `gorm:"type:jsonb" json:"depends_on,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// Resource is infrastructure the portal has provisioned. It is created
+// when a request is applied and tracks what is currently running.
+type Resource struct {
+	ID              uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name            string         `gorm:"not null;index" json:"name"`
+	RequestID       uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex" json:"request_id"` // the request that created it
+	Request         *Request       `gorm:"foreignKey:RequestID" json:"request,omitempty"`
+	LastRequestID   *uuid.UUID     `gorm:"type:uuid" json:"last_request_id,omitempty"` // the most recently applied change
+	EnvironmentID   uuid.UUID      `gorm:"type:uuid;not null;index" json:"environment_id"`
+	Environment     *Environment   `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
+	ResourceTypeID  uuid.UUID      `gorm:"type:uuid;not null;index" json:"resource_type_id"`
+	ResourceType    *ResourceType  `gorm:"foreignKey:ResourceTypeID" json:"resource_type,omitempty"`
+	OwnerID         uuid.UUID      `gorm:"type:uuid;not null;index" json:"owner_id"`
+	Owner           *User          `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+	TeamID          *uuid.UUID     `gorm:"type:uuid;index" json:"team_id,omitempty"`
+	Team            *Team          `gorm:"foreignKey:TeamID" json:"team,omitempty"`
+	Configuration   JSON           `gorm:"type:jsonb;not null" json:"configuration"` // as last applied
+	SchemaVersion   int            `gorm:"default:0" json:"schema_version"`
+	Outputs         JSON           `gorm:"type:jsonb" json:"outputs,omitempty"` // terraform outputs, sensitive values redacted
+	StatePrefix     string         `gorm:"not null" json:"state_prefix"`        // remote state location
+	Status          string         `gorm:"default:active" json:"status"`
+	ExpiresAt       *time.Time     `gorm:"index" json:"expires_at,omitempty"`
+	ExpiryWarnedAt  *time.Time     `json:"expiry_warned_at,omitempty"`
+	ExpiryRequestID *uuid.UUID     `gorm:"type:uuid" json:"expiry_request_id,omitempty"` // decommission filed when it expired
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DestroyedAt     *time.Time     `json:"destroyed_at,omitempty"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Module variables wired from other components when the resource was
+	// created by a blueprint. Later changes are planned with the same values.
+	WiredVariables JSON `gorm:"type:jsonb" json:"wired_variables,omitempty"`
+}
+
+// TTL returns the lifetime in hours of a resource requested with the given
+// TTL (0 for none). An environment with a maximum uses it as the default
+// and refuses anything longer.
+func (e Environment) TTL(hours int) (int, error) {
+	if hours < 0 {
+		return 0, errors.New("TTL cannot be negative")
+	}
+	if e.MaxTTLHours == 0 {
+		return hours, nil
+	}
+	if hours == 0 {
+		return e.MaxTTLHours, nil
+	}
+	if hours > e.MaxTTLHours {
+		return 0, fmt.Errorf("TTL cannot exceed %d hours in %s", e.MaxTTLHours, e.Name)
+	}
+	return hours, nil
+}
+
+// PromotionOverride returns the configuration forced on requests for the
+// given resource type that are promoted into the environment, or nil
+func (e Environment) PromotionOverride(resourceType string) JSON {
+	override, ok := e.PromotionOverrides[resourceType].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return JSON(override)
+}
+
+// ExtendExpiry returns the expiry of a resource extended by hours from
+// expiresAt, or from now if it has already passed. The result is capped at
+// the environment's maximum TTL counted from now.
+func (e Environment) ExtendExpiry(expiresAt time.Time, hours int, now time.Time) (time.Time, error) {
+	if hours <= 0 {
+		return time.Time{}, errors.New("extension must be at least one hour")
+	}
+	if expiresAt.Before(now) {
+		expiresAt = now
+	}
+	extended := expiresAt.Add(time.Duration(hours) * time.Hour)
+	if e.MaxTTLHours > 0 {
+		if limit := now.Add(time.Duration(e.MaxTTLHours) * time.Hour); extended.After(limit) {
+			extended = limit
+		}
+	}
+	return extended, nil
+}
+
+// Resource statuses
+const (
+	ResourceActive    = "active"
+	ResourceDestroyed = "destroyed"
+)
+
 // Approval represents an approval decision
 type Approval struct {
 	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	RequestID  uuid.UUID  `gorm:"type:uuid;not null" json:"request_id"`
 	Request    *Request   `gorm:"foreignKey:RequestID" json:"request,omitempty"`
-	ApproverID uuid.UUID  `gorm:"type:uuid;not null" json:"approver_id"`
+	ApproverID *uuid.UUID `gorm:"type:uuid" json:"approver_id,omitempty"`
 	Approver   *User      `gorm:"foreignKey:ApproverID" json:"approver,omitempty"`
-	Status     string     `gorm:"default:pending" json:"status"` // pending, approved, rejected
+	Stage      int        `gorm:"default:1" json:"stage"`
+	StageID    *uuid.UUID `gorm:"type:uuid" json:"stage_id,omitempty"`
+	StageName  string     `json:"stage_name,omitempty"`
+	Status     string     `gorm:"default:pending" json:"status"` // pending, approved, rejected, cancelled
 	Comment    string     `json:"comment,omitempty"`
 	ApprovedAt *time.Time `json:"approved_at,omitempty"`
 	CreatedAt  time.Time  `json:"created_at"`
 }
 
+// Approval statuses
+const (
+	ApprovalPending   = "pending"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+	ApprovalCancelled = "cancelled"
+)
+
+// Run is one Terraform plan, apply or destroy of a request. Provisioning a
+// request is an attempt made of a plan run followed by an apply (or
+// destroy) run; retries start a new attempt.
+type Run struct {
+	ID         uuid.UUID     `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	RequestID  uuid.UUID     `gorm:"type:uuid;not null;index" json:"request_id"`
+	Attempt    int           `gorm:"not null" json:"attempt"` // 1-based
+	Operation  string        `gorm:"not null" json:"operation"`
+	Status     string        `gorm:"not null" json:"status"`
+	ExitCode   *int          `json:"exit_code,omitempty"` // terraform's exit status, if it ran
+	Error      string        `json:"error,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	BackupURI  string        `json:"backup_uri,omitempty"` // final backup taken before a destroy
+	Artifacts  []RunArtifact `gorm:"foreignKey:RunID" json:"artifacts,omitempty"`
+}
+
+// Run operations
+const (
+	RunPlan    = "plan"
+	RunApply   = "apply"
+	RunDestroy = "destroy"
+)
+
+// Run statuses
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// RunLogChunk is a piece of a run's output. Chunks are written while the
+// run is in progress; in Seq order they make up the whole log.
+type RunLogChunk struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"-"`
+	RunID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_run_log_chunks_seq" json:"run_id"`
+	Seq       int       `gorm:"not null;uniqueIndex:idx_run_log_chunks_seq" json:"seq"` // 1-based
+	Data      string    `gorm:"type:text;not null" json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RunArtifact is a file a run produced, kept in artifact storage
+type RunArtifact struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	RunID       uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_run_artifacts_name" json:"run_id"`
+	Name        string    `gorm:"not null;uniqueIndex:idx_run_artifacts_name" json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	StorageKey  string    `gorm:"not null" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Run artifact names
+const (
+	ArtifactPlan     = "tfplan"      // binary plan saved by terraform plan -out
+	ArtifactPlanJSON = "tfplan.json" // terraform show -json of the plan
+)
+
+// Team is a group of users that owns requests and pays for them through a
+// cost center
+type Team struct {
+	ID          uuid.UUID        `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name        string           `gorm:"uniqueIndex;not null" json:"name"`
+	DisplayName string           `json:"display_name"`
+	CostCenter  string           `json:"cost_center,omitempty"`
+	IsActive    bool             `gorm:"default:true" json:"is_active"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	Members     []TeamMembership `gorm:"foreignKey:TeamID" json:"members,omitempty"`
+	Budgets     []Budget         `gorm:"foreignKey:TeamID" json:"budgets,omitempty"`
+}
+
+// TeamMembership links a user to a team
+type TeamMembership struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TeamID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_team_member" json:"team_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_team_member;index" json:"user_id"`
+	User      *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Budget is a team's monthly spending limit in one environment
+type Budget struct {
+	ID            uuid.UUID    `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TeamID        uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_team_environment_budget" json:"team_id"`
+	EnvironmentID uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_team_environment_budget" json:"environment_id"`
+	Environment   *Environment `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
+	MonthlyAmount float64      `gorm:"not null" json:"monthly_amount"`
+	Policy        string       `gorm:"default:warn" json:"policy"` // warn, block
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// Budget policies
+const (
+	BudgetPolicyWarn  = "warn"
+	BudgetPolicyBlock = "block"
+)
+
+// BudgetStatus is how a request affects its team's budget
+type BudgetStatus struct {
+	BudgetID      uuid.UUID `json:"budget_id"`
+	Policy        string    `json:"policy"`
+	MonthlyAmount float64   `json:"monthly_amount"`
+	Committed     float64   `json:"committed"`    // other open and applied requests
+	RequestCost   float64   `json:"request_cost"` // this request
+	Remaining     float64   `json:"remaining"`    // headroom left after this request
+	Exceeded      bool      `json:"exceeded"`
+	Blocked       bool      `json:"blocked"`
+}
+
+// Evaluate checks whether a request costing cost fits in the budget next to
+// the committed spend
+func (b Budget) Evaluate(committed, cost float64) BudgetStatus {
+	remaining := math.Round((b.MonthlyAmount-committed-cost)*100) / 100
+	exceeded := remaining < 0 && cost > 0
+	return BudgetStatus{
+		BudgetID:      b.ID,
+		Policy:        b.Policy,
+		MonthlyAmount: b.MonthlyAmount,
+		Committed:     committed,
+		RequestCost:   cost,
+		Remaining:     remaining,
+		Exceeded:      exceeded,
+		Blocked:       exceeded && b.Policy == BudgetPolicyBlock,
+	}
+}
+
+// MonthlyCost is the request's monthly cost for budgeting: the priced plan
+// when there is one, otherwise the catalog estimate
+func (r Request) MonthlyCost() float64 {
+	if r.CostDelta != nil {
+		return *r.CostDelta
+	}
+	return r.EstimatedCost
+}
+
+// ValidBudgetPolicy reports whether policy is a known budget policy
+func ValidBudgetPolicy(policy string) bool {
+	return policy == BudgetPolicyWarn || policy == BudgetPolicyBlock
+}
+
 // AuditLog represents an audit trail entry
 type AuditLog struct {
 	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -114,3 +526,62 @@ type AuditLog struct {
 
 // JSON is a custom type for JSONB fields
 type JSON map[string]interface{}
+
+// Value implements driver.Valuer
+func (j JSON) Value() (driver.Value, error) {
+	if j == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(j)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (j *JSON) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, j)
+	case string:
+		return json.Unmarshal([]byte(v), j)
+	}
+	return errors.New("unsupported type for JSON")
+}
+
+// StringList is a list of strings stored as a JSONB array
+type StringList []string
+
+// Value implements driver.Valuer
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	}
+	return errors.New("unsupported type for StringList")
+}
+
+// Contains reports whether the list contains s
+func (l StringList) Contains(s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
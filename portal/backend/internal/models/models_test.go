@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 )
 
 func TestRequestStatusConstants(t *testing.T) {
@@ -31,6 +32,27 @@ func TestRequestStatusConstants(t *testing.T) {
 	}
 }
 
+func TestApprovalStatusConstants(t *testing.T) {
+	tests := []struct {
+		name     string
+		constant string
+		expected string
+	}{
+		{"Pending", ApprovalPending, "pending"},
+		{"Approved", ApprovalApproved, "approved"},
+		{"Rejected", ApprovalRejected, "rejected"},
+		{"Cancelled", ApprovalCancelled, "cancelled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.constant != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, tt.constant)
+			}
+		})
+	}
+}
+
 func TestUserRoleValues(t *testing.T) {
 	validRoles := map[string]bool{
 		"user":     true,
@@ -112,6 +134,10 @@ func TestApprovalDefaults(t *testing.T) {
 	if approval.ApprovedAt != nil {
 		t.Error("ApprovedAt should be nil by default")
 	}
+
+	if approval.ApproverID != nil {
+		t.Error("ApproverID should be nil until someone decides")
+	}
 }
 
 func TestJSONType(t *testing.T) {
@@ -169,3 +195,151 @@ func TestAuditLogOptionalFields(t *testing.T) {
 		t.Error("NewValues should be optional (nil)")
 	}
 }
+
+func TestStringList(t *testing.T) {
+	l := StringList{"approver", "admin"}
+
+	if !l.Contains("admin") {
+		t.Error("list should contain admin")
+	}
+	if l.Contains("user") {
+		t.Error("list should not contain user")
+	}
+
+	value, err := l.Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+
+	var scanned StringList
+	if err := scanned.Scan(value); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(scanned) != 2 || scanned[0] != "approver" || scanned[1] != "admin" {
+		t.Errorf("round trip mismatch: %v", scanned)
+	}
+
+	if err := scanned.Scan(nil); err != nil || scanned != nil {
+		t.Errorf("scanning NULL should give a nil list, got %v (%v)", scanned, err)
+	}
+}
+
+func TestBudgetEvaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		committed float64
+		cost      float64
+		remaining float64
+		exceeded  bool
+		blocked   bool
+	}{
+		{"Within budget", BudgetPolicyBlock, 300, 100, 600, false, false},
+		{"Exactly at budget", BudgetPolicyBlock, 900, 100, 0, false, false},
+		{"Over budget with warn", BudgetPolicyWarn, 900, 150.5, -50.5, true, false},
+		{"Over budget with block", BudgetPolicyBlock, 900, 150.5, -50.5, true, true},
+		{"Free request on a spent budget", BudgetPolicyBlock, 1200, 0, -200, false, false},
+		{"Saving on a spent budget", BudgetPolicyBlock, 1200, -50, -150, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Budget{MonthlyAmount: 1000, Policy: tt.policy}
+			status := b.Evaluate(tt.committed, tt.cost)
+			if status.Remaining != tt.remaining || status.Exceeded != tt.exceeded || status.Blocked != tt.blocked {
+				t.Errorf("unexpected status %+v", status)
+			}
+		})
+	}
+}
+
+func TestRequestMonthlyCost(t *testing.T) {
+	r := Request{EstimatedCost: 120}
+	if r.MonthlyCost() != 120 {
+		t.Errorf("expected the estimate without a priced plan, got %v", r.MonthlyCost())
+	}
+
+	delta := 95.5
+	r.CostDelta = &delta
+	if r.MonthlyCost() != 95.5 {
+		t.Errorf("expected the priced plan delta, got %v", r.MonthlyCost())
+	}
+}
+
+func TestEnvironmentTTL(t *testing.T) {
+	dev := Environment{Name: "dev", MaxTTLHours: 72}
+	prod := Environment{Name: "prod"}
+
+	tests := []struct {
+		name     string
+		env      Environment
+		hours    int
+		expected int
+		valid    bool
+	}{
+		{"default to the maximum", dev, 0, 72, true},
+		{"within the maximum", dev, 8, 8, true},
+		{"over the maximum", dev, 96, 0, false},
+		{"no limit", prod, 0, 0, true},
+		{"optional without a limit", prod, 500, 500, true},
+		{"negative", prod, -1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.env.TTL(tt.hours)
+			if tt.valid != (err == nil) {
+				t.Fatalf("expected valid=%v, got %v", tt.valid, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnvironmentExtendExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	dev := Environment{Name: "dev", MaxTTLHours: 48}
+
+	extended, err := dev.ExtendExpiry(now.Add(2*time.Hour), 24, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !extended.Equal(now.Add(26 * time.Hour)) {
+		t.Errorf("expected 26h from now, got %v", extended.Sub(now))
+	}
+
+	capped, _ := dev.ExtendExpiry(now.Add(40*time.Hour), 24, now)
+	if !capped.Equal(now.Add(48 * time.Hour)) {
+		t.Errorf("extension should be capped at the maximum TTL, got %v", capped.Sub(now))
+	}
+
+	expired, _ := Environment{}.ExtendExpiry(now.Add(-time.Hour), 4, now)
+	if !expired.Equal(now.Add(4 * time.Hour)) {
+		t.Errorf("an expired resource should be extended from now, got %v", expired.Sub(now))
+	}
+
+	if _, err := dev.ExtendExpiry(now, 0, now); err == nil {
+		t.Error("expected an error for a zero extension")
+	}
+}
+
+func TestEnvironmentPromotionOverride(t *testing.T) {
+	prod := Environment{PromotionOverrides: JSON{
+		"cloudsql": map[string]interface{}{"high_availability": true},
+		"redis":    "not an object",
+	}}
+
+	if got := prod.PromotionOverride("cloudsql"); got["high_availability"] != true {
+		t.Errorf("expected high_availability override, got %v", got)
+	}
+	if got := prod.PromotionOverride("redis"); got != nil {
+		t.Errorf("expected no override for a malformed entry, got %v", got)
+	}
+	if got := prod.PromotionOverride("gke"); got != nil {
+		t.Errorf("expected no override, got %v", got)
+	}
+	if got := (Environment{}).PromotionOverride("cloudsql"); got != nil {
+		t.Errorf("expected no override without promotion settings, got %v", got)
+	}
+}
@@ -0,0 +1,97 @@
+// Package notify tells users about things that need their attention, such
+// as resources that are about to expire.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Message is a notification for one or more users
+type Message struct {
+	To      []string // email addresses
+	Subject string
+	Body    string
+	Link    string // portal page the message is about, optional
+}
+
+// Notifier delivers messages
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New returns a notifier that posts to webhookURL, or one that only logs
+// when it is empty
+func New(webhookURL string) Notifier {
+	if webhookURL == "" {
+		return LogNotifier{}
+	}
+	return NewWebhookNotifier(webhookURL)
+}
+
+// LogNotifier writes messages to the server log
+type LogNotifier struct{}
+
+// Notify implements Notifier
+func (LogNotifier) Notify(_ context.Context, msg Message) error {
+	log.Printf("Notification to %s: %s", strings.Join(msg.To, ", "), msg.Subject)
+	return nil
+}
+
+// WebhookNotifier posts messages to a chat webhook (Slack or Google Chat
+// style, a JSON body with a text field)
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier for the given webhook URL
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Notify implements Notifier
+func (n *WebhookNotifier) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]string{"text": Text(msg)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Text renders a message as plain text
+func Text(msg Message) string {
+	var b strings.Builder
+	b.WriteString(msg.Subject)
+	if len(msg.To) > 0 {
+		b.WriteString(" (" + strings.Join(msg.To, ", ") + ")")
+	}
+	if msg.Body != "" {
+		b.WriteString("\n" + msg.Body)
+	}
+	if msg.Link != "" {
+		b.WriteString("\n" + msg.Link)
+	}
+	return b.String()
+}
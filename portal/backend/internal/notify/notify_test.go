@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := New(server.URL).Notify(context.Background(), Message{
+		To:      []string{"dev@example.com"},
+		Subject: "dev-1b4e28ba expires in 24h",
+		Body:    "Extend it or it will be decommissioned.",
+		Link:    "http://localhost:3000/resources/1",
+	})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	text := received["text"]
+	for _, want := range []string{"dev-1b4e28ba expires in 24h", "dev@example.com", "Extend it", "/resources/1"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in %q", want, text)
+		}
+	}
+}
+
+func TestWebhookNotifierError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	if err := New(server.URL).Notify(context.Background(), Message{Subject: "test"}); err == nil {
+		t.Error("expected an error for a rejected webhook")
+	}
+}
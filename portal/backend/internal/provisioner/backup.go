@@ -0,0 +1,91 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+// BackupTarget is a resource that is about to be destroyed
+type BackupTarget struct {
+	Module    string // module directory name, e.g. cloudsql
+	Name      string
+	ProjectID string
+	Outputs   models.JSON
+}
+
+// Backuper takes a final backup of a resource before it is destroyed. It
+// returns where the backup was written. The backup must outlive the
+// resource.
+type Backuper interface {
+	Backup(ctx context.Context, target BackupTarget, out io.Writer) (string, error)
+}
+
+// BackupSupported reports whether resources of a module can be backed up
+// before they are destroyed
+func BackupSupported(module string) bool {
+	return module == "cloudsql"
+}
+
+// GCloudBackup exports databases to Cloud Storage with the gcloud CLI.
+// On-demand Cloud SQL backups are deleted together with their instance,
+// so a final backup has to be an export.
+type GCloudBackup struct {
+	Binary string
+	Bucket string // GCS bucket exports are written to
+	Env    []string
+}
+
+// NewGCloudBackup creates a backuper for the given gcloud binary that
+// exports to bucket
+func NewGCloudBackup(binary, bucket string) *GCloudBackup {
+	if binary == "" {
+		binary = "gcloud"
+	}
+	return &GCloudBackup{Binary: binary, Bucket: bucket}
+}
+
+// Backup exports the database named in the resource's database_name output
+// from the Cloud SQL instance in its instance_name output to
+// gs://<bucket>/<resource name>/<time>.sql.gz. The instance's service
+// account needs write access to the bucket.
+func (b *GCloudBackup) Backup(ctx context.Context, target BackupTarget, out io.Writer) (string, error) {
+	if !BackupSupported(target.Module) {
+		return "", fmt.Errorf("backups are not supported for %s", target.Module)
+	}
+	if b.Bucket == "" {
+		return "", errors.New("final backups need a bucket; set BACKUP_BUCKET")
+	}
+	instance, _ := target.Outputs["instance_name"].(string)
+	if instance == "" {
+		return "", fmt.Errorf("resource %s has no instance_name output", target.Name)
+	}
+	database, _ := target.Outputs["database_name"].(string)
+	if database == "" {
+		return "", fmt.Errorf("resource %s has no database_name output", target.Name)
+	}
+
+	uri := fmt.Sprintf("gs://%s/%s/%s.sql.gz", b.Bucket, target.Name, time.Now().UTC().Format("20060102T150405Z"))
+	args := []string{"sql", "export", "sql", instance, uri,
+		"--database=" + database,
+		"--quiet",
+	}
+	if target.ProjectID != "" {
+		args = append(args, "--project="+target.ProjectID)
+	}
+
+	cmd := exec.CommandContext(ctx, b.Binary, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = append(os.Environ(), b.Env...)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("gcloud sql export sql: %w", err)
+	}
+	return uri, nil
+}
@@ -0,0 +1,437 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/artifacts"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/blueprint"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/cost"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/events"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNotProvisionable is returned when a request is not approved
+	ErrNotProvisionable = errors.New("request is not approved for provisioning")
+
+	// ErrStatusChanged is returned when a request changed status during a run
+	ErrStatusChanged = errors.New("request status changed during provisioning")
+)
+
+// Engine drives approved requests through plan and apply and persists
+// every status transition
+type Engine struct {
+	db       *gorm.DB
+	pipeline *Pipeline
+	wg       sync.WaitGroup
+
+	// Events receives status transitions and Terraform output when set
+	Events *events.Broker
+
+	// Artifacts keeps the plan files of every run when set
+	Artifacts artifacts.Store
+}
+
+// NewEngine creates a new provisioning engine
+func NewEngine(db *gorm.DB, pipeline *Pipeline) *Engine {
+	return &Engine{db: db, pipeline: pipeline}
+}
+
+// Provisionable reports whether a request in the given status can be provisioned
+func Provisionable(status string) bool {
+	return status == models.StatusApproved || status == models.StatusFailed
+}
+
+// Start provisions a request in the background
+func (e *Engine) Start(requestID uuid.UUID) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		if err := e.Provision(context.Background(), requestID); err != nil {
+			log.Printf("Provisioning request %s failed: %v", requestID, err)
+		}
+	}()
+}
+
+// Wait blocks until all background runs have finished
+func (e *Engine) Wait() {
+	e.wg.Wait()
+}
+
+// Provision runs plan and apply for an approved (or previously failed) request
+func (e *Engine) Provision(ctx context.Context, requestID uuid.UUID) error {
+	var request models.Request
+	if err := e.db.Preload("Environment").Preload("ResourceType").Preload("Resource").
+		First(&request, "id = ?", requestID).Error; err != nil {
+		return err
+	}
+
+	if !Provisionable(request.Status) {
+		return ErrNotProvisionable
+	}
+	if request.Kind == models.RequestKindBlueprint {
+		return e.provisionBlueprint(ctx, &request)
+	}
+
+	variables, err := repository.RequestVariables(e.db, &request)
+	if err != nil {
+		return e.abort(&request, fmt.Errorf("Failed to render terraform variables: %w", err))
+	}
+
+	job := Job{
+		RequestID:   request.ID,
+		Environment: request.Environment.Name,
+		ModulePath:  request.ResourceType.ModulePath,
+		Variables:   variables,
+	}
+	if request.Kind == models.RequestKindModify || request.Kind == models.RequestKindDecommission {
+		// Plan against the state the resource was created with
+		if request.Resource == nil || request.Resource.Status != models.ResourceActive {
+			return e.abort(&request, errors.New("target resource is not active"))
+		}
+		job.State = request.Resource.StatePrefix
+	}
+	if request.Kind == models.RequestKindDecommission {
+		if err := e.prepareDestroy(&request, &job); err != nil {
+			return e.abort(&request, err)
+		}
+	}
+
+	runs, err := newRunRecorder(e.db, e.Artifacts, request.ID)
+	if err != nil {
+		return e.abort(&request, fmt.Errorf("Failed to record run: %w", err))
+	}
+	logs := e.Events.Lines(request.ID)
+	if request.ParentID != nil {
+		logs.Component(*request.ParentID, request.Component)
+	}
+	defer logs.Flush()
+	job.Log = io.MultiWriter(logs, runs)
+
+	var backup string
+	job.BackedUp = func(location string) {
+		backup = location
+		if err := runs.backedUp(location); err != nil {
+			log.Printf("Recording final backup of request %s failed: %v", request.ID, err)
+		}
+	}
+
+	current := request.Status
+	err = e.pipeline.Run(ctx, job, func(status, output string) error {
+		if err := e.transition(&request, current, status, output); err != nil {
+			return err
+		}
+		current = status
+
+		switch status {
+		case models.StatusPlanning:
+			if err := runs.start(models.RunPlan); err != nil {
+				log.Printf("Recording plan run for request %s failed: %v", request.ID, err)
+			}
+		case models.StatusPlanned:
+			e.savePlan(ctx, request.ID, runs)
+			if err := runs.finish(nil); err != nil {
+				log.Printf("Recording plan run for request %s failed: %v", request.ID, err)
+			}
+		case models.StatusApplying:
+			operation := models.RunApply
+			if job.Destroy {
+				operation = models.RunDestroy
+			}
+			if err := runs.start(operation); err != nil {
+				log.Printf("Recording %s run for request %s failed: %v", operation, request.ID, err)
+			}
+		}
+		if status == models.StatusApplied && request.Kind == models.RequestKindDecommission {
+			if err := e.destroyResource(&request, backup); err != nil {
+				log.Printf("Marking resource destroyed for request %s failed: %v", request.ID, err)
+			}
+		} else if status == models.StatusApplied {
+			if err := e.recordResource(ctx, &request, job); err != nil {
+				log.Printf("Recording resource for request %s failed: %v", request.ID, err)
+			}
+		}
+		return nil
+	})
+	if finishErr := runs.finish(err); finishErr != nil {
+		log.Printf("Recording run for request %s failed: %v", request.ID, finishErr)
+	}
+	return err
+}
+
+// provisionBlueprint provisions the components of a blueprint request in
+// dependency order, wiring the outputs of each component into the ones that
+// read them. Components applied by an earlier attempt are skipped, so a
+// failed blueprint resumes where it stopped.
+func (e *Engine) provisionBlueprint(ctx context.Context, parent *models.Request) error {
+	var components []models.Request
+	if err := e.db.Where("parent_id = ?", parent.ID).Find(&components).Error; err != nil {
+		return err
+	}
+
+	byName := make(map[string]*models.Request, len(components))
+	view := make([]blueprint.Component, len(components))
+	for i := range components {
+		component := &components[i]
+		byName[component.Component] = component
+		view[i] = blueprint.Component{Name: component.Component, Wiring: component.Wiring, DependsOn: component.DependsOn}
+	}
+	order, err := blueprint.Order(view)
+	if err != nil {
+		return e.abort(parent, err)
+	}
+
+	if err := e.transition(parent, parent.Status, models.StatusApplying, ""); err != nil {
+		return err
+	}
+
+	outputs := make(map[string]models.JSON, len(components))
+	for _, name := range order {
+		component := byName[name]
+		if component.Status != models.StatusApplied {
+			if err := e.provisionComponent(ctx, component, outputs); err != nil {
+				cause := fmt.Errorf("Component %s failed: %w", name, err)
+				if terr := e.transition(parent, models.StatusApplying, models.StatusFailed, cause.Error()); terr != nil {
+					return terr
+				}
+				return cause
+			}
+		}
+
+		var resource models.Resource
+		if err := e.db.Select("outputs").First(&resource, "request_id = ?", component.ID).Error; err != nil {
+			cause := fmt.Errorf("Component %s has no recorded resource: %w", name, err)
+			if terr := e.transition(parent, models.StatusApplying, models.StatusFailed, cause.Error()); terr != nil {
+				return terr
+			}
+			return cause
+		}
+		outputs[name] = resource.Outputs
+	}
+
+	return e.transition(parent, models.StatusApplying, models.StatusApplied, "")
+}
+
+// provisionComponent resolves a component's wiring against the outputs of
+// the components before it and provisions it
+func (e *Engine) provisionComponent(ctx context.Context, component *models.Request, outputs map[string]models.JSON) error {
+	wired, err := blueprint.Resolve(component.Wiring, outputs)
+	if err != nil {
+		return err
+	}
+	for variable, value := range wired {
+		if value == RedactedOutput {
+			return fmt.Errorf("%s is wired to a sensitive output", variable)
+		}
+	}
+	if err := e.db.Model(component).Update("wired_variables", models.JSON(wired)).Error; err != nil {
+		return err
+	}
+	return e.Provision(ctx, component.ID)
+}
+
+// abort fails a request before the pipeline starts
+func (e *Engine) abort(request *models.Request, cause error) error {
+	if err := e.transition(request, request.Status, models.StatusFailed, cause.Error()); err != nil {
+		return err
+	}
+	return cause
+}
+
+// DeletionProtected reports whether a request's resource has deletion
+// protection turned on, either in the rendered variables or by the
+// module's default. Environment and ResourceType must be loaded.
+func (e *Engine) DeletionProtected(request *models.Request) (bool, error) {
+	variables, err := repository.RequestVariables(e.db, request)
+	if err != nil {
+		return false, err
+	}
+	return e.pipeline.Workspaces.DeletionProtected(request.ResourceType.ModulePath, variables)
+}
+
+// prepareDestroy turns a decommission request's job into a destroy. A
+// protected resource is only destroyed when the request says so, in which
+// case protection is turned off first.
+func (e *Engine) prepareDestroy(request *models.Request, job *Job) error {
+	job.Destroy = true
+
+	protected, err := e.pipeline.Workspaces.DeletionProtected(job.ModulePath, job.Variables)
+	if err != nil {
+		return err
+	}
+	if protected {
+		if !request.DisableDeletionProtection {
+			return errors.New("resource has deletion protection enabled")
+		}
+		job.Variables[DeletionProtectionVariable] = false
+		job.Unprotect = true
+	}
+
+	if request.FinalBackup {
+		job.Backup = &BackupTarget{
+			Module:    filepath.Base(job.ModulePath),
+			Name:      request.Resource.Name,
+			ProjectID: request.Environment.GCPProjectID,
+			Outputs:   request.Resource.Outputs,
+		}
+	}
+	return nil
+}
+
+// destroyResource marks a decommissioned resource as destroyed. backup is
+// where its final backup was written, if one was taken.
+func (e *Engine) destroyResource(request *models.Request, backup string) error {
+	if err := repository.DestroyResource(e.db, request); err != nil {
+		return err
+	}
+	values := models.JSON{"request_id": request.ID, "final_backup": request.FinalBackup}
+	if backup != "" {
+		values["backup_uri"] = backup
+	}
+	audit := models.AuditLog{
+		Action:       "destroy",
+		ResourceType: "resource",
+		ResourceID:   request.ResourceID,
+		NewValues:    values,
+	}
+	return e.db.Create(&audit).Error
+}
+
+// recordResource adds the applied request to the resource inventory. A
+// resource is recorded even when its outputs cannot be read.
+func (e *Engine) recordResource(ctx context.Context, request *models.Request, job Job) error {
+	var outputs models.JSON
+	var buf bytes.Buffer
+	if err := e.pipeline.Runner.Output(ctx, e.pipeline.Workspaces.Dir(request.ID), &buf); err != nil {
+		log.Printf("Reading outputs for request %s failed: %v", request.ID, err)
+	} else if outputs, err = ParseOutputs(buf.Bytes()); err != nil {
+		log.Printf("Reading outputs for request %s failed: %v", request.ID, err)
+	}
+
+	name := tfvars.ResourceName(request.Environment.Name, request.ID)
+	resource, err := repository.SaveResource(e.db, request, name, job.StatePrefix(), outputs)
+	if err != nil {
+		return err
+	}
+
+	action := "create"
+	if request.Kind == models.RequestKindModify {
+		action = "update"
+	}
+	audit := models.AuditLog{
+		Action:       action,
+		ResourceType: "resource",
+		ResourceID:   &resource.ID,
+		NewValues:    models.JSON{"request_id": request.ID, "configuration": resource.Configuration},
+	}
+	return e.db.Create(&audit).Error
+}
+
+// savePlan keeps the saved plan and its JSON rendering as artifacts of the
+// plan run and prices the plan. Both are informational, so failures are
+// only logged.
+func (e *Engine) savePlan(ctx context.Context, requestID uuid.UUID, runs *runRecorder) {
+	if e.Artifacts == nil && e.pipeline.Costs == nil {
+		return
+	}
+	dir := e.pipeline.Workspaces.Dir(requestID)
+
+	if e.Artifacts != nil {
+		if f, err := os.Open(filepath.Join(dir, PlanFile)); err != nil {
+			log.Printf("Saving plan for request %s failed: %v", requestID, err)
+		} else {
+			if err := runs.attach(ctx, models.ArtifactPlan, "application/octet-stream", f); err != nil {
+				log.Printf("Saving plan for request %s failed: %v", requestID, err)
+			}
+			f.Close()
+		}
+	}
+
+	var plan bytes.Buffer
+	if err := e.pipeline.Runner.ShowPlan(ctx, dir, &plan); err != nil {
+		log.Printf("Rendering plan for request %s failed: %v", requestID, err)
+		return
+	}
+	if e.Artifacts != nil {
+		if err := runs.attach(ctx, models.ArtifactPlanJSON, "application/json", bytes.NewReader(plan.Bytes())); err != nil {
+			log.Printf("Saving plan JSON for request %s failed: %v", requestID, err)
+		}
+	}
+	if e.pipeline.Costs != nil {
+		if err := e.pricePlan(ctx, requestID, plan.Bytes()); err != nil {
+			log.Printf("Pricing plan for request %s failed: %v", requestID, err)
+		}
+	}
+}
+
+// pricePlan runs a plan rendered with terraform show -json through
+// Infracost and stores the result on the request
+func (e *Engine) pricePlan(ctx context.Context, requestID uuid.UUID, plan []byte) error {
+	planJSON := filepath.Join(e.pipeline.Workspaces.Dir(requestID), models.ArtifactPlanJSON)
+	if err := os.WriteFile(planJSON, plan, 0o640); err != nil {
+		return err
+	}
+
+	report, err := e.pipeline.Costs.Breakdown(ctx, planJSON)
+	if err != nil {
+		return err
+	}
+	planCost, err := cost.ParseInfracost(report)
+	if err != nil {
+		return err
+	}
+	planCost.Source = cost.PlanCostSourcePlan
+	return repository.SavePlanCost(e.db, requestID, planCost)
+}
+
+// transition moves a request from one status to another. The update only
+// succeeds if the request is still in the expected status, so a request
+// cancelled mid-run is never overwritten. The status change of a blueprint
+// component is also published to its blueprint request.
+func (e *Engine) transition(request *models.Request, from, to, output string) error {
+	updates := map[string]interface{}{"status": to}
+	switch to {
+	case models.StatusPlanned, models.StatusFailed:
+		updates["terraform_plan"] = output
+	}
+	if to == models.StatusApplied || to == models.StatusFailed {
+		updates["completed_at"] = time.Now()
+	}
+
+	result := e.db.Model(&models.Request{}).
+		Where("id = ? AND status = ?", request.ID, from).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
+	e.Events.Publish(request.ID, events.TypeStatus, events.StatusChange{From: from, To: to})
+	if request.ParentID != nil {
+		e.Events.Publish(*request.ParentID, events.TypeStatus,
+			events.StatusChange{From: from, To: to, Component: request.Component})
+	}
+
+	audit := models.AuditLog{
+		Action:       "status_change",
+		ResourceType: "request",
+		ResourceID:   &request.ID,
+		OldValues:    models.JSON{"status": from},
+		NewValues:    models.JSON{"status": to},
+	}
+	return e.db.Create(&audit).Error
+}
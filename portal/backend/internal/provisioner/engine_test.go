@@ -0,0 +1,77 @@
+package provisioner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/events"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/testdb"
+)
+
+func TestProvisionBlueprintPublishesComponentEvents(t *testing.T) {
+	db := testdb.New(t)
+	environment := models.Environment{Name: "dev", DisplayName: "Development", Region: "us-central1"}
+	resourceType := models.ResourceType{Name: "redis", ModulePath: "terraform/modules/redis", ConfigSchema: models.JSON{"type": "object"}}
+	requester := models.User{Email: "jane@example.com", Name: "jane"}
+	for _, value := range []interface{}{&environment, &resourceType, &requester} {
+		if err := db.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parent := models.Request{
+		Title:         "stack",
+		Kind:          models.RequestKindBlueprint,
+		RequesterID:   requester.ID,
+		EnvironmentID: environment.ID,
+		Configuration: models.JSON{},
+		Status:        models.StatusApproved,
+	}
+	if err := db.Create(&parent).Error; err != nil {
+		t.Fatal(err)
+	}
+	component := models.Request{
+		Title:          "stack cache",
+		RequesterID:    requester.ID,
+		EnvironmentID:  environment.ID,
+		ResourceTypeID: &resourceType.ID,
+		Configuration:  models.JSON{"memory_size_gb": 1},
+		Status:         models.StatusApproved,
+		ParentID:       &parent.ID,
+		Component:      "cache",
+	}
+	if err := db.Create(&component).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewEngine(db, &Pipeline{Workspaces: newTestWorkspaces(t), Runner: NewFakeRunner()})
+	engine.Events = events.NewBroker(0, 0)
+	if err := engine.Provision(context.Background(), parent.ID); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+
+	replay, _, cancel := engine.Events.Subscribe(parent.ID, 0)
+	defer cancel()
+	var statuses, lines int
+	for _, event := range replay {
+		switch data := event.Data.(type) {
+		case events.StatusChange:
+			if data.Component == "cache" {
+				statuses++
+			}
+		case events.Log:
+			if data.Component != "cache" {
+				t.Errorf("expected log lines to be tagged with the component, got %+v", data)
+			}
+			lines++
+		}
+	}
+	// planning, planned, applying and applied
+	if statuses != 4 {
+		t.Errorf("expected 4 component status changes on the blueprint, got %d in %+v", statuses, replay)
+	}
+	if lines == 0 {
+		t.Error("expected the component's output on the blueprint")
+	}
+}
@@ -0,0 +1,150 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FakeRunner is a Runner that never calls Terraform. It is used in tests
+// and local development where no GCP credentials are available.
+type FakeRunner struct {
+	mu sync.Mutex
+
+	// Errors maps a step name (init, plan, destroy, apply, backup) to the
+	// error it returns. destroy-apply fails only the apply of a destroy
+	// plan.
+	Errors map[string]error
+
+	// Calls records every step in the order it was run
+	Calls []string
+
+	// Applied records the variables of every apply, in order
+	Applied []map[string]interface{}
+
+	// Outputs are returned by Output
+	Outputs map[string]interface{}
+}
+
+// NewFakeRunner creates a fake runner that succeeds on every step
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{Errors: map[string]error{}}
+}
+
+// Init pretends to initialise the workspace
+func (r *FakeRunner) Init(ctx context.Context, dir string, out io.Writer) error {
+	return r.step(ctx, "init", out, "Terraform has been successfully initialized!")
+}
+
+// Plan writes an empty plan file and a canned summary
+func (r *FakeRunner) Plan(ctx context.Context, dir string, out io.Writer) error {
+	if err := r.step(ctx, "plan", out, "Plan: 1 to add, 0 to change, 0 to destroy."); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, PlanFile), []byte{}, 0o600)
+}
+
+// fakeDestroyPlan marks a plan file written by PlanDestroy
+const fakeDestroyPlan = "destroy"
+
+// PlanDestroy writes a destroy plan file and a canned destroy summary
+func (r *FakeRunner) PlanDestroy(ctx context.Context, dir string, out io.Writer) error {
+	if err := r.step(ctx, "destroy", out, "Plan: 0 to add, 0 to change, 1 to destroy."); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, PlanFile), []byte(fakeDestroyPlan), 0o600)
+}
+
+// Apply pretends to apply the saved plan
+func (r *FakeRunner) Apply(ctx context.Context, dir string, out io.Writer) error {
+	plan, err := os.ReadFile(filepath.Join(dir, PlanFile))
+	if err != nil {
+		return fmt.Errorf("no saved plan: %w", err)
+	}
+	var variables map[string]interface{}
+	if data, err := os.ReadFile(filepath.Join(dir, "terraform.tfvars.json")); err == nil {
+		json.Unmarshal(data, &variables)
+	}
+
+	r.mu.Lock()
+	r.Applied = append(r.Applied, variables)
+	r.mu.Unlock()
+
+	if string(plan) == fakeDestroyPlan {
+		return r.step(ctx, "apply", out, "Destroy complete! Resources: 1 destroyed.", "destroy-apply")
+	}
+	return r.step(ctx, "apply", out, "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.")
+}
+
+// ShowPlan writes a plan JSON document without resource changes
+func (r *FakeRunner) ShowPlan(ctx context.Context, dir string, out io.Writer) error {
+	r.mu.Lock()
+	r.Calls = append(r.Calls, "show")
+	err := r.Errors["show"]
+	r.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, PlanFile)); err != nil {
+		return fmt.Errorf("no saved plan: %w", err)
+	}
+	_, err = io.WriteString(out, `{"format_version":"1.2","resource_changes":[]}`)
+	return err
+}
+
+// Output writes the outputs set in Outputs
+func (r *FakeRunner) Output(ctx context.Context, dir string, out io.Writer) error {
+	r.mu.Lock()
+	r.Calls = append(r.Calls, "output")
+	err := r.Errors["output"]
+	outputs := r.Outputs
+	r.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	doc := map[string]interface{}{}
+	for name, value := range outputs {
+		doc[name] = map[string]interface{}{"value": value, "sensitive": false}
+	}
+	return json.NewEncoder(out).Encode(doc)
+}
+
+// Backup pretends to take a final backup, so the fake can stand in for a
+// Backuper too
+func (r *FakeRunner) Backup(ctx context.Context, target BackupTarget, out io.Writer) (string, error) {
+	location := fmt.Sprintf("fake://backups/%s/%s.sql.gz", target.Module, target.Name)
+	if err := r.step(ctx, "backup", out, "Backup exported to "+location+"."); err != nil {
+		return "", err
+	}
+	return location, nil
+}
+
+// step records a call to name. It fails with the error set for name or for
+// any of the extra error keys.
+func (r *FakeRunner) step(ctx context.Context, name string, out io.Writer, message string, keys ...string) error {
+	r.mu.Lock()
+	r.Calls = append(r.Calls, name)
+	err := r.Errors[name]
+	for _, key := range keys {
+		if err == nil {
+			err = r.Errors[key]
+		}
+	}
+	r.mu.Unlock()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Error: %v\n", err)
+		return err
+	}
+	fmt.Fprintln(out, message)
+	return nil
+}
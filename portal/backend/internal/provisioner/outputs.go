@@ -0,0 +1,33 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+// RedactedOutput replaces the value of sensitive outputs
+const RedactedOutput = "(sensitive)"
+
+// ParseOutputs reads `terraform output -json` into a map of output name to
+// value. Sensitive values are never stored.
+func ParseOutputs(data []byte) (models.JSON, error) {
+	var raw map[string]struct {
+		Sensitive bool        `json:"sensitive"`
+		Value     interface{} `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid terraform output: %w", err)
+	}
+
+	outputs := models.JSON{}
+	for name, output := range raw {
+		if output.Sensitive {
+			outputs[name] = RedactedOutput
+			continue
+		}
+		outputs[name] = output.Value
+	}
+	return outputs, nil
+}
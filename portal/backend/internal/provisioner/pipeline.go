@@ -0,0 +1,178 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+// TransitionFunc is called every time a job moves to a new status. Output
+// holds the Terraform output collected so far.
+type TransitionFunc func(status, output string) error
+
+// PlanCoster prices a plan saved with terraform show -json, returning
+// Infracost JSON output
+type PlanCoster interface {
+	Breakdown(ctx context.Context, planJSON string) ([]byte, error)
+}
+
+// Pipeline renders a workspace and runs plan then apply for a job
+type Pipeline struct {
+	Workspaces *Workspaces
+	Runner     Runner
+
+	// Costs prices every plan when set
+	Costs PlanCoster
+
+	// Backups takes final backups before a destroy
+	Backups Backuper
+}
+
+// Run drives a job through planning, planned, applying and applied. Any
+// error moves the job to failed. If a transition is rejected the run stops
+// without touching Terraform any further.
+func (p *Pipeline) Run(ctx context.Context, job Job, transition TransitionFunc) error {
+	var out bytes.Buffer
+	var w io.Writer = &out
+	if job.Log != nil {
+		w = io.MultiWriter(&out, job.Log)
+	}
+
+	if err := transition(models.StatusPlanning, ""); err != nil {
+		return err
+	}
+
+	dir, err := p.Workspaces.Prepare(job)
+	if err != nil {
+		return p.fail(transition, &out, w, err)
+	}
+	if err := p.Runner.Init(ctx, dir, w); err != nil {
+		return p.fail(transition, &out, w, err)
+	}
+
+	// Destroying a protected resource takes two steps: protection is lifted
+	// with a regular apply, then the destroy is planned against the updated
+	// state. If the destroy does not go through, protection is put back.
+	unprotected := false
+	failed := func(cause error) error {
+		if unprotected {
+			cause = p.protect(ctx, job, dir, w, cause)
+		}
+		return p.fail(transition, &out, w, cause)
+	}
+	rejected := func(err error) error {
+		if unprotected {
+			if perr := p.protect(ctx, job, dir, w, err); perr != err {
+				log.Printf("Request %s: %v", job.RequestID, perr)
+			}
+		}
+		return err
+	}
+
+	if job.Destroy && job.Unprotect {
+		unprotected = true
+		if err := p.unprotect(ctx, dir, w); err != nil {
+			return failed(err)
+		}
+	}
+	if err := p.plan(ctx, job, dir, w); err != nil {
+		return failed(err)
+	}
+
+	if err := transition(models.StatusPlanned, out.String()); err != nil {
+		return rejected(err)
+	}
+	if err := transition(models.StatusApplying, out.String()); err != nil {
+		return rejected(err)
+	}
+
+	if job.Destroy && job.Backup != nil {
+		location, err := p.backup(ctx, *job.Backup, w)
+		if err != nil {
+			return failed(err)
+		}
+		if job.BackedUp != nil {
+			job.BackedUp(location)
+		}
+	}
+	if err := p.Runner.Apply(ctx, dir, w); err != nil {
+		return failed(err)
+	}
+
+	return transition(models.StatusApplied, out.String())
+}
+
+// plan saves the plan for the job
+func (p *Pipeline) plan(ctx context.Context, job Job, dir string, out io.Writer) error {
+	if job.Destroy {
+		return p.Runner.PlanDestroy(ctx, dir, out)
+	}
+	return p.Runner.Plan(ctx, dir, out)
+}
+
+// unprotect applies the job's variables, which turn deletion protection off
+func (p *Pipeline) unprotect(ctx context.Context, dir string, out io.Writer) error {
+	io.WriteString(out, "Turning off deletion protection\n")
+	if err := p.Runner.Plan(ctx, dir, out); err != nil {
+		return err
+	}
+	return p.Runner.Apply(ctx, dir, out)
+}
+
+// protect turns deletion protection back on after a destroy that lifted it
+// failed, by applying the job's variables with protection on. This also
+// restores anything a partial destroy removed, matching the inventory,
+// which still lists the resource as active. It returns cause, with the
+// reason protection could not be restored if it failed.
+func (p *Pipeline) protect(ctx context.Context, job Job, dir string, out io.Writer, cause error) error {
+	io.WriteString(out, "Destroy did not finish; turning deletion protection back on\n")
+
+	// Restore protection even if the run was cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	variables := make(map[string]interface{}, len(job.Variables))
+	for name, value := range job.Variables {
+		variables[name] = value
+	}
+	variables[DeletionProtectionVariable] = true
+
+	err := WriteVariables(dir, variables)
+	if err == nil {
+		err = p.Runner.Plan(ctx, dir, out)
+	}
+	if err == nil {
+		err = p.Runner.Apply(ctx, dir, out)
+	}
+	if err != nil {
+		return fmt.Errorf("%w; deletion protection is still off: %v", cause, err)
+	}
+	return cause
+}
+
+func (p *Pipeline) backup(ctx context.Context, target BackupTarget, out io.Writer) (string, error) {
+	if p.Backups == nil {
+		return "", errors.New("final backup requested but no backup tool is configured")
+	}
+	io.WriteString(out, "Taking final backup\n")
+	location, err := p.Backups.Backup(ctx, target, out)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(out, "Final backup written to %s\n", location)
+	return location, nil
+}
+
+// fail records the cause in the output and moves the job to failed. out
+// collects the output; w is where it is written.
+func (p *Pipeline) fail(transition TransitionFunc, out *bytes.Buffer, w io.Writer, cause error) error {
+	io.WriteString(w, "\n"+cause.Error()+"\n")
+	if err := transition(models.StatusFailed, out.String()); err != nil {
+		return err
+	}
+	return cause
+}
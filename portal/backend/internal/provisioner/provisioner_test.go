@@ -0,0 +1,465 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func newTestWorkspaces(t *testing.T) *Workspaces {
+	t.Helper()
+
+	repo := t.TempDir()
+	module := filepath.Join(repo, "terraform", "modules", "redis")
+	if err := os.MkdirAll(module, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(module, "main.tf"), []byte("# main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Workspaces{BaseDir: t.TempDir(), RepoDir: repo}
+}
+
+func testJob() Job {
+	return Job{
+		RequestID:   uuid.New(),
+		Environment: "dev",
+		ModulePath:  "terraform/modules/redis",
+		Variables:   map[string]interface{}{"memory_size_gb": 1},
+	}
+}
+
+func TestWorkspacePrepare(t *testing.T) {
+	w := newTestWorkspaces(t)
+	w.StateBucket = "state-bucket"
+	job := testJob()
+
+	dir, err := w.Prepare(job)
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "main.tf")); err != nil {
+		t.Errorf("module file not copied: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "terraform.tfvars.json"))
+	if err != nil {
+		t.Fatalf("tfvars not written: %v", err)
+	}
+	var vars map[string]interface{}
+	if err := json.Unmarshal(data, &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["memory_size_gb"] != float64(1) {
+		t.Errorf("unexpected tfvars: %v", vars)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "backend.tf.json"))
+	if err != nil {
+		t.Fatalf("backend not written: %v", err)
+	}
+	if !json.Valid(data) {
+		t.Error("backend.tf.json should be valid JSON")
+	}
+}
+
+func TestWorkspaceLocalState(t *testing.T) {
+	w := newTestWorkspaces(t)
+	job := testJob()
+	job.State = "portal/dev/existing"
+
+	dir, err := w.Prepare(job)
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "backend.tf.json"))
+	if err != nil {
+		t.Fatalf("backend not written: %v", err)
+	}
+	var backend struct {
+		Terraform struct {
+			Backend struct {
+				Local struct {
+					Path string `json:"path"`
+				} `json:"local"`
+			} `json:"backend"`
+		} `json:"terraform"`
+	}
+	if err := json.Unmarshal(data, &backend); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := filepath.Join(w.BaseDir, "state", "portal", "dev", "existing", "terraform.tfstate")
+	if backend.Terraform.Backend.Local.Path != expected {
+		t.Errorf("expected state at %s, got %s", expected, backend.Terraform.Backend.Local.Path)
+	}
+}
+
+func TestWorkspaceRejectsPathOutsideRepo(t *testing.T) {
+	w := newTestWorkspaces(t)
+	job := testJob()
+	job.ModulePath = "../../etc"
+
+	if _, err := w.Prepare(job); err == nil {
+		t.Error("expected error for module path outside repository")
+	}
+}
+
+func TestPipelineRunSuccess(t *testing.T) {
+	runner := NewFakeRunner()
+	p := &Pipeline{Workspaces: newTestWorkspaces(t), Runner: runner}
+
+	var statuses []string
+	err := p.Run(context.Background(), testJob(), func(status, output string) error {
+		statuses = append(statuses, status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	expected := []string{
+		models.StatusPlanning,
+		models.StatusPlanned,
+		models.StatusApplying,
+		models.StatusApplied,
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected transitions %v, got %v", expected, statuses)
+	}
+	if !reflect.DeepEqual(runner.Calls, []string{"init", "plan", "apply"}) {
+		t.Errorf("unexpected runner calls: %v", runner.Calls)
+	}
+}
+
+func TestPipelineRunStreamsLog(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.Errors["apply"] = errors.New("quota exceeded")
+	p := &Pipeline{Workspaces: newTestWorkspaces(t), Runner: runner}
+
+	var log bytes.Buffer
+	job := testJob()
+	job.Log = &log
+
+	var lastOutput string
+	p.Run(context.Background(), job, func(status, output string) error {
+		lastOutput = output
+		return nil
+	})
+
+	if log.String() != lastOutput {
+		t.Errorf("streamed log %q differs from recorded output %q", log.String(), lastOutput)
+	}
+	if !strings.Contains(log.String(), "quota exceeded") {
+		t.Errorf("expected the failure in the log, got %q", log.String())
+	}
+}
+
+func TestPipelineRunPlanFailure(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.Errors["plan"] = errors.New("invalid value for variable")
+	p := &Pipeline{Workspaces: newTestWorkspaces(t), Runner: runner}
+
+	var statuses []string
+	var lastOutput string
+	err := p.Run(context.Background(), testJob(), func(status, output string) error {
+		statuses = append(statuses, status)
+		lastOutput = output
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected plan error")
+	}
+
+	expected := []string{models.StatusPlanning, models.StatusFailed}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected transitions %v, got %v", expected, statuses)
+	}
+	if lastOutput == "" {
+		t.Error("failure output should be recorded")
+	}
+	for _, call := range runner.Calls {
+		if call == "apply" {
+			t.Error("apply should not run after a failed plan")
+		}
+	}
+}
+
+func TestPipelineStopsWhenTransitionRejected(t *testing.T) {
+	runner := NewFakeRunner()
+	p := &Pipeline{Workspaces: newTestWorkspaces(t), Runner: runner}
+
+	err := p.Run(context.Background(), testJob(), func(status, output string) error {
+		if status == models.StatusApplying {
+			return ErrStatusChanged
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrStatusChanged) {
+		t.Fatalf("expected ErrStatusChanged, got %v", err)
+	}
+	for _, call := range runner.Calls {
+		if call == "apply" {
+			t.Error("apply should not run once a transition is rejected")
+		}
+	}
+}
+
+func TestPipelineDestroy(t *testing.T) {
+	runner := NewFakeRunner()
+	p := &Pipeline{Workspaces: newTestWorkspaces(t), Runner: runner, Backups: runner}
+
+	job := testJob()
+	job.Destroy = true
+	job.Unprotect = true
+	job.Backup = &BackupTarget{Module: "cloudsql", Name: "dev-1b4e28ba"}
+	var backup string
+	job.BackedUp = func(location string) { backup = location }
+
+	var statuses []string
+	err := p.Run(context.Background(), job, func(status, output string) error {
+		statuses = append(statuses, status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if backup != "fake://backups/cloudsql/dev-1b4e28ba.sql.gz" {
+		t.Errorf("expected the backup location to be reported, got %q", backup)
+	}
+
+	if statuses[len(statuses)-1] != models.StatusApplied {
+		t.Errorf("expected the destroy to be applied, got %v", statuses)
+	}
+	expected := []string{"init", "plan", "apply", "destroy", "backup", "apply"}
+	if !reflect.DeepEqual(runner.Calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, runner.Calls)
+	}
+}
+
+func TestPipelineDestroyBackupFailure(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.Errors["backup"] = errors.New("instance not found")
+	p := &Pipeline{Workspaces: newTestWorkspaces(t), Runner: runner, Backups: runner}
+
+	job := testJob()
+	job.Destroy = true
+	job.Backup = &BackupTarget{Module: "cloudsql"}
+
+	var last string
+	err := p.Run(context.Background(), job, func(status, output string) error {
+		last = status
+		return nil
+	})
+	if err == nil || last != models.StatusFailed {
+		t.Fatalf("expected the run to fail, got %v in %s", err, last)
+	}
+	if runner.Calls[len(runner.Calls)-1] != "backup" {
+		t.Errorf("nothing should be destroyed after a failed backup: %v", runner.Calls)
+	}
+}
+
+func TestPipelineDestroyRestoresProtection(t *testing.T) {
+	for _, step := range []string{"backup", "destroy", "destroy-apply"} {
+		t.Run(step, func(t *testing.T) {
+			runner := NewFakeRunner()
+			runner.Errors[step] = errors.New("boom")
+			p := &Pipeline{Workspaces: newTestWorkspaces(t), Runner: runner, Backups: runner}
+
+			job := testJob()
+			job.Destroy = true
+			job.Unprotect = true
+			job.Variables = map[string]interface{}{"memory_size_gb": 1, DeletionProtectionVariable: false}
+			job.Backup = &BackupTarget{Module: "cloudsql", Name: "dev-1b4e28ba"}
+
+			var last string
+			err := p.Run(context.Background(), job, func(status, output string) error {
+				last = status
+				return nil
+			})
+			if err == nil || last != models.StatusFailed {
+				t.Fatalf("expected the run to fail, got %v in %s", err, last)
+			}
+
+			if len(runner.Applied) < 2 {
+				t.Fatalf("expected protection to be re-applied, got applies %v", runner.Applied)
+			}
+			if protected := runner.Applied[len(runner.Applied)-1][DeletionProtectionVariable]; protected != true {
+				t.Errorf("expected the last apply to turn deletion protection back on, got %v", protected)
+			}
+			if job.Variables[DeletionProtectionVariable] != false {
+				t.Error("restoring protection should not change the job's variables")
+			}
+		})
+	}
+}
+
+func TestGCloudBackup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	gcloud := filepath.Join(dir, "gcloud")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\n"
+	if err := os.WriteFile(gcloud, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	target := BackupTarget{
+		Module:    "cloudsql",
+		Name:      "dev-1b4e28ba",
+		ProjectID: "acme-dev",
+		Outputs:   map[string]interface{}{"instance_name": "dev-db", "database_name": "app"},
+	}
+	location, err := NewGCloudBackup(gcloud, "acme-backups").Backup(context.Background(), target, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if !strings.HasPrefix(location, "gs://acme-backups/dev-1b4e28ba/") || !strings.HasSuffix(location, ".sql.gz") {
+		t.Errorf("unexpected backup location %q", location)
+	}
+
+	data, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "sql export sql dev-db " + location + " --database=app --quiet --project=acme-dev\n"
+	if string(data) != expected {
+		t.Errorf("expected gcloud %q, got %q", expected, data)
+	}
+
+	if _, err := NewGCloudBackup(gcloud, "").Backup(context.Background(), target, &bytes.Buffer{}); err == nil {
+		t.Error("expected a backup without a bucket to fail")
+	}
+}
+
+func TestDeletionProtected(t *testing.T) {
+	w := newTestWorkspaces(t)
+	module := filepath.Join(w.RepoDir, "terraform", "modules", "redis")
+	variables := `variable "deletion_protection" {
+  type    = bool
+  default = true
+}
+`
+	if err := os.WriteFile(filepath.Join(module, "variables.tf"), []byte(variables), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		variables map[string]interface{}
+		expected  bool
+	}{
+		{"module default", map[string]interface{}{}, true},
+		{"turned off", map[string]interface{}{"deletion_protection": false}, false},
+		{"turned on", map[string]interface{}{"deletion_protection": true}, true},
+	}
+	for _, tt := range tests {
+		protected, err := w.DeletionProtected("terraform/modules/redis", tt.variables)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if protected != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, protected)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(module, "variables.tf")); err != nil {
+		t.Fatal(err)
+	}
+	if protected, _ := w.DeletionProtected("terraform/modules/redis", nil); protected {
+		t.Error("modules without the variable are never protected")
+	}
+}
+
+func TestProvisionable(t *testing.T) {
+	tests := []struct {
+		status   string
+		expected bool
+	}{
+		{models.StatusApproved, true},
+		{models.StatusFailed, true},
+		{models.StatusDraft, false},
+		{models.StatusPending, false},
+		{models.StatusApplied, false},
+		{models.StatusCancelled, false},
+	}
+
+	for _, tt := range tests {
+		if got := Provisionable(tt.status); got != tt.expected {
+			t.Errorf("Provisionable(%q) = %v, want %v", tt.status, got, tt.expected)
+		}
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	data := `{
+  "instance_name": {"sensitive": false, "type": "string", "value": "dev-1b4e28ba"},
+  "port": {"sensitive": false, "type": "number", "value": 6379},
+  "auth_string": {"sensitive": true, "type": "string", "value": "hunter2"}
+}`
+	outputs, err := ParseOutputs([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseOutputs failed: %v", err)
+	}
+
+	expected := models.JSON{
+		"instance_name": "dev-1b4e28ba",
+		"port":          float64(6379),
+		"auth_string":   RedactedOutput,
+	}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("expected %v, got %v", expected, outputs)
+	}
+
+	if _, err := ParseOutputs([]byte("Error: no state")); err == nil {
+		t.Error("expected an error for non-JSON output")
+	}
+}
+
+func TestFakeRunnerOutput(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.Outputs = map[string]interface{}{"host": "10.0.0.3"}
+
+	var buf bytes.Buffer
+	if err := runner.Output(context.Background(), t.TempDir(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := ParseOutputs(buf.Bytes())
+	if err != nil {
+		t.Fatalf("fake output should parse: %v", err)
+	}
+	if outputs["host"] != "10.0.0.3" {
+		t.Errorf("unexpected outputs: %v", outputs)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if code := ExitCode(nil); code == nil || *code != 0 {
+		t.Errorf("expected 0 for success, got %v", code)
+	}
+
+	err := fmt.Errorf("terraform apply: %w", exec.Command("sh", "-c", "exit 3").Run())
+	if code := ExitCode(err); code == nil || *code != 3 {
+		t.Errorf("expected exit status 3, got %v", code)
+	}
+
+	if code := ExitCode(ErrStatusChanged); code != nil {
+		t.Errorf("expected no exit status for an error without a command, got %d", *code)
+	}
+}
@@ -0,0 +1,121 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PlanFile is the name of the saved plan inside a workspace
+const PlanFile = "tfplan"
+
+// Runner executes Terraform commands inside a rendered workspace
+type Runner interface {
+	Init(ctx context.Context, dir string, out io.Writer) error
+	Plan(ctx context.Context, dir string, out io.Writer) error
+	Apply(ctx context.Context, dir string, out io.Writer) error
+
+	// PlanDestroy saves a plan that removes everything in the state
+	PlanDestroy(ctx context.Context, dir string, out io.Writer) error
+
+	// ShowPlan writes the saved plan as JSON to out
+	ShowPlan(ctx context.Context, dir string, out io.Writer) error
+
+	// Output writes the root module outputs as JSON to out
+	Output(ctx context.Context, dir string, out io.Writer) error
+}
+
+// ExitCode returns the exit status of the Terraform command behind err: 0
+// for nil, and nil when err did not come from a command that exited
+func ExitCode(err error) *int {
+	code := 0
+	if err == nil {
+		return &code
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() < 0 {
+		return nil
+	}
+	code = exitErr.ExitCode()
+	return &code
+}
+
+// TerraformRunner runs the terraform binary
+type TerraformRunner struct {
+	Binary string
+	Env    []string
+}
+
+// NewTerraformRunner creates a runner for the given terraform binary
+func NewTerraformRunner(binary string) *TerraformRunner {
+	if binary == "" {
+		binary = "terraform"
+	}
+	return &TerraformRunner{Binary: binary}
+}
+
+// Init runs terraform init
+func (r *TerraformRunner) Init(ctx context.Context, dir string, out io.Writer) error {
+	return r.run(ctx, dir, out, "init", "-input=false", "-no-color")
+}
+
+// Plan runs terraform plan and saves the plan to PlanFile
+func (r *TerraformRunner) Plan(ctx context.Context, dir string, out io.Writer) error {
+	return r.run(ctx, dir, out, "plan", "-input=false", "-no-color", "-out="+PlanFile)
+}
+
+// PlanDestroy runs terraform plan -destroy and saves the plan to PlanFile
+func (r *TerraformRunner) PlanDestroy(ctx context.Context, dir string, out io.Writer) error {
+	return r.run(ctx, dir, out, "plan", "-destroy", "-input=false", "-no-color", "-out="+PlanFile)
+}
+
+// Apply applies the saved plan
+func (r *TerraformRunner) Apply(ctx context.Context, dir string, out io.Writer) error {
+	return r.run(ctx, dir, out, "apply", "-input=false", "-no-color", PlanFile)
+}
+
+// ShowPlan runs terraform show -json on the saved plan. Only the JSON goes
+// to out; diagnostics are returned in the error.
+func (r *TerraformRunner) ShowPlan(ctx context.Context, dir string, out io.Writer) error {
+	return r.runJSON(ctx, dir, out, "show", "-json", "-no-color", PlanFile)
+}
+
+// Output runs terraform output -json
+func (r *TerraformRunner) Output(ctx context.Context, dir string, out io.Writer) error {
+	return r.runJSON(ctx, dir, out, "output", "-json", "-no-color")
+}
+
+// runJSON runs a command whose stdout is JSON, keeping diagnostics out of it
+func (r *TerraformRunner) runJSON(ctx context.Context, dir string, out io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.Binary, args...)
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "TF_IN_AUTOMATION=1")
+	cmd.Env = append(cmd.Env, r.Env...)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("terraform %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (r *TerraformRunner) run(ctx context.Context, dir string, out io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, r.Binary, args...)
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = append(os.Environ(), "TF_IN_AUTOMATION=1")
+	cmd.Env = append(cmd.Env, r.Env...)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("terraform %s: %w", args[0], err)
+	}
+	return nil
+}
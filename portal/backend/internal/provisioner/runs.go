@@ -0,0 +1,171 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/artifacts"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Output is stored once this much has accumulated or this long has passed
+// since the last chunk, whichever comes first
+const (
+	logChunkSize     = 16 << 10
+	logChunkInterval = time.Second
+)
+
+// runRecorder records the runs of one provisioning attempt. Output written
+// to it goes to the log of the current run in chunks, so a run can be
+// followed while it is in progress.
+type runRecorder struct {
+	db        *gorm.DB
+	store     artifacts.Store
+	requestID uuid.UUID
+	attempt   int
+
+	mu      sync.Mutex
+	current *models.Run
+	pending bytes.Buffer
+	seq     int
+	flushed time.Time
+}
+
+// newRunRecorder starts the next attempt for a request. Artifacts are not
+// kept when store is nil.
+func newRunRecorder(db *gorm.DB, store artifacts.Store, requestID uuid.UUID) (*runRecorder, error) {
+	var attempt int
+	if err := db.Model(&models.Run{}).Select("COALESCE(MAX(attempt), 0)").
+		Where("request_id = ?", requestID).Scan(&attempt).Error; err != nil {
+		return nil, err
+	}
+	return &runRecorder{db: db, store: store, requestID: requestID, attempt: attempt + 1}, nil
+}
+
+// start begins a run of the given operation
+func (r *runRecorder) start(operation string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run := models.Run{
+		RequestID: r.requestID,
+		Attempt:   r.attempt,
+		Operation: operation,
+		Status:    models.RunRunning,
+		StartedAt: time.Now(),
+	}
+	if err := r.db.Create(&run).Error; err != nil {
+		return err
+	}
+	r.current = &run
+	r.pending.Reset()
+	r.seq = 0
+	r.flushed = time.Now()
+	return nil
+}
+
+// finish ends the current run with the outcome of its last step. It does
+// nothing when no run is in progress.
+func (r *runRecorder) finish(cause error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return nil
+	}
+
+	err := r.flush()
+	updates := map[string]interface{}{
+		"status":      models.RunSucceeded,
+		"exit_code":   ExitCode(cause),
+		"finished_at": time.Now(),
+	}
+	if cause != nil {
+		updates["status"] = models.RunFailed
+		updates["error"] = cause.Error()
+	}
+	if updateErr := r.db.Model(r.current).Updates(updates).Error; err == nil {
+		err = updateErr
+	}
+	r.current = nil
+	return err
+}
+
+// backedUp records where the final backup taken during the current run was
+// written
+func (r *runRecorder) backedUp(location string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return nil
+	}
+	return r.db.Model(r.current).Update("backup_uri", location).Error
+}
+
+// Write adds output to the log of the current run. Failing to store the log
+// never fails the run; what could not be stored is retried with the next
+// chunk.
+func (r *runRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return len(p), nil
+	}
+
+	r.pending.Write(p)
+	if r.pending.Len() >= logChunkSize || time.Since(r.flushed) >= logChunkInterval {
+		if err := r.flush(); err != nil {
+			log.Printf("Storing log of run %s failed: %v", r.current.ID, err)
+		}
+	}
+	return len(p), nil
+}
+
+// flush stores the pending output as the next chunk. r.mu must be held.
+func (r *runRecorder) flush() error {
+	r.flushed = time.Now()
+	if r.pending.Len() == 0 {
+		return nil
+	}
+	chunk := models.RunLogChunk{RunID: r.current.ID, Seq: r.seq + 1, Data: r.pending.String()}
+	if err := r.db.Create(&chunk).Error; err != nil {
+		return err
+	}
+	r.seq++
+	r.pending.Reset()
+	return nil
+}
+
+// attach keeps an artifact of the current run
+func (r *runRecorder) attach(ctx context.Context, name, contentType string, data io.Reader) error {
+	r.mu.Lock()
+	run := r.current
+	r.mu.Unlock()
+	if run == nil || r.store == nil {
+		return nil
+	}
+
+	key := fmt.Sprintf("runs/%s/%s", run.ID, name)
+	hash := sha256.New()
+	size, err := r.store.Put(ctx, key, io.TeeReader(data, hash))
+	if err != nil {
+		return err
+	}
+	artifact := models.RunArtifact{
+		RunID:       run.ID,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		StorageKey:  key,
+	}
+	return r.db.Create(&artifact).Error
+}
@@ -0,0 +1,202 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfmodule"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
+	"github.com/google/uuid"
+)
+
+// Job describes a single request to be provisioned
+type Job struct {
+	RequestID   uuid.UUID
+	Environment string
+	ModulePath  string
+	Variables   map[string]interface{}
+
+	// State is the state prefix of an existing resource the job changes
+	State string
+
+	// Destroy removes everything in the state instead of applying Variables.
+	// Unprotect first applies Variables, which turn deletion protection
+	// off, and Backup is taken right before the destroy is applied.
+	// BackedUp, when set, is told where the backup was written.
+	Destroy   bool
+	Unprotect bool
+	Backup    *BackupTarget
+	BackedUp  func(location string)
+
+	// Log, when set, receives Terraform output as it is produced
+	Log io.Writer
+}
+
+// StatePrefix returns the remote state prefix for the job. New resources
+// get their own prefix; changes reuse the resource's.
+func (j Job) StatePrefix() string {
+	if j.State != "" {
+		return j.State
+	}
+	return fmt.Sprintf("portal/%s/%s", j.Environment, j.RequestID)
+}
+
+// Workspaces renders Terraform working directories for jobs
+type Workspaces struct {
+	// BaseDir is where working directories are created
+	BaseDir string
+
+	// RepoDir is the repository root that ModulePath is relative to
+	RepoDir string
+
+	// StateBucket is the GCS bucket for remote state. When empty, state is
+	// kept under BaseDir/state so it outlives the working directory.
+	StateBucket string
+}
+
+// Prepare creates a fresh working directory for the job. The module at
+// ModulePath is copied in as the root module and the job variables are
+// written to terraform.tfvars.json.
+func (w *Workspaces) Prepare(job Job) (string, error) {
+	moduleDir, err := w.ModuleDir(job.ModulePath)
+	if err != nil {
+		return "", err
+	}
+
+	dir := w.Dir(job.RequestID)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+
+	files, err := filepath.Glob(filepath.Join(moduleDir, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no terraform files in %s", job.ModulePath)
+	}
+	for _, src := range files {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(src)), data, 0o640); err != nil {
+			return "", err
+		}
+	}
+
+	if err := WriteVariables(dir, job.Variables); err != nil {
+		return "", err
+	}
+
+	backend, err := w.backend(job)
+	if err != nil {
+		return "", err
+	}
+	if err := writeJSON(filepath.Join(dir, "backend.tf.json"), backend); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// WriteVariables writes a working directory's terraform.tfvars.json
+func WriteVariables(dir string, variables map[string]interface{}) error {
+	tfvarsJSON, err := tfvars.Encode(variables)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "terraform.tfvars.json"), tfvarsJSON, 0o640)
+}
+
+// backend configures where the job's state lives
+func (w *Workspaces) backend(job Job) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	if w.StateBucket != "" {
+		config["gcs"] = map[string]interface{}{
+			"bucket": w.StateBucket,
+			"prefix": job.StatePrefix(),
+		}
+	} else {
+		path, err := filepath.Abs(filepath.Join(w.BaseDir, "state", filepath.FromSlash(job.StatePrefix()), "terraform.tfstate"))
+		if err != nil {
+			return nil, err
+		}
+		config["local"] = map[string]interface{}{"path": path}
+	}
+	return map[string]interface{}{
+		"terraform": map[string]interface{}{"backend": config},
+	}, nil
+}
+
+// DeletionProtectionVariable is the module variable the gke and cloudsql
+// modules use to guard against accidental destroys
+const DeletionProtectionVariable = "deletion_protection"
+
+// DeletionProtected reports whether deletion protection is on for a module
+// rendered with variables. Unset variables fall back to the module default.
+func (w *Workspaces) DeletionProtected(modulePath string, variables map[string]interface{}) (bool, error) {
+	if v, ok := variables[DeletionProtectionVariable]; ok {
+		protected, _ := v.(bool)
+		return protected, nil
+	}
+
+	dir, err := w.ModuleDir(modulePath)
+	if err != nil {
+		return false, err
+	}
+	moduleVars, err := tfmodule.Load(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range moduleVars {
+		if v.Name == DeletionProtectionVariable {
+			protected, _ := v.Default.(bool)
+			return protected, nil
+		}
+	}
+	return false, nil
+}
+
+// Dir returns the working directory for a request
+func (w *Workspaces) Dir(requestID uuid.UUID) string {
+	return filepath.Join(w.BaseDir, requestID.String())
+}
+
+// Cleanup removes the working directory for a request
+func (w *Workspaces) Cleanup(requestID uuid.UUID) error {
+	return os.RemoveAll(w.Dir(requestID))
+}
+
+// ModuleDir resolves a module path against the repository, refusing paths
+// that point outside of it
+func (w *Workspaces) ModuleDir(modulePath string) (string, error) {
+	if modulePath == "" {
+		return "", fmt.Errorf("resource type has no module path")
+	}
+
+	root, err := filepath.Abs(w.RepoDir)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, filepath.Clean(modulePath))
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("module path %q is outside the repository", modulePath)
+	}
+	return dir, nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o640)
+}
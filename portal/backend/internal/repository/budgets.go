@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// budgetedStatuses are the statuses of requests that count against a
+// budget: everything waiting for a decision, being provisioned or running
+var budgetedStatuses = append(append([]string{}, models.InFlightStatuses...), models.StatusApplied)
+
+// CommittedSpend sums the monthly cost of a team's requests in an
+// environment, leaving out the request being checked (and its components)
+// and requests whose resource has been destroyed. Blueprint requests are
+// counted through their components. A modify request prices the whole
+// resource, so only the latest request for each resource is counted, and
+// none when the request being checked modifies it.
+func CommittedSpend(db *gorm.DB, teamID, environmentID, excludeRequestID uuid.UUID) (float64, error) {
+	var total float64
+	err := db.Model(&models.Request{}).
+		Select("COALESCE(SUM(COALESCE(cost_delta, estimated_cost)), 0)").
+		Where("team_id = ? AND environment_id = ? AND id <> ? AND status IN ?",
+			teamID, environmentID, excludeRequestID, budgetedStatuses).
+		Where("kind <> ?", models.RequestKindBlueprint).
+		Where("parent_id IS NULL OR parent_id <> ?", excludeRequestID).
+		Where("resource_id IS NULL OR resource_id NOT IN (?)",
+			db.Model(&models.Resource{}).Select("id").Where("status = ?", models.ResourceDestroyed)).
+		Where("resource_id IS NULL OR NOT EXISTS (?)",
+			db.Table("requests AS later").Select("1").
+				Where("later.resource_id = requests.resource_id AND later.created_at > requests.created_at").
+				Where("later.kind = ? AND later.status IN ? AND later.deleted_at IS NULL",
+					models.RequestKindModify, budgetedStatuses)).
+		Where("resource_id IS NULL OR resource_id NOT IN (?)",
+			db.Model(&models.Request{}).Select("resource_id").
+				Where("id = ? AND kind = ? AND resource_id IS NOT NULL", excludeRequestID, models.RequestKindModify)).
+		Scan(&total).Error
+	return total, err
+}
+
+// CheckBudget evaluates a request against its team's budget for the
+// request's environment. It returns nil when the request has no team or
+// the team has no budget there.
+func CheckBudget(db *gorm.DB, request *models.Request) (*models.BudgetStatus, error) {
+	if request.TeamID == nil {
+		return nil, nil
+	}
+
+	var budget models.Budget
+	err := db.First(&budget, "team_id = ? AND environment_id = ?", *request.TeamID, request.EnvironmentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := CommittedSpend(db, *request.TeamID, request.EnvironmentID, request.ID)
+	if err != nil {
+		return nil, err
+	}
+	status := budget.Evaluate(committed, request.MonthlyCost())
+	return &status, nil
+}
+
+// IsTeamMember reports whether a user belongs to a team
+func IsTeamMember(db *gorm.DB, teamID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.TeamMembership{}).
+		Where("team_id = ? AND user_id = ?", teamID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// UserTeams returns the active teams a user belongs to
+func UserTeams(db *gorm.DB, userID uuid.UUID) ([]models.Team, error) {
+	var teams []models.Team
+	err := db.Where("is_active = ? AND id IN (?)", true,
+		db.Model(&models.TeamMembership{}).Select("team_id").Where("user_id = ?", userID)).
+		Order("name").Find(&teams).Error
+	return teams, err
+}
@@ -0,0 +1,87 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/testdb"
+	"gorm.io/gorm"
+)
+
+func mustCreate(t *testing.T, db *gorm.DB, values ...interface{}) {
+	t.Helper()
+	for _, value := range values {
+		if err := db.Create(value).Error; err != nil {
+			t.Fatalf("create %T: %v", value, err)
+		}
+	}
+}
+
+func TestCommittedSpendCountsModifiedResourceOnce(t *testing.T) {
+	db := testdb.New(t)
+	environment := models.Environment{Name: "dev"}
+	resourceType := models.ResourceType{Name: "redis", ConfigSchema: models.JSON{"type": "object"}}
+	user := models.User{Email: "jane@example.com", Name: "jane"}
+	team := models.Team{Name: "payments"}
+	mustCreate(t, db, &environment, &resourceType, &user, &team)
+	mustCreate(t, db, &models.Budget{TeamID: team.ID, EnvironmentID: environment.ID, MonthlyAmount: 250, Policy: models.BudgetPolicyBlock})
+
+	created := time.Now().Add(-time.Hour)
+	request := func(kind, status string, cost float64, at time.Time) models.Request {
+		r := models.Request{
+			Title:          kind,
+			Kind:           kind,
+			RequesterID:    user.ID,
+			EnvironmentID:  environment.ID,
+			ResourceTypeID: &resourceType.ID,
+			TeamID:         &team.ID,
+			Configuration:  models.JSON{},
+			EstimatedCost:  cost,
+			Status:         status,
+			CreatedAt:      at,
+		}
+		mustCreate(t, db, &r)
+		return r
+	}
+
+	create := request(models.RequestKindCreate, models.StatusApplied, 100, created)
+	resource := models.Resource{
+		Name:           "dev-cache",
+		RequestID:      create.ID,
+		EnvironmentID:  environment.ID,
+		ResourceTypeID: resourceType.ID,
+		OwnerID:        user.ID,
+		TeamID:         &team.ID,
+		Configuration:  models.JSON{},
+		StatePrefix:    "dev/cache",
+	}
+	mustCreate(t, db, &resource)
+	db.Model(&create).Update("resource_id", resource.ID)
+
+	modify := request(models.RequestKindModify, models.StatusApplied, 150, created.Add(time.Minute))
+	db.Model(&modify).Update("resource_id", resource.ID)
+	other := request(models.RequestKindCreate, models.StatusPending, 20, created.Add(2*time.Minute))
+
+	committed, err := repository.CommittedSpend(db, team.ID, environment.ID, other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed != 150 {
+		t.Errorf("expected the resource to count once at its latest cost of 150, got %v", committed)
+	}
+
+	// Resizing again replaces the resource's cost instead of adding to it
+	resize := request(models.RequestKindModify, models.StatusDraft, 200, created.Add(3*time.Minute))
+	db.Model(&resize).Update("resource_id", resource.ID)
+	resize.ResourceID = &resource.ID
+
+	status, err := repository.CheckBudget(db, &resize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Committed != 20 || status.Blocked {
+		t.Errorf("expected only the other request to be committed and the resize to fit, got %+v", status)
+	}
+}
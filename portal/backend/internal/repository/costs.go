@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/cost"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SavePlanCost stores an Infracost breakdown of the plan the portal ran and
+// its monthly delta on a request
+func SavePlanCost(db *gorm.DB, requestID uuid.UUID, planCost *cost.PlanCost) error {
+	breakdown, err := planCostJSON(planCost)
+	if err != nil {
+		return err
+	}
+	return db.Model(&models.Request{}).Where("id = ?", requestID).Updates(map[string]interface{}{
+		"plan_cost":  breakdown,
+		"cost_delta": planCost.Delta,
+	}).Error
+}
+
+// SaveImportedCost stores an uploaded Infracost breakdown on a request.
+// Uploads cannot be verified against the plan, so they are shown to
+// approvers but never replace cost_delta, which budgets are checked with.
+func SaveImportedCost(db *gorm.DB, requestID uuid.UUID, planCost *cost.PlanCost) error {
+	breakdown, err := planCostJSON(planCost)
+	if err != nil {
+		return err
+	}
+	return db.Model(&models.Request{}).Where("id = ?", requestID).Update("imported_cost", breakdown).Error
+}
+
+func planCostJSON(planCost *cost.PlanCost) (models.JSON, error) {
+	data, err := json.Marshal(planCost)
+	if err != nil {
+		return nil, err
+	}
+	var breakdown models.JSON
+	if err := json.Unmarshal(data, &breakdown); err != nil {
+		return nil, err
+	}
+	return breakdown, nil
+}
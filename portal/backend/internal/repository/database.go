@@ -4,6 +4,8 @@ import (
 	"log"
 
 	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
+	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -43,11 +45,23 @@ func Migrate(db *gorm.DB) error {
 
 	err := db.AutoMigrate(
 		&models.User{},
+		&models.Session{},
 		&models.Environment{},
+		&models.ApprovalStage{},
 		&models.ResourceType{},
+		&models.ResourceTypeVersion{},
+		&models.Blueprint{},
+		&models.BlueprintComponent{},
 		&models.Request{},
 		&models.Approval{},
+		&models.Run{},
+		&models.RunLogChunk{},
+		&models.RunArtifact{},
 		&models.AuditLog{},
+		&models.Team{},
+		&models.TeamMembership{},
+		&models.Budget{},
+		&models.Resource{},
 	)
 	if err != nil {
 		return err
@@ -60,34 +74,21 @@ func Migrate(db *gorm.DB) error {
 // Seed seeds initial data
 func Seed(db *gorm.DB) error {
 	d := &Database{db}
-	d.seedEnvironments()
-	d.seedResourceTypes()
-	return nil
-}
-
-// Migrate runs database migrations
-func (d *Database) Migrate() error {
-	log.Println("Running database migrations...")
-
-	err := d.AutoMigrate(
-		&models.User{},
-		&models.Environment{},
-		&models.ResourceType{},
-		&models.Request{},
-		&models.Approval{},
-		&models.AuditLog{},
-	)
-	if err != nil {
-		return err
-	}
 
 	// Seed default environments if not exist
 	d.seedEnvironments()
 
-	// Seed default resource types if not exist
+	// Seed default approval stages if not exist
+	d.seedApprovalStages()
+
+	// Seed the dev -> staging -> prod promotion chain if none is set up
+	d.seedPromotionChain()
+
+	// Seed default resource types and sync their schemas
 	d.seedResourceTypes()
 
-	log.Println("Migrations completed successfully")
+	// Seed the default blueprints if not exist
+	d.seedBlueprints()
 	return nil
 }
 
@@ -108,11 +109,12 @@ func (d *Database) seedEnvironments() {
 			IsActive:         true,
 		},
 		{
-			Name:             "prod",
-			DisplayName:      "Production",
-			Description:      "Production environment",
-			RequiresApproval: true,
-			IsActive:         true,
+			Name:                "prod",
+			DisplayName:         "Production",
+			Description:         "Production environment",
+			RequiresApproval:    true,
+			BlockEditorApproval: true,
+			IsActive:            true,
 		},
 	}
 
@@ -121,8 +123,109 @@ func (d *Database) seedEnvironments() {
 	}
 }
 
+func (d *Database) seedApprovalStages() {
+	// Production needs a peer review followed by a platform admin sign-off.
+	// Staging uses the default single-approver policy.
+	var prod models.Environment
+	if err := d.First(&prod, "name = ?", "prod").Error; err != nil {
+		return
+	}
+
+	var count int64
+	d.Model(&models.ApprovalStage{}).Where("environment_id = ?", prod.ID).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	stages := []models.ApprovalStage{
+		{
+			EnvironmentID:     prod.ID,
+			Position:          1,
+			Name:              "Peer Review",
+			RequiredApprovals: 1,
+			RequiredRoles:     models.StringList{"approver"},
+		},
+		{
+			EnvironmentID:     prod.ID,
+			Position:          2,
+			Name:              "Platform Sign-off",
+			RequiredApprovals: 1,
+			RequiredRoles:     models.StringList{"admin"},
+		},
+	}
+	d.Create(&stages)
+}
+
+func (d *Database) seedPromotionChain() {
+	var count int64
+	d.Model(&models.Environment{}).Where("next_environment_id IS NOT NULL").Count(&count)
+	if count > 0 {
+		return
+	}
+
+	var dev, staging, prod models.Environment
+	if d.First(&dev, "name = ?", "dev").Error != nil ||
+		d.First(&staging, "name = ?", "staging").Error != nil ||
+		d.First(&prod, "name = ?", "prod").Error != nil {
+		return
+	}
+
+	d.Model(&dev).Update("next_environment_id", staging.ID)
+	d.Model(&staging).Update("next_environment_id", prod.ID)
+
+	// Databases promoted to production always get a standby
+	if prod.PromotionOverrides == nil {
+		d.Model(&prod).Update("promotion_overrides", models.JSON{
+			"cloudsql": map[string]interface{}{"high_availability": true},
+		})
+	}
+}
+
 func (d *Database) seedResourceTypes() {
 	resourceTypes := []models.ResourceType{
+		{
+			Name:        "network",
+			DisplayName: "VPC Network",
+			Description: "VPC with public and private subnets, Cloud NAT and private service access",
+			ModulePath:  "terraform/modules/networking",
+			ConfigSchema: models.JSON{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"public_subnet_cidr": map[string]interface{}{
+						"type":    "string",
+						"title":   "Public Subnet CIDR",
+						"default": "10.0.1.0/24",
+					},
+					"private_subnet_cidr": map[string]interface{}{
+						"type":    "string",
+						"title":   "Private Subnet CIDR",
+						"default": "10.0.2.0/24",
+					},
+					"pods_cidr": map[string]interface{}{
+						"type":    "string",
+						"title":   "GKE Pods CIDR",
+						"default": "10.1.0.0/16",
+					},
+					"services_cidr": map[string]interface{}{
+						"type":    "string",
+						"title":   "GKE Services CIDR",
+						"default": "10.2.0.0/16",
+					},
+				},
+				"required": []string{"public_subnet_cidr", "private_subnet_cidr", "pods_cidr", "services_cidr"},
+			},
+			InputMapping: models.JSON{
+				"project_id":          mapEnv(tfvars.EnvProjectID),
+				"region":              mapEnv(tfvars.EnvRegion),
+				"environment":         mapEnv(tfvars.EnvEnvironment),
+				"project_name":        mapEnv(tfvars.EnvResourceName),
+				"public_subnet_cidr":  mapFrom("public_subnet_cidr"),
+				"private_subnet_cidr": mapFrom("private_subnet_cidr"),
+				"pods_cidr":           mapFrom("pods_cidr"),
+				"services_cidr":       mapFrom("services_cidr"),
+			},
+			IsActive: true,
+		},
 		{
 			Name:        "gke",
 			DisplayName: "GKE Cluster",
@@ -159,6 +262,16 @@ func (d *Database) seedResourceTypes() {
 				},
 				"required": []string{"machine_type", "min_nodes", "max_nodes"},
 			},
+			InputMapping: models.JSON{
+				"project_id":                  mapEnv(tfvars.EnvProjectID),
+				"region":                      mapEnv(tfvars.EnvRegion),
+				"environment":                 mapEnv(tfvars.EnvEnvironment),
+				"project_name":                mapEnv(tfvars.EnvResourceName),
+				"primary_pool_machine_type":   mapFrom("machine_type"),
+				"primary_pool_min_node_count": mapFrom("min_nodes"),
+				"primary_pool_max_node_count": mapFrom("max_nodes"),
+				"create_spot_pool":            mapFrom("create_spot_pool"),
+			},
 			IsActive: true,
 		},
 		{
@@ -190,6 +303,19 @@ func (d *Database) seedResourceTypes() {
 				},
 				"required": []string{"tier", "disk_size_gb"},
 			},
+			InputMapping: models.JSON{
+				"project_id":   mapEnv(tfvars.EnvProjectID),
+				"region":       mapEnv(tfvars.EnvRegion),
+				"environment":  mapEnv(tfvars.EnvEnvironment),
+				"project_name": mapEnv(tfvars.EnvResourceName),
+				"tier":         mapFrom("tier"),
+				"disk_size":    mapFrom("disk_size_gb"),
+				"availability_type": map[string]interface{}{
+					"from":      "high_availability",
+					"transform": tfvars.TransformMap,
+					"values":    map[string]interface{}{"true": "REGIONAL", "false": "ZONAL"},
+				},
+			},
 			IsActive: true,
 		},
 		{
@@ -216,11 +342,135 @@ func (d *Database) seedResourceTypes() {
 				},
 				"required": []string{"memory_size_gb", "tier"},
 			},
+			InputMapping: models.JSON{
+				"project_id":     mapEnv(tfvars.EnvProjectID),
+				"region":         mapEnv(tfvars.EnvRegion),
+				"environment":    mapEnv(tfvars.EnvEnvironment),
+				"project_name":   mapEnv(tfvars.EnvResourceName),
+				"memory_size_gb": mapFrom("memory_size_gb"),
+				"tier":           mapFrom("tier"),
+			},
 			IsActive: true,
 		},
 	}
 
 	for _, rt := range resourceTypes {
-		d.FirstOrCreate(&rt, models.ResourceType{Name: rt.Name})
+		configSchema, inputMapping := rt.ConfigSchema, rt.InputMapping
+		if err := d.FirstOrCreate(&rt, models.ResourceType{Name: rt.Name}).Error; err != nil {
+			log.Printf("Warning: Failed to seed resource type %s: %v", rt.Name, err)
+			continue
+		}
+		if err := d.syncSeedSchema(&rt, configSchema, inputMapping); err != nil {
+			log.Printf("Warning: Failed to sync schema for %s: %v", rt.Name, err)
+		}
+	}
+}
+
+// seedBlueprints creates the service stack blueprint: a network with a GKE
+// cluster, database and cache attached to it
+func (d *Database) seedBlueprints() {
+	var count int64
+	d.Model(&models.Blueprint{}).Where("name = ?", "service-stack").Count(&count)
+	if count > 0 {
+		return
 	}
+
+	types := map[string]uuid.UUID{}
+	for _, name := range []string{"network", "gke", "cloudsql", "redis"} {
+		var rt models.ResourceType
+		if err := d.Select("id").First(&rt, "name = ?", name).Error; err != nil {
+			log.Printf("Warning: Skipping service-stack blueprint, resource type %s not found", name)
+			return
+		}
+		types[name] = rt.ID
+	}
+
+	bp := models.Blueprint{
+		Name:        "service-stack",
+		DisplayName: "Service Stack",
+		Description: "VPC network with a GKE cluster, Cloud SQL database and Redis cache on it",
+		IsActive:    true,
+		Components: []models.BlueprintComponent{
+			{Name: "network", Position: 1, ResourceTypeID: types["network"]},
+			{
+				Name:           "gke",
+				Position:       2,
+				ResourceTypeID: types["gke"],
+				Wiring: models.JSON{
+					"vpc_id":                 "network.vpc_id",
+					"subnet_id":              "network.private_subnet_id",
+					"pods_range_name":        "network.pods_range_name",
+					"services_range_name":    "network.services_range_name",
+					"private_vpc_connection": "network.private_vpc_connection",
+				},
+			},
+			{
+				Name:           "cloudsql",
+				Position:       3,
+				ResourceTypeID: types["cloudsql"],
+				Wiring: models.JSON{
+					"vpc_id":                 "network.vpc_id",
+					"private_vpc_connection": "network.private_vpc_connection",
+				},
+			},
+			{
+				Name:           "redis",
+				Position:       4,
+				ResourceTypeID: types["redis"],
+				Wiring: models.JSON{
+					"vpc_id":                 "network.vpc_id",
+					"private_vpc_connection": "network.private_vpc_connection",
+				},
+			},
+		},
+	}
+	if err := d.Create(&bp).Error; err != nil {
+		log.Printf("Warning: Failed to seed service-stack blueprint: %v", err)
+	}
+}
+
+// syncSeedSchema publishes the schema and input mapping defined in code as a
+// new version of a seeded resource type. Types whose schema was since changed through the
+// admin API are left alone.
+func (d *Database) syncSeedSchema(rt *models.ResourceType, configSchema, inputMapping models.JSON) error {
+	return d.Transaction(func(tx *gorm.DB) error {
+		// Resource types from before versioning: snapshot the stored schema
+		// as version 1, which is what existing requests were validated against
+		if rt.CurrentVersion == 0 {
+			if _, _, err := PublishSchema(tx, rt, rt.ConfigSchema, rt.InputMapping, models.SchemaSourceSeed, nil); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Request{}).
+				Where("resource_type_id = ? AND schema_version = 0", rt.ID).
+				Update("schema_version", rt.CurrentVersion).Error; err != nil {
+				return err
+			}
+		}
+
+		current, err := SchemaVersion(tx, rt.ID, rt.CurrentVersion)
+		if err != nil {
+			return err
+		}
+		if current.Source != models.SchemaSourceSeed {
+			return nil
+		}
+
+		version, created, err := PublishSchema(tx, rt, configSchema, inputMapping, models.SchemaSourceSeed, nil)
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Printf("Published %s schema version %d", rt.Name, version.Version)
+		}
+		return nil
+	})
+}
+
+// mapFrom and mapEnv build seed input mapping entries
+func mapFrom(field string) map[string]interface{} {
+	return map[string]interface{}{"from": field}
+}
+
+func mapEnv(field string) map[string]interface{} {
+	return map[string]interface{}{"env": field}
 }
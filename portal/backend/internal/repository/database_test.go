@@ -0,0 +1,71 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/testdb"
+)
+
+func TestSeedPromotionChain(t *testing.T) {
+	db := testdb.New(t)
+	if err := repository.Seed(db); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	environments := map[string]models.Environment{}
+	var all []models.Environment
+	if err := db.Find(&all).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range all {
+		environments[env.Name] = env
+	}
+
+	dev, staging, prod := environments["dev"], environments["staging"], environments["prod"]
+	if dev.NextEnvironmentID == nil || *dev.NextEnvironmentID != staging.ID {
+		t.Errorf("expected dev to promote to staging, got %v", dev.NextEnvironmentID)
+	}
+	if staging.NextEnvironmentID == nil || *staging.NextEnvironmentID != prod.ID {
+		t.Errorf("expected staging to promote to prod, got %v", staging.NextEnvironmentID)
+	}
+	if prod.NextEnvironmentID != nil {
+		t.Errorf("expected prod to be the end of the chain, got %v", prod.NextEnvironmentID)
+	}
+	cloudsql, _ := prod.PromotionOverrides["cloudsql"].(map[string]interface{})
+	if cloudsql["high_availability"] != true {
+		t.Errorf("expected prod to override cloudsql high_availability, got %v", prod.PromotionOverrides)
+	}
+}
+
+func TestSeedBlueprints(t *testing.T) {
+	db := testdb.New(t)
+	if err := repository.Seed(db); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	var blueprint models.Blueprint
+	if err := db.Preload("Components").First(&blueprint, "name = ?", "service-stack").Error; err != nil {
+		t.Fatalf("expected the service-stack blueprint to be seeded: %v", err)
+	}
+	if len(blueprint.Components) != 4 {
+		t.Errorf("expected service-stack to have 4 components, got %d", len(blueprint.Components))
+	}
+}
+
+func TestSeedIsIdempotent(t *testing.T) {
+	db := testdb.New(t)
+	for i := 0; i < 2; i++ {
+		if err := repository.Seed(db); err != nil {
+			t.Fatalf("Seed failed: %v", err)
+		}
+	}
+
+	var environments, blueprints int64
+	db.Model(&models.Environment{}).Count(&environments)
+	db.Model(&models.Blueprint{}).Count(&blueprints)
+	if environments != 3 || blueprints != 1 {
+		t.Errorf("expected 3 environments and 1 blueprint after seeding twice, got %d and %d", environments, blueprints)
+	}
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/schema"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PublishSchema makes configSchema and inputMapping the current schema of
+// the resource type. If either differs from the current version, a new
+// immutable version is created and true is returned. Run it inside a
+// transaction.
+func PublishSchema(tx *gorm.DB, rt *models.ResourceType, configSchema, inputMapping models.JSON, source string, createdBy *uuid.UUID) (*models.ResourceTypeVersion, bool, error) {
+	checksum, err := versionChecksum(configSchema, inputMapping)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if rt.CurrentVersion > 0 {
+		current, err := SchemaVersion(tx, rt.ID, rt.CurrentVersion)
+		if err != nil {
+			return nil, false, err
+		}
+		if current.Checksum == checksum {
+			return current, false, nil
+		}
+	}
+
+	version := models.ResourceTypeVersion{
+		ResourceTypeID: rt.ID,
+		Version:        rt.CurrentVersion + 1,
+		ConfigSchema:   configSchema,
+		InputMapping:   inputMapping,
+		Checksum:       checksum,
+		Source:         source,
+		CreatedByID:    createdBy,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, false, err
+	}
+
+	rt.ConfigSchema = configSchema
+	rt.InputMapping = inputMapping
+	rt.CurrentVersion = version.Version
+	if err := tx.Model(rt).Select("config_schema", "input_mapping", "current_version").Updates(rt).Error; err != nil {
+		return nil, false, err
+	}
+
+	return &version, true, nil
+}
+
+// SchemaVersion loads one version of a resource type's schema
+func SchemaVersion(db *gorm.DB, resourceTypeID uuid.UUID, version int) (*models.ResourceTypeVersion, error) {
+	var v models.ResourceTypeVersion
+	if err := db.First(&v, "resource_type_id = ? AND version = ?", resourceTypeID, version).Error; err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// versionChecksum hashes the schema, and the input mapping when there is
+// one, so versions without a mapping keep their original checksum
+func versionChecksum(configSchema, inputMapping models.JSON) (string, error) {
+	if len(inputMapping) == 0 {
+		return schema.Checksum(configSchema)
+	}
+	return schema.Checksum(models.JSON{
+		"config_schema": configSchema,
+		"input_mapping": inputMapping,
+	})
+}
+
+// RequestVariables renders the Terraform variables for a request using the
+// input mapping of the schema version it was validated against. The
+// request's Environment must be loaded.
+func RequestVariables(db *gorm.DB, request *models.Request) (map[string]interface{}, error) {
+	mapping, err := requestInputMapping(db, request)
+	if err != nil {
+		return nil, err
+	}
+	if request.Environment == nil {
+		return nil, fmt.Errorf("request %s has no environment loaded", request.ID)
+	}
+	ctx := tfvars.NewContext(*request.Environment, request.ID)
+	wired := request.WiredVariables
+	if request.ResourceID != nil {
+		// Changes must keep the name and wiring of the resource they target
+		var resource models.Resource
+		if err := db.Select("name", "wired_variables").First(&resource, "id = ?", *request.ResourceID).Error; err != nil {
+			return nil, err
+		}
+		ctx.ResourceName = resource.Name
+		if len(wired) == 0 {
+			wired = resource.WiredVariables
+		}
+	}
+	if request.TeamID != nil {
+		var team models.Team
+		if err := db.Select("name", "cost_center").First(&team, "id = ?", *request.TeamID).Error; err != nil {
+			return nil, err
+		}
+		ctx.Team, ctx.CostCenter = team.Name, team.CostCenter
+	}
+	variables, err := tfvars.Render(mapping, request.Configuration, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range wired {
+		variables[name] = value
+	}
+	return variables, nil
+}
+
+func requestInputMapping(db *gorm.DB, request *models.Request) (models.JSON, error) {
+	if request.ResourceTypeID == nil {
+		return nil, fmt.Errorf("request %s has no resource type", request.ID)
+	}
+	if request.SchemaVersion > 0 {
+		version, err := SchemaVersion(db, *request.ResourceTypeID, request.SchemaVersion)
+		if err != nil {
+			return nil, err
+		}
+		return version.InputMapping, nil
+	}
+
+	var rt models.ResourceType
+	if err := db.Select("input_mapping").First(&rt, "id = ?", *request.ResourceTypeID).Error; err != nil {
+		return nil, err
+	}
+	return rt.InputMapping, nil
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SaveResource records the infrastructure an applied request created or
+// changed. A create request adds a resource called name and links itself to
+// it; a modify request updates the resource it targets. Running it again
+// for the same request refreshes the record.
+func SaveResource(db *gorm.DB, request *models.Request, name, statePrefix string, outputs models.JSON) (*models.Resource, error) {
+	var resource models.Resource
+	if request.Kind == models.RequestKindModify {
+		if request.ResourceID == nil {
+			return nil, errors.New("modify request has no resource")
+		}
+		if err := db.First(&resource, "id = ?", *request.ResourceID).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		if request.ResourceTypeID == nil {
+			return nil, errors.New("request has no resource type")
+		}
+		err := db.First(&resource, "request_id = ?", request.ID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		resource.Name = name
+		resource.RequestID = request.ID
+		resource.EnvironmentID = request.EnvironmentID
+		resource.ResourceTypeID = *request.ResourceTypeID
+		resource.OwnerID = request.RequesterID
+		resource.TeamID = request.TeamID
+		resource.StatePrefix = statePrefix
+		resource.WiredVariables = request.WiredVariables
+		if request.TTLHours > 0 && resource.ExpiresAt == nil {
+			expiresAt := time.Now().Add(time.Duration(request.TTLHours) * time.Hour)
+			resource.ExpiresAt = &expiresAt
+		}
+	}
+
+	resource.LastRequestID = &request.ID
+	resource.Configuration = request.Configuration
+	resource.SchemaVersion = request.SchemaVersion
+	resource.Outputs = outputs
+	resource.Status = models.ResourceActive
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&resource).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Request{}).Where("id = ?", request.ID).
+			Update("resource_id", resource.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resource, nil
+}
+
+// DestroyResource marks the resource a decommission request removed as
+// destroyed
+func DestroyResource(db *gorm.DB, request *models.Request) error {
+	if request.ResourceID == nil {
+		return errors.New("decommission request has no resource")
+	}
+	now := time.Now()
+	return db.Model(&models.Resource{}).Where("id = ?", *request.ResourceID).Updates(map[string]interface{}{
+		"status":          models.ResourceDestroyed,
+		"destroyed_at":    now,
+		"last_request_id": request.ID,
+	}).Error
+}
+
+// OpenResourceRequest returns a request other than excludeRequestID that
+// is waiting for approval or being provisioned against a resource, or nil
+func OpenResourceRequest(db *gorm.DB, resourceID, excludeRequestID uuid.UUID) (*models.Request, error) {
+	var request models.Request
+	err := db.Where("resource_id = ? AND id <> ? AND status IN ?", resourceID, excludeRequestID, models.InFlightStatuses).
+		First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/middleware"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SessionStore checks access tokens against the sessions and users tables
+type SessionStore struct {
+	db *gorm.DB
+}
+
+// NewSessionStore creates a session store backed by the database
+func NewSessionStore(db *gorm.DB) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+// Lookup returns the session's revocation state together with the current
+// role and status of its user, or nil if the session or user is unknown
+func (s *SessionStore) Lookup(sessionID uuid.UUID) (*middleware.SessionState, error) {
+	if sessionID == uuid.Nil {
+		return nil, nil
+	}
+
+	var rows []struct {
+		ExpiresAt time.Time
+		RevokedAt *time.Time
+		Role      string
+		IsActive  bool
+	}
+	err := s.db.Table("sessions").
+		Select("sessions.expires_at, sessions.revoked_at, users.role, users.is_active").
+		Joins("JOIN users ON users.id = sessions.user_id AND users.deleted_at IS NULL").
+		Where("sessions.id = ?", sessionID).
+		Limit(1).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	row := rows[0]
+	return &middleware.SessionState{
+		Revoked: row.RevokedAt != nil || time.Now().After(row.ExpiresAt),
+		Role:    row.Role,
+		Active:  row.IsActive,
+	}, nil
+}
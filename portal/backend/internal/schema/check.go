@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+var knownTypes = map[string]bool{
+	"string": true, "integer": true, "number": true, "boolean": true,
+	"object": true, "array": true, "null": true,
+}
+
+// Check reports whether schema can be used as a resource type's
+// configuration schema: an object schema whose properties use the
+// supported keywords correctly
+func Check(schema models.JSON) error {
+	var s map[string]interface{}
+	if err := normalize(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	if s["type"] != "object" {
+		return fmt.Errorf("schema must have type object")
+	}
+	return checkSchema("", s)
+}
+
+func checkSchema(field string, s map[string]interface{}) error {
+	if t, ok := s["type"]; ok {
+		name, _ := t.(string)
+		if !knownTypes[name] {
+			return fmt.Errorf("%s: unsupported type %v", describe(field), t)
+		}
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", describe(field), err)
+		}
+	}
+	if enum, ok := s["enum"]; ok {
+		if _, ok := enum.([]interface{}); !ok {
+			return fmt.Errorf("%s: enum must be a list", describe(field))
+		}
+	}
+
+	properties := map[string]interface{}{}
+	if p, ok := s["properties"]; ok {
+		if properties, ok = p.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s: properties must be an object", describe(field))
+		}
+	}
+	for name, p := range properties {
+		prop, ok := p.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be a schema object", describe(join(field, name)))
+		}
+		if err := checkSchema(join(field, name), prop); err != nil {
+			return err
+		}
+	}
+
+	if r, ok := s["required"]; ok {
+		required, ok := r.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: required must be a list", describe(field))
+		}
+		for _, name := range required {
+			key, _ := name.(string)
+			if _, ok := properties[key]; !ok {
+				return fmt.Errorf("%s: required property %v is not defined", describe(field), name)
+			}
+		}
+	}
+
+	if additional, ok := s["additionalProperties"]; ok {
+		switch a := additional.(type) {
+		case bool:
+		case map[string]interface{}:
+			if err := checkSchema(field+"{}", a); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: additionalProperties must be a boolean or a schema object", describe(field))
+		}
+	}
+
+	if items, ok := s["items"]; ok {
+		itemSchema, ok := items.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: items must be a schema object", describe(field))
+		}
+		if err := checkSchema(field+"[]", itemSchema); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func describe(field string) string {
+	if field == "" {
+		return "schema"
+	}
+	return field
+}
+
+// Checksum returns a stable hash of schema. Map keys are sorted when
+// encoding, so equal schemas hash the same regardless of how they were built.
+func Checksum(schema models.JSON) (string, error) {
+	var s interface{}
+	if err := normalize(schema, &s); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+// Kinds of configuration change
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Change is a single field that differs between two configurations
+type Change struct {
+	Field string      `json:"field"`
+	Kind  string      `json:"kind"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// Diff lists the fields that differ from before to after, sorted by field.
+// Nested objects are compared field by field; lists are compared whole.
+func Diff(before, after models.JSON) ([]Change, error) {
+	var b, a map[string]interface{}
+	if err := normalize(before, &b); err != nil {
+		return nil, err
+	}
+	if err := normalize(after, &a); err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	diffObjects("", b, a, &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+func diffObjects(field string, before, after map[string]interface{}, changes *[]Change) {
+	for name, old := range before {
+		if _, ok := after[name]; !ok {
+			*changes = append(*changes, Change{Field: join(field, name), Kind: ChangeRemoved, Old: old})
+		}
+	}
+	for name, value := range after {
+		old, ok := before[name]
+		if !ok {
+			*changes = append(*changes, Change{Field: join(field, name), Kind: ChangeAdded, New: value})
+			continue
+		}
+
+		oldObj, oldIsObj := old.(map[string]interface{})
+		newObj, newIsObj := value.(map[string]interface{})
+		if oldIsObj && newIsObj {
+			diffObjects(join(field, name), oldObj, newObj, changes)
+			continue
+		}
+		if !reflect.DeepEqual(old, value) {
+			*changes = append(*changes, Change{Field: join(field, name), Kind: ChangeChanged, Old: old, New: value})
+		}
+	}
+}
+
+// Merge overlays changes on a copy of base. Only top-level fields are
+// replaced; a nested object in changes replaces the whole object.
+func Merge(base, changes models.JSON) models.JSON {
+	merged := models.JSON{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range changes {
+		merged[k] = v
+	}
+	return merged
+}
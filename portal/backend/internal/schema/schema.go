@@ -0,0 +1,253 @@
+// Package schema validates request configuration against the JSON Schema
+// stored on a resource type. Only the subset of JSON Schema used by the
+// portal is supported: type, enum, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minLength, maxLength, pattern, required, properties,
+// additionalProperties, items and default.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+// FieldError describes a single configuration value that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Validate checks config against schema. It returns a copy of config with
+// schema defaults filled in for missing fields, and the list of field
+// errors. The error result is only set when the schema itself is unusable.
+func Validate(schema, config models.JSON) (models.JSON, []FieldError, error) {
+	var s map[string]interface{}
+	if err := normalize(schema, &s); err != nil {
+		return nil, nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	var value interface{}
+	if config == nil {
+		config = models.JSON{}
+	}
+	if err := normalize(config, &value); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	v := &validator{}
+	value = v.validate("", s, value)
+
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		return v.errors[i].Field < v.errors[j].Field
+	})
+
+	result, _ := value.(map[string]interface{})
+	return models.JSON(result), v.errors, nil
+}
+
+// normalize round-trips a value through JSON so in-memory schemas (which may
+// use []string or int) look the same as schemas loaded from the database
+func normalize(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validate checks value against s and returns value with defaults applied
+func (v *validator) validate(field string, s map[string]interface{}, value interface{}) interface{} {
+	if t, ok := s["type"].(string); ok && !matchesType(t, value) {
+		v.fail(field, "must be of type %s", t)
+		return value
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok && !inEnum(enum, value) {
+		v.fail(field, "must be one of %s", formatEnum(enum))
+	}
+
+	switch val := value.(type) {
+	case float64:
+		v.checkNumber(field, s, val)
+	case string:
+		v.checkString(field, s, val)
+	case map[string]interface{}:
+		return v.checkObject(field, s, val)
+	case []interface{}:
+		return v.checkArray(field, s, val)
+	}
+
+	return value
+}
+
+func (v *validator) checkNumber(field string, s map[string]interface{}, n float64) {
+	if min, ok := s["minimum"].(float64); ok && n < min {
+		v.fail(field, "must be greater than or equal to %s", formatNumber(min))
+	}
+	if max, ok := s["maximum"].(float64); ok && n > max {
+		v.fail(field, "must be less than or equal to %s", formatNumber(max))
+	}
+	if min, ok := s["exclusiveMinimum"].(float64); ok && n <= min {
+		v.fail(field, "must be greater than %s", formatNumber(min))
+	}
+	if max, ok := s["exclusiveMaximum"].(float64); ok && n >= max {
+		v.fail(field, "must be less than %s", formatNumber(max))
+	}
+}
+
+func (v *validator) checkString(field string, s map[string]interface{}, str string) {
+	length := float64(len([]rune(str)))
+	if min, ok := s["minLength"].(float64); ok && length < min {
+		v.fail(field, "must be at least %s characters", formatNumber(min))
+	}
+	if max, ok := s["maxLength"].(float64); ok && length > max {
+		v.fail(field, "must be at most %s characters", formatNumber(max))
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.fail(field, "schema pattern %q is invalid", pattern)
+		} else if !re.MatchString(str) {
+			v.fail(field, "must match pattern %s", pattern)
+		}
+	}
+}
+
+func (v *validator) checkObject(field string, s map[string]interface{}, obj map[string]interface{}) interface{} {
+	properties, _ := s["properties"].(map[string]interface{})
+
+	result := make(map[string]interface{}, len(obj))
+	for key, val := range obj {
+		result[key] = val
+	}
+
+	// Fill in defaults for missing properties
+	for name, raw := range properties {
+		prop, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, present := result[name]; !present {
+			if def, hasDefault := prop["default"]; hasDefault {
+				result[name] = def
+			}
+		}
+	}
+
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, present := result[name]; !present {
+				v.fail(join(field, name), "is required")
+			}
+		}
+	}
+
+	// additionalProperties is either a boolean or a schema for every
+	// property not listed in properties (e.g. a map of strings)
+	additional, _ := s["additionalProperties"].(bool)
+	additionalSchema, hasSchema := s["additionalProperties"].(map[string]interface{})
+	_, restricts := s["additionalProperties"]
+
+	for name, val := range result {
+		prop, known := properties[name].(map[string]interface{})
+		if !known {
+			if hasSchema {
+				result[name] = v.validate(join(field, name), additionalSchema, val)
+			} else if restricts && !additional {
+				v.fail(join(field, name), "is not a recognised field")
+			}
+			continue
+		}
+		result[name] = v.validate(join(field, name), prop, val)
+	}
+
+	return result
+}
+
+func (v *validator) checkArray(field string, s map[string]interface{}, arr []interface{}) interface{} {
+	items, ok := s["items"].(map[string]interface{})
+	if !ok {
+		return arr
+	}
+	result := make([]interface{}, len(arr))
+	for i, val := range arr {
+		result[i] = v.validate(fmt.Sprintf("%s[%d]", field, i), items, val)
+	}
+	return result
+}
+
+func matchesType(t string, value interface{}) bool {
+	switch t {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		values[i] = fmt.Sprintf("%v", e)
+	}
+	return strings.Join(values, ", ")
+}
+
+func formatNumber(n float64) string {
+	return fmt.Sprintf("%g", n)
+}
+
+func join(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,284 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+// gkeSchema mirrors the seeded GKE schema, including Go-typed slices and ints
+var gkeSchema = models.JSON{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"machine_type": map[string]interface{}{
+			"type":    "string",
+			"enum":    []string{"e2-standard-2", "e2-standard-4"},
+			"default": "e2-standard-2",
+		},
+		"min_nodes": map[string]interface{}{
+			"type":    "integer",
+			"minimum": 1,
+			"maximum": 10,
+			"default": 1,
+		},
+		"max_nodes": map[string]interface{}{
+			"type":    "integer",
+			"minimum": 1,
+			"maximum": 50,
+		},
+		"create_spot_pool": map[string]interface{}{
+			"type":    "boolean",
+			"default": false,
+		},
+	},
+	"required": []string{"machine_type", "min_nodes", "max_nodes"},
+}
+
+func fieldsOf(errs []FieldError) map[string]string {
+	fields := make(map[string]string, len(errs))
+	for _, e := range errs {
+		fields[e.Field] = e.Message
+	}
+	return fields
+}
+
+func TestValidateAcceptsValidConfig(t *testing.T) {
+	config := models.JSON{
+		"machine_type": "e2-standard-4",
+		"min_nodes":    2,
+		"max_nodes":    10,
+	}
+
+	result, errs, err := Validate(gkeSchema, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("expected no field errors, got %v", errs)
+	}
+	if result["machine_type"] != "e2-standard-4" {
+		t.Errorf("provided value should be kept, got %v", result["machine_type"])
+	}
+}
+
+func TestValidateFillsDefaults(t *testing.T) {
+	result, errs, err := Validate(gkeSchema, models.JSON{"max_nodes": 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("expected no field errors, got %v", errs)
+	}
+
+	if result["machine_type"] != "e2-standard-2" {
+		t.Errorf("expected default machine_type, got %v", result["machine_type"])
+	}
+	if result["min_nodes"] != float64(1) {
+		t.Errorf("expected default min_nodes, got %v", result["min_nodes"])
+	}
+	if result["create_spot_pool"] != false {
+		t.Errorf("expected default create_spot_pool, got %v", result["create_spot_pool"])
+	}
+}
+
+func TestValidateReportsFieldErrors(t *testing.T) {
+	config := models.JSON{
+		"machine_type":     "n1-made-up",
+		"min_nodes":        0,
+		"max_nodes":        2.5,
+		"create_spot_pool": "yes",
+	}
+
+	_, errs, err := Validate(gkeSchema, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fields := fieldsOf(errs)
+	for _, field := range []string{"machine_type", "min_nodes", "max_nodes", "create_spot_pool"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("expected error for %s, got %v", field, errs)
+		}
+	}
+}
+
+func TestValidateRequired(t *testing.T) {
+	_, errs, err := Validate(gkeSchema, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fields := fieldsOf(errs)
+	if fields["max_nodes"] != "is required" {
+		t.Errorf("expected max_nodes to be required, got %v", errs)
+	}
+	if _, ok := fields["machine_type"]; ok {
+		t.Error("machine_type has a default and should not be reported missing")
+	}
+}
+
+func TestValidateAdditionalProperties(t *testing.T) {
+	s := models.JSON{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "string", "pattern": "^[a-z]+$"},
+		},
+	}
+
+	_, errs, err := Validate(s, models.JSON{"name": "Bad-Name", "extra": 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fields := fieldsOf(errs)
+	if _, ok := fields["extra"]; !ok {
+		t.Errorf("expected unknown field error, got %v", errs)
+	}
+	if _, ok := fields["name"]; !ok {
+		t.Errorf("expected pattern error, got %v", errs)
+	}
+}
+
+func TestValidateAdditionalPropertiesSchema(t *testing.T) {
+	s := models.JSON{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"labels": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+		},
+	}
+
+	_, errs, err := Validate(s, models.JSON{"labels": map[string]interface{}{"team": "data", "cost": 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(errs) != 1 || errs[0].Field != "labels.cost" {
+		t.Errorf("expected a single error on labels.cost, got %v", errs)
+	}
+}
+
+func TestValidateNestedFields(t *testing.T) {
+	s := models.JSON{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"databases": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string", "minLength": 1},
+			},
+		},
+	}
+
+	_, errs, err := Validate(s, models.JSON{"databases": []interface{}{"app", ""}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(errs) != 1 || errs[0].Field != "databases[1]" {
+		t.Errorf("expected error on databases[1], got %v", errs)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(gkeSchema); err != nil {
+		t.Errorf("seeded schema should pass: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		schema models.JSON
+	}{
+		{"not an object", models.JSON{"type": "string"}},
+		{"unknown type", models.JSON{"type": "object", "properties": map[string]interface{}{
+			"size": map[string]interface{}{"type": "int"},
+		}}},
+		{"bad pattern", models.JSON{"type": "object", "properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "string", "pattern": "(["},
+		}}},
+		{"undefined required", models.JSON{"type": "object", "required": []string{"missing"}}},
+		{"enum not a list", models.JSON{"type": "object", "properties": map[string]interface{}{
+			"tier": map[string]interface{}{"type": "string", "enum": "BASIC"},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(tt.schema); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	a := models.JSON{"type": "object", "required": []string{"size"}, "properties": map[string]interface{}{
+		"size": map[string]interface{}{"type": "integer", "minimum": 1},
+	}}
+	b := models.JSON{"properties": map[string]interface{}{
+		"size": map[string]interface{}{"minimum": 1.0, "type": "integer"},
+	}, "required": []interface{}{"size"}, "type": "object"}
+
+	sumA, err := Checksum(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sumB, _ := Checksum(b)
+	if sumA != sumB {
+		t.Error("equal schemas should have the same checksum")
+	}
+
+	b["required"] = []interface{}{}
+	if sumC, _ := Checksum(b); sumC == sumA {
+		t.Error("different schemas should have different checksums")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := models.JSON{
+		"machine_type": "e2-standard-2",
+		"min_nodes":    1,
+		"labels":       []string{"web"},
+		"backup":       map[string]interface{}{"enabled": true, "retention_days": 7},
+		"spot":         false,
+	}
+	after := models.JSON{
+		"machine_type": "e2-standard-2",
+		"min_nodes":    3.0,
+		"labels":       []interface{}{"web"},
+		"backup":       map[string]interface{}{"enabled": true, "retention_days": 14},
+		"max_nodes":    5,
+	}
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Field: "backup.retention_days", Kind: ChangeChanged, Old: 7.0, New: 14.0},
+		{Field: "max_nodes", Kind: ChangeAdded, New: 5.0},
+		{Field: "min_nodes", Kind: ChangeChanged, Old: 1.0, New: 3.0},
+		{Field: "spot", Kind: ChangeRemoved, Old: false},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+
+	same, _ := Diff(before, before)
+	if len(same) != 0 {
+		t.Errorf("identical configurations should have no changes, got %+v", same)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := models.JSON{"min_nodes": 1, "machine_type": "e2-standard-2"}
+	merged := Merge(base, models.JSON{"min_nodes": 3})
+
+	if merged["min_nodes"] != 3 || merged["machine_type"] != "e2-standard-2" {
+		t.Errorf("unexpected merge result: %v", merged)
+	}
+	if base["min_nodes"] != 1 {
+		t.Error("Merge should not modify the base configuration")
+	}
+}
@@ -0,0 +1,91 @@
+// Package testdb opens throwaway databases for tests. It uses an in-memory
+// SQLite database with the portal's schema, so tests that need the database
+// run without a PostgreSQL server.
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/repository"
+	"github.com/glebarez/go-sqlite"
+	gormsqlite "github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	register sync.Once
+	counter  atomic.Int64
+)
+
+// New returns an empty, migrated database that is closed when the test ends
+func New(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	// PostgreSQL generates the models' IDs; give SQLite the same function
+	register.Do(func() {
+		sqlite.MustRegisterScalarFunction("gen_random_uuid", 0,
+			func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return uuid.NewString(), nil
+			})
+	})
+
+	// Each test gets its own database; the shared cache lets all of its
+	// connections see it
+	dsn := fmt.Sprintf("file:testdb%d?mode=memory&cache=shared&_pragma=foreign_keys(0)", counter.Add(1))
+	db, err := gorm.Open(dialector{gormsqlite.Open(dsn)}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := repository.Migrate(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	return db
+}
+
+// dialector is SQLite with a migrator that accepts the models' PostgreSQL
+// column defaults
+type dialector struct {
+	gorm.Dialector
+}
+
+func (d dialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return migrator{Migrator: d.Dialector.Migrator(db), db: db}
+}
+
+type migrator struct {
+	gorm.Migrator
+	db *gorm.DB
+}
+
+// AutoMigrate rewrites function call defaults such as gen_random_uuid() to
+// the parenthesised form SQLite requires before creating the tables. The
+// migrator reads the same cached schemas.
+func (m migrator) AutoMigrate(values ...interface{}) error {
+	for _, value := range values {
+		stmt := &gorm.Statement{DB: m.db}
+		if err := stmt.Parse(value); err != nil {
+			return err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if strings.HasSuffix(field.DefaultValue, ")") && !strings.HasPrefix(field.DefaultValue, "(") {
+				field.DefaultValue = "(" + field.DefaultValue + ")"
+			}
+		}
+	}
+	return m.Migrator.AutoMigrate(values...)
+}
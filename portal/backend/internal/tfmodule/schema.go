@@ -0,0 +1,317 @@
+package tfmodule
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// PlatformVariables maps variable names the portal fills in from the target
+// environment to the environment field they come from
+var PlatformVariables = map[string]string{
+	"project_id":  tfvars.EnvProjectID,
+	"region":      tfvars.EnvRegion,
+	"environment": tfvars.EnvEnvironment,
+}
+
+// Import is a generated configuration schema and the mapping from module
+// variables to configuration fields or environment values
+type Import struct {
+	ConfigSchema models.JSON `json:"config_schema"`
+	InputMapping models.JSON `json:"input_mapping"`
+	Warnings     []string    `json:"warnings,omitempty"`
+}
+
+// Generate builds a configuration schema from module variables. Each
+// variable becomes a property of the same name, except platform variables,
+// which are mapped from the environment. Validation blocks that use
+// contains(), numeric comparisons, length() or can(regex()) are translated
+// into enum, bounds and pattern; anything else is reported as a warning
+// since Terraform still enforces it at plan time.
+func Generate(variables []Variable) Import {
+	properties := map[string]interface{}{}
+	mapping := models.JSON{}
+	required := []string{}
+	var warnings []string
+
+	for _, v := range variables {
+		if field, ok := PlatformVariables[v.Name]; ok {
+			mapping[v.Name] = map[string]interface{}{"env": field}
+			continue
+		}
+
+		prop := typeSchema(v.Type, v.Default)
+		prop["title"] = title(v.Name)
+		if v.Description != "" {
+			prop["description"] = v.Description
+		}
+		if v.Default != nil {
+			prop["default"] = v.Default
+		}
+		if v.Sensitive {
+			prop["writeOnly"] = true
+		}
+
+		for _, validation := range v.Validations {
+			if !applyValidation(prop, v.Name, validation.Condition) {
+				warnings = append(warnings, fmt.Sprintf("%s: validation %q is only checked by Terraform", v.Name, validation.Source))
+			}
+		}
+
+		properties[v.Name] = prop
+		mapping[v.Name] = map[string]interface{}{"from": v.Name}
+		if v.Required {
+			required = append(required, v.Name)
+		}
+	}
+
+	configSchema := models.JSON{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		configSchema["required"] = required
+	}
+
+	return Import{ConfigSchema: configSchema, InputMapping: mapping, Warnings: warnings}
+}
+
+// typeSchema converts a Terraform type constraint to a JSON Schema. Terraform
+// has a single number type; it becomes an integer when the default is whole.
+func typeSchema(ty cty.Type, def interface{}) map[string]interface{} {
+	switch {
+	case ty == cty.String:
+		return map[string]interface{}{"type": "string"}
+	case ty == cty.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case ty == cty.Number:
+		if n, ok := def.(float64); ok && n == math.Trunc(n) {
+			return map[string]interface{}{"type": "integer"}
+		}
+		return map[string]interface{}{"type": "number"}
+	case ty.IsListType() || ty.IsSetType():
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(ty.ElementType(), nil),
+		}
+	case ty.IsTupleType():
+		return map[string]interface{}{"type": "array"}
+	case ty.IsMapType():
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(ty.ElementType(), nil),
+		}
+	case ty.IsObjectType():
+		properties := map[string]interface{}{}
+		required := []string{}
+		for name, attr := range ty.AttributeTypes() {
+			properties[name] = typeSchema(attr, nil)
+			if !ty.AttributeOptional(name) {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		return s
+	}
+	// any
+	return map[string]interface{}{}
+}
+
+// applyValidation translates a validation condition into schema keywords.
+// It returns false if the condition (or part of it) has no equivalent.
+func applyValidation(prop map[string]interface{}, name string, expr hclsyntax.Expression) bool {
+	switch e := expr.(type) {
+	case *hclsyntax.ParenthesesExpr:
+		return applyValidation(prop, name, e.Expression)
+
+	case *hclsyntax.BinaryOpExpr:
+		if e.Op == hclsyntax.OpLogicalAnd {
+			left := applyValidation(prop, name, e.LHS)
+			right := applyValidation(prop, name, e.RHS)
+			return left && right
+		}
+		return applyComparison(prop, name, e)
+
+	case *hclsyntax.FunctionCallExpr:
+		switch e.Name {
+		case "contains":
+			if len(e.Args) != 2 || !isVar(e.Args[1], name) {
+				return false
+			}
+			values, ok := constant(e.Args[0])
+			if !ok {
+				return false
+			}
+			list, ok := values.([]interface{})
+			if !ok {
+				return false
+			}
+			prop["enum"] = list
+			return true
+		case "can":
+			if len(e.Args) != 1 {
+				return false
+			}
+			call, ok := e.Args[0].(*hclsyntax.FunctionCallExpr)
+			if !ok || call.Name != "regex" || len(call.Args) != 2 || !isVar(call.Args[1], name) {
+				return false
+			}
+			pattern, ok := constant(call.Args[0])
+			if !ok {
+				return false
+			}
+			if s, ok := pattern.(string); ok {
+				prop["pattern"] = s
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyComparison handles var.x OP n and n OP var.x, where var.x may also be
+// wrapped in length()
+func applyComparison(prop map[string]interface{}, name string, e *hclsyntax.BinaryOpExpr) bool {
+	op := e.Op
+	subject, other := e.LHS, e.RHS
+	if _, ok := constant(subject); ok {
+		// Flip n OP var.x into var.x OP' n
+		subject, other = other, subject
+		switch op {
+		case hclsyntax.OpGreaterThan:
+			op = hclsyntax.OpLessThan
+		case hclsyntax.OpGreaterThanOrEqual:
+			op = hclsyntax.OpLessThanOrEqual
+		case hclsyntax.OpLessThan:
+			op = hclsyntax.OpGreaterThan
+		case hclsyntax.OpLessThanOrEqual:
+			op = hclsyntax.OpGreaterThanOrEqual
+		}
+	}
+
+	value, ok := constant(other)
+	if !ok {
+		return false
+	}
+	n, ok := value.(float64)
+	if !ok {
+		return false
+	}
+
+	var keywords map[*hclsyntax.Operation]string
+	switch {
+	case isVar(subject, name):
+		keywords = map[*hclsyntax.Operation]string{
+			hclsyntax.OpGreaterThanOrEqual: "minimum",
+			hclsyntax.OpGreaterThan:        "exclusiveMinimum",
+			hclsyntax.OpLessThanOrEqual:    "maximum",
+			hclsyntax.OpLessThan:           "exclusiveMaximum",
+		}
+	case isLength(subject, name) && prop["type"] == "string":
+		keywords = map[*hclsyntax.Operation]string{
+			hclsyntax.OpGreaterThanOrEqual: "minLength",
+			hclsyntax.OpLessThanOrEqual:    "maxLength",
+		}
+	default:
+		return false
+	}
+
+	keyword, ok := keywords[op]
+	if !ok {
+		return false
+	}
+	prop[keyword] = n
+	return true
+}
+
+// isVar reports whether expr is a reference to var.<name>
+func isVar(expr hclsyntax.Expression, name string) bool {
+	traversal, ok := expr.(*hclsyntax.ScopeTraversalExpr)
+	if !ok || len(traversal.Traversal) != 2 || traversal.Traversal.RootName() != "var" {
+		return false
+	}
+	attr, ok := traversal.Traversal[1].(hcl.TraverseAttr)
+	return ok && attr.Name == name
+}
+
+func isLength(expr hclsyntax.Expression, name string) bool {
+	call, ok := expr.(*hclsyntax.FunctionCallExpr)
+	return ok && call.Name == "length" && len(call.Args) == 1 && isVar(call.Args[0], name)
+}
+
+// constant evaluates an expression that has no references, returning it as
+// plain JSON data
+func constant(expr hclsyntax.Expression) (interface{}, bool) {
+	if len(expr.Variables()) > 0 {
+		return nil, false
+	}
+	if _, ok := expr.(*hclsyntax.FunctionCallExpr); ok {
+		return nil, false
+	}
+	val, diags := expr.Value(&hcl.EvalContext{})
+	if diags.HasErrors() || !val.IsWhollyKnown() || val.IsNull() {
+		return nil, false
+	}
+
+	switch {
+	case val.Type() == cty.String:
+		return val.AsString(), true
+	case val.Type() == cty.Number:
+		f, _ := val.AsBigFloat().Float64()
+		return f, true
+	case val.Type() == cty.Bool:
+		return val.True(), true
+	case val.Type().IsTupleType() || val.Type().IsListType():
+		var out []interface{}
+		for it := val.ElementIterator(); it.Next(); {
+			_, elem := it.Element()
+			switch {
+			case elem.Type() == cty.String:
+				out = append(out, elem.AsString())
+			case elem.Type() == cty.Number:
+				f, _ := elem.AsBigFloat().Float64()
+				out = append(out, f)
+			default:
+				return nil, false
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// acronyms are kept upper case in generated titles
+var acronyms = map[string]bool{
+	"cpu": true, "gb": true, "ha": true, "id": true, "ip": true,
+	"sql": true, "ssl": true, "tls": true, "url": true, "vpc": true,
+}
+
+// title turns a variable name like disk_size_gb into "Disk Size GB"
+func title(name string) string {
+	words := strings.Split(name, "_")
+	for i, w := range words {
+		switch {
+		case acronyms[w]:
+			words[i] = strings.ToUpper(w)
+		case w != "":
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
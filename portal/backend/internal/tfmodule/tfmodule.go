@@ -0,0 +1,198 @@
+// Package tfmodule reads the input variables of a Terraform module and
+// turns them into a resource type configuration schema. Variables supplied
+// by the portal itself (project, region, environment) are mapped from the
+// target environment instead of being asked from the requester.
+package tfmodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Variable is a single module input variable
+type Variable struct {
+	Name        string
+	Description string
+	Type        cty.Type
+	Default     interface{} // JSON-compatible default, nil if there is none
+	Required    bool        // no default, so the caller must set it
+	Sensitive   bool
+	Validations []Validation
+	Source      string // file the variable was declared in
+}
+
+// Validation is a variable validation block
+type Validation struct {
+	Condition    hclsyntax.Expression
+	ErrorMessage string
+	Source       string // the condition as written
+}
+
+// Load parses every *.tf file in dir and returns the declared variables
+// sorted by name
+func Load(dir string) ([]Variable, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Terraform files in %s", dir)
+	}
+
+	var variables []Variable
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		vars, err := Parse(filepath.Base(file), src)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, vars...)
+	}
+
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].Name < variables[j].Name
+	})
+	return variables, nil
+}
+
+// Parse returns the variables declared in a single Terraform file
+func Parse(filename string, src []byte) ([]Variable, error) {
+	file, diags := hclparse.NewParser().ParseHCL(src, filename)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil, fmt.Errorf("%s: unexpected body type", filename)
+	}
+
+	var variables []Variable
+	for _, block := range body.Blocks {
+		if block.Type != "variable" || len(block.Labels) != 1 {
+			continue
+		}
+		v, err := parseVariable(src, filename, block)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, v)
+	}
+	return variables, nil
+}
+
+func parseVariable(src []byte, filename string, block *hclsyntax.Block) (Variable, error) {
+	v := Variable{
+		Name:     block.Labels[0],
+		Type:     cty.DynamicPseudoType,
+		Required: true,
+		Source:   filename,
+	}
+	attrs := block.Body.Attributes
+
+	if attr, ok := attrs["description"]; ok {
+		if err := decodeString(attr, &v.Description); err != nil {
+			return v, err
+		}
+	}
+
+	if attr, ok := attrs["type"]; ok {
+		ty, _, diags := typeexpr.TypeConstraintWithDefaults(attr.Expr)
+		if diags.HasErrors() {
+			return v, fmt.Errorf("variable %q: %s", v.Name, diags.Error())
+		}
+		v.Type = ty
+	}
+
+	if attr, ok := attrs["sensitive"]; ok {
+		val, diags := attr.Expr.Value(nil)
+		if !diags.HasErrors() && val.Type() == cty.Bool && val.IsKnown() && !val.IsNull() {
+			v.Sensitive = val.True()
+		}
+	}
+
+	if attr, ok := attrs["default"]; ok {
+		def, err := decodeDefault(attr, v.Type)
+		if err != nil {
+			return v, fmt.Errorf("variable %q: %w", v.Name, err)
+		}
+		v.Default = def
+		v.Required = false
+	}
+
+	for _, nested := range block.Body.Blocks {
+		if nested.Type != "validation" {
+			continue
+		}
+		condition, ok := nested.Body.Attributes["condition"]
+		if !ok {
+			continue
+		}
+		validation := Validation{
+			Condition: condition.Expr,
+			Source:    string(condition.Expr.Range().SliceBytes(src)),
+		}
+		if msg, ok := nested.Body.Attributes["error_message"]; ok {
+			decodeString(msg, &validation.ErrorMessage)
+		}
+		v.Validations = append(v.Validations, validation)
+	}
+
+	return v, nil
+}
+
+func decodeString(attr *hclsyntax.Attribute, out *string) error {
+	val, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() {
+		return diags
+	}
+	if val.IsNull() || !val.IsKnown() || val.Type() != cty.String {
+		return nil
+	}
+	*out = val.AsString()
+	return nil
+}
+
+// decodeDefault evaluates a default value, converts it to the variable's
+// type and returns it as plain JSON data. A null default means the
+// variable is optional without a value.
+func decodeDefault(attr *hclsyntax.Attribute, ty cty.Type) (interface{}, error) {
+	val, diags := attr.Expr.Value(&hcl.EvalContext{})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	if val.IsNull() {
+		return nil, nil
+	}
+	if ty != cty.DynamicPseudoType {
+		converted, err := convert.Convert(val, ty)
+		if err != nil {
+			return nil, fmt.Errorf("default does not match type: %w", err)
+		}
+		val = converted
+	}
+
+	data, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,186 @@
+package tfmodule
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/schema"
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/tfvars"
+)
+
+const sampleVariables = `
+variable "project_id" {
+  type = string
+}
+
+variable "tier" {
+  description = "Redis tier"
+  type        = string
+  default     = "BASIC"
+
+  validation {
+    condition     = contains(["BASIC", "STANDARD_HA"], var.tier)
+    error_message = "Tier must be BASIC or STANDARD_HA."
+  }
+}
+
+variable "memory_size_gb" {
+  type    = number
+  default = 1
+
+  validation {
+    condition     = var.memory_size_gb >= 1 && var.memory_size_gb <= 300
+    error_message = "Memory size must be between 1 and 300 GB."
+  }
+}
+
+variable "owasp_action" {
+  type    = string
+  default = "deny(403)"
+
+  validation {
+    condition     = can(regex("^(deny\\(\\d+\\)|allow)$", var.owasp_action))
+    error_message = "Invalid action."
+  }
+}
+
+variable "name" {
+  type = string
+
+  validation {
+    condition     = length(var.name) <= 30 && startswith(var.name, "app-")
+    error_message = "Invalid name."
+  }
+}
+
+variable "labels" {
+  type    = map(string)
+  default = {}
+}
+
+variable "flags" {
+  type = list(object({
+    name  = string
+    value = optional(string)
+  }))
+  default = []
+}
+
+variable "password" {
+  type      = string
+  sensitive = true
+  default   = null
+}
+`
+
+func TestParse(t *testing.T) {
+	variables, err := Parse("variables.tf", []byte(sampleVariables))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(variables) != 8 {
+		t.Fatalf("expected 8 variables, got %d", len(variables))
+	}
+
+	byName := map[string]Variable{}
+	for _, v := range variables {
+		byName[v.Name] = v
+	}
+
+	if !byName["project_id"].Required || byName["tier"].Required {
+		t.Error("only variables without a default should be required")
+	}
+	if byName["tier"].Default != "BASIC" || byName["tier"].Description != "Redis tier" {
+		t.Errorf("unexpected tier variable: %+v", byName["tier"])
+	}
+	if byName["password"].Required || byName["password"].Default != nil || !byName["password"].Sensitive {
+		t.Errorf("null default should make an optional sensitive variable: %+v", byName["password"])
+	}
+	if len(byName["memory_size_gb"].Validations) != 1 {
+		t.Error("validation block should be parsed")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	variables, err := Parse("variables.tf", []byte(sampleVariables))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Generate(variables)
+
+	if err := schema.Check(result.ConfigSchema); err != nil {
+		t.Fatalf("generated schema should be usable: %v", err)
+	}
+	if err := tfvars.Check(result.InputMapping, result.ConfigSchema); err != nil {
+		t.Fatalf("generated mapping should be usable: %v", err)
+	}
+
+	properties := result.ConfigSchema["properties"].(map[string]interface{})
+	if _, ok := properties["project_id"]; ok {
+		t.Error("platform variables should not be part of the schema")
+	}
+	if !reflect.DeepEqual(result.InputMapping["project_id"], map[string]interface{}{"env": "project_id"}) {
+		t.Errorf("project_id should come from the environment, got %v", result.InputMapping["project_id"])
+	}
+	if !reflect.DeepEqual(result.InputMapping["tier"], map[string]interface{}{"from": "tier"}) {
+		t.Errorf("tier should come from the configuration, got %v", result.InputMapping["tier"])
+	}
+
+	tier := properties["tier"].(map[string]interface{})
+	if !reflect.DeepEqual(tier["enum"], []interface{}{"BASIC", "STANDARD_HA"}) {
+		t.Errorf("contains() should become an enum, got %v", tier["enum"])
+	}
+
+	memory := properties["memory_size_gb"].(map[string]interface{})
+	if memory["type"] != "integer" || memory["minimum"] != 1.0 || memory["maximum"] != 300.0 {
+		t.Errorf("unexpected memory_size_gb schema: %v", memory)
+	}
+	if memory["title"] != "Memory Size GB" {
+		t.Errorf("unexpected title %v", memory["title"])
+	}
+
+	if properties["owasp_action"].(map[string]interface{})["pattern"] != `^(deny\(\d+\)|allow)$` {
+		t.Errorf("can(regex()) should become a pattern, got %v", properties["owasp_action"])
+	}
+
+	name := properties["name"].(map[string]interface{})
+	if name["maxLength"] != 30.0 {
+		t.Errorf("length() should become maxLength, got %v", name)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("startswith() cannot be translated and should be reported, got %v", result.Warnings)
+	}
+
+	if !reflect.DeepEqual(result.ConfigSchema["required"], []string{"name"}) {
+		t.Errorf("unexpected required list %v", result.ConfigSchema["required"])
+	}
+
+	config, fieldErrors, err := schema.Validate(result.ConfigSchema, map[string]interface{}{
+		"name":   "app-cache",
+		"labels": map[string]interface{}{"team": "data"},
+		"flags":  []interface{}{map[string]interface{}{"name": "log_connections"}},
+	})
+	if err != nil || len(fieldErrors) > 0 {
+		t.Fatalf("expected valid configuration, got %v %v", fieldErrors, err)
+	}
+	if config["tier"] != "BASIC" {
+		t.Errorf("defaults should be filled in, got %v", config)
+	}
+}
+
+func TestLoadModule(t *testing.T) {
+	variables, err := Load(filepath.Join("..", "..", "..", "..", "terraform", "modules", "cloudsql"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	properties := Generate(variables).ConfigSchema["properties"].(map[string]interface{})
+	disk, ok := properties["disk_size"].(map[string]interface{})
+	if !ok {
+		t.Fatal("expected a disk_size property")
+	}
+	if disk["type"] != "integer" || disk["default"] != 20.0 {
+		t.Errorf("unexpected disk_size schema: %v", disk)
+	}
+}
@@ -0,0 +1,340 @@
+// Package tfvars renders the Terraform input variables for a request. A
+// resource type's input mapping says, for each module variable, where its
+// value comes from: a configuration field (optionally transformed), a
+// constant, or the target environment.
+//
+//	{
+//	  "primary_pool_min_node_count": {"from": "min_nodes"},
+//	  "availability_type": {"from": "high_availability", "transform": "map",
+//	                        "values": {"true": "REGIONAL", "false": "ZONAL"}},
+//	  "disk_type": {"value": "PD_SSD"},
+//	  "project_id": {"env": "project_id"}
+//	}
+package tfvars
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Environment fields that can be injected with "env"
+const (
+	EnvProjectID    = "project_id"
+	EnvRegion       = "region"
+	EnvEnvironment  = "environment"
+	EnvResourceName = "resource_name"
+	EnvTeam         = "team"
+	EnvCostCenter   = "cost_center"
+)
+
+// Transforms that can be applied to a configuration value
+const (
+	TransformUpper    = "upper"
+	TransformLower    = "lower"
+	TransformString   = "string"
+	TransformInteger  = "integer"
+	TransformMultiply = "multiply" // needs "factor"
+	TransformMap      = "map"      // needs "values"
+	TransformList     = "list"     // wraps a single value in a list
+)
+
+// Context holds the values injected from the target environment
+type Context struct {
+	ProjectID   string
+	Region      string
+	Environment string
+
+	// ResourceName is a stable name for the request's resources,
+	// "<environment>-<first 8 characters of the request ID>"
+	ResourceName string
+
+	// Team and CostCenter are empty when the request has no team
+	Team       string
+	CostCenter string
+}
+
+// NewContext builds the injection context for a request in env
+func NewContext(env models.Environment, requestID uuid.UUID) Context {
+	return Context{
+		ProjectID:    env.GCPProjectID,
+		Region:       env.Region,
+		Environment:  env.Name,
+		ResourceName: ResourceName(env.Name, requestID),
+	}
+}
+
+// ResourceName is the name given to the infrastructure a request creates
+func ResourceName(environment string, requestID uuid.UUID) string {
+	return fmt.Sprintf("%s-%s", environment, requestID.String()[:8])
+}
+
+func (c Context) lookup(field string) (string, bool) {
+	switch field {
+	case EnvProjectID:
+		return c.ProjectID, true
+	case EnvRegion:
+		return c.Region, true
+	case EnvEnvironment:
+		return c.Environment, true
+	case EnvResourceName:
+		return c.ResourceName, true
+	case EnvTeam:
+		return c.Team, true
+	case EnvCostCenter:
+		return c.CostCenter, true
+	}
+	return "", false
+}
+
+// entry is the mapping for a single variable
+type entry struct {
+	from      string
+	env       string
+	value     interface{}
+	hasValue  bool
+	transform string
+	factor    float64
+	values    map[string]interface{}
+}
+
+func parseEntry(variable string, raw interface{}) (entry, error) {
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return entry{}, fmt.Errorf("mapping for %s must be an object", variable)
+	}
+
+	var e entry
+	e.from, _ = m["from"].(string)
+	e.env, _ = m["env"].(string)
+	e.value, e.hasValue = m["value"]
+	e.transform, _ = m["transform"].(string)
+	e.factor, _ = m["factor"].(float64)
+	e.values, _ = m["values"].(map[string]interface{})
+
+	sources := 0
+	for _, set := range []bool{e.from != "", e.env != "", e.hasValue} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return e, fmt.Errorf("mapping for %s needs exactly one of from, env or value", variable)
+	}
+
+	if e.transform != "" && e.from == "" {
+		return e, fmt.Errorf("mapping for %s: transform needs a from field", variable)
+	}
+	switch e.transform {
+	case "", TransformUpper, TransformLower, TransformString, TransformInteger, TransformList:
+	case TransformMultiply:
+		if e.factor == 0 {
+			return e, fmt.Errorf("mapping for %s: multiply needs a non-zero factor", variable)
+		}
+	case TransformMap:
+		if len(e.values) == 0 {
+			return e, fmt.Errorf("mapping for %s: map needs values", variable)
+		}
+	default:
+		return e, fmt.Errorf("mapping for %s: unknown transform %q", variable, e.transform)
+	}
+
+	if e.env != "" {
+		if _, ok := (Context{}).lookup(e.env); !ok {
+			return e, fmt.Errorf("mapping for %s reads unknown environment field %s", variable, e.env)
+		}
+	}
+	return e, nil
+}
+
+// Check verifies a mapping against the configuration schema it reads from
+func Check(mapping, configSchema models.JSON) error {
+	var s map[string]interface{}
+	if err := normalize(configSchema, &s); err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := normalize(mapping, &m); err != nil {
+		return err
+	}
+
+	for _, variable := range sortedKeys(m) {
+		e, err := parseEntry(variable, m[variable])
+		if err != nil {
+			return err
+		}
+		if e.from != "" && !hasProperty(s, e.from) {
+			return fmt.Errorf("mapping for %s reads unknown property %s", variable, e.from)
+		}
+	}
+	return nil
+}
+
+// Render returns the module variables for a configuration. Without a
+// mapping the configuration is passed through unchanged. Variables whose
+// configuration field is not set are left out so the module default applies.
+func Render(mapping, config models.JSON, ctx Context) (map[string]interface{}, error) {
+	var cfg map[string]interface{}
+	if err := normalize(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	if len(mapping) == 0 {
+		return cfg, nil
+	}
+
+	var m map[string]interface{}
+	if err := normalize(mapping, &m); err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]interface{}, len(m))
+	for _, variable := range sortedKeys(m) {
+		e, err := parseEntry(variable, m[variable])
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case e.hasValue:
+			vars[variable] = e.value
+		case e.env != "":
+			vars[variable], _ = ctx.lookup(e.env)
+		default:
+			value, ok := lookup(cfg, e.from)
+			if !ok || value == nil {
+				continue
+			}
+			value, err := apply(e, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", variable, err)
+			}
+			vars[variable] = value
+		}
+	}
+	return vars, nil
+}
+
+// Encode renders variables as terraform.tfvars.json. Keys are sorted, so
+// the same variables always produce the same bytes.
+func Encode(vars map[string]interface{}) ([]byte, error) {
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	data, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func apply(e entry, value interface{}) (interface{}, error) {
+	switch e.transform {
+	case TransformUpper, TransformLower:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a string, got %T", e.transform, value)
+		}
+		if e.transform == TransformUpper {
+			return strings.ToUpper(s), nil
+		}
+		return strings.ToLower(s), nil
+	case TransformString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return formatKey(value), nil
+	case TransformInteger:
+		n, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("integer needs a number, got %T", value)
+		}
+		return math.Round(n), nil
+	case TransformMultiply:
+		n, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("multiply needs a number, got %T", value)
+		}
+		return n * e.factor, nil
+	case TransformMap:
+		mapped, ok := e.values[formatKey(value)]
+		if !ok {
+			return nil, fmt.Errorf("no mapping for value %v", value)
+		}
+		return mapped, nil
+	case TransformList:
+		if list, ok := value.([]interface{}); ok {
+			return list, nil
+		}
+		return []interface{}{value}, nil
+	}
+	return value, nil
+}
+
+// lookup reads a dotted path such as "backup.enabled" from the configuration
+func lookup(cfg map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = cfg
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// hasProperty reports whether the schema defines the dotted path
+func hasProperty(s map[string]interface{}, path string) bool {
+	current := s
+	for _, part := range strings.Split(path, ".") {
+		properties, _ := current["properties"].(map[string]interface{})
+		next, ok := properties[part].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = next
+	}
+	return true
+}
+
+// formatKey formats a configuration value as a key of a map transform
+func formatKey(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		if v == math.Trunc(v) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// normalize round-trips a value through JSON so values built in Go look
+// the same as values loaded from the database
+func normalize(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,171 @@
+package tfvars
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+var testSchema = models.JSON{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"min_nodes":         map[string]interface{}{"type": "integer"},
+		"high_availability": map[string]interface{}{"type": "boolean"},
+		"tier":              map[string]interface{}{"type": "string"},
+		"zone":              map[string]interface{}{"type": "string"},
+		"memory_gb":         map[string]interface{}{"type": "number"},
+		"backup": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"enabled": map[string]interface{}{"type": "boolean"},
+			},
+		},
+	},
+}
+
+var testMapping = models.JSON{
+	"primary_pool_min_node_count": map[string]interface{}{"from": "min_nodes"},
+	"availability_type": map[string]interface{}{
+		"from":      "high_availability",
+		"transform": "map",
+		"values":    map[string]interface{}{"true": "REGIONAL", "false": "ZONAL"},
+	},
+	"tier":           map[string]interface{}{"from": "tier", "transform": "upper"},
+	"node_locations": map[string]interface{}{"from": "zone", "transform": "list"},
+	"memory_mb":      map[string]interface{}{"from": "memory_gb", "transform": "multiply", "factor": 1024},
+	"backup_enabled": map[string]interface{}{"from": "backup.enabled"},
+	"disk_type":      map[string]interface{}{"value": "PD_SSD"},
+	"project_id":     map[string]interface{}{"env": "project_id"},
+	"region":         map[string]interface{}{"env": "region"},
+	"project_name":   map[string]interface{}{"env": "resource_name"},
+}
+
+func testContext() Context {
+	env := models.Environment{Name: "staging", GCPProjectID: "acme-staging", Region: "asia-southeast2"}
+	return NewContext(env, uuid.MustParse("1b4e28ba-2fa1-11d2-883f-0016d3cca427"))
+}
+
+func TestRender(t *testing.T) {
+	config := models.JSON{
+		"min_nodes":         2,
+		"high_availability": true,
+		"tier":              "standard_ha",
+		"zone":              "asia-southeast2-a",
+		"memory_gb":         1.5,
+		"backup":            map[string]interface{}{"enabled": false},
+	}
+
+	vars, err := Render(testMapping, config, testContext())
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"primary_pool_min_node_count": 2.0,
+		"availability_type":           "REGIONAL",
+		"tier":                        "STANDARD_HA",
+		"node_locations":              []interface{}{"asia-southeast2-a"},
+		"memory_mb":                   1536.0,
+		"backup_enabled":              false,
+		"disk_type":                   "PD_SSD",
+		"project_id":                  "acme-staging",
+		"region":                      "asia-southeast2",
+		"project_name":                "staging-1b4e28ba",
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("unexpected variables:\n got %v\nwant %v", vars, expected)
+	}
+}
+
+func TestRenderOmitsUnsetFields(t *testing.T) {
+	vars, err := Render(testMapping, models.JSON{"min_nodes": 1}, testContext())
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if _, ok := vars["tier"]; ok {
+		t.Error("unset fields should be left to the module default")
+	}
+	if vars["disk_type"] != "PD_SSD" || vars["project_id"] != "acme-staging" {
+		t.Errorf("constants and environment values should always be set, got %v", vars)
+	}
+}
+
+func TestRenderWithoutMapping(t *testing.T) {
+	config := models.JSON{"memory_size_gb": 1, "tier": "BASIC"}
+	vars, err := Render(nil, config, testContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vars, map[string]interface{}{"memory_size_gb": 1.0, "tier": "BASIC"}) {
+		t.Errorf("configuration should pass through unchanged, got %v", vars)
+	}
+}
+
+func TestRenderUnmappedValue(t *testing.T) {
+	mapping := models.JSON{
+		"availability_type": map[string]interface{}{
+			"from": "tier", "transform": "map", "values": map[string]interface{}{"ha": "REGIONAL"},
+		},
+	}
+	if _, err := Render(mapping, models.JSON{"tier": "basic"}, testContext()); err == nil {
+		t.Error("expected an error for a value the map transform does not cover")
+	}
+}
+
+func TestEncodeIsDeterministic(t *testing.T) {
+	first, err := Render(testMapping, models.JSON{"min_nodes": 3, "tier": "basic"}, testContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := Encode(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		vars, _ := Render(testMapping, models.JSON{"tier": "basic", "min_nodes": 3}, testContext())
+		b, err := Encode(vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(a) != string(b) {
+			t.Fatalf("encoding changed between runs:\n%s\n%s", a, b)
+		}
+	}
+
+	empty, _ := Encode(nil)
+	if string(empty) != "{}\n" {
+		t.Errorf("expected an empty object, got %q", empty)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(testMapping, testSchema); err != nil {
+		t.Fatalf("expected valid mapping, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		entry interface{}
+	}{
+		{"not an object", "min_nodes"},
+		{"no source", map[string]interface{}{"transform": "upper"}},
+		{"two sources", map[string]interface{}{"from": "tier", "value": "x"}},
+		{"unknown property", map[string]interface{}{"from": "max_nodes"}},
+		{"unknown nested property", map[string]interface{}{"from": "backup.retention"}},
+		{"unknown environment field", map[string]interface{}{"env": "zone"}},
+		{"unknown transform", map[string]interface{}{"from": "tier", "transform": "reverse"}},
+		{"transform on constant", map[string]interface{}{"value": "x", "transform": "upper"}},
+		{"multiply without factor", map[string]interface{}{"from": "min_nodes", "transform": "multiply"}},
+		{"map without values", map[string]interface{}{"from": "tier", "transform": "map"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(models.JSON{"variable": tt.entry}, testSchema); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+// Package tokens signs and verifies portal access tokens with asymmetric
+// keys. Tokens carry a key ID (kid) so that several verification keys can
+// be active at once, which allows rotating the signing key without
+// invalidating tokens that are already issued.
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrUnknownKey is returned when a token references a key that is not in the set
+	ErrUnknownKey = errors.New("unknown signing key")
+
+	// ErrAlgorithmMismatch is returned when a token's algorithm does not match its key
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match key")
+)
+
+// Key is a single verification key
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	PublicKey crypto.PublicKey
+}
+
+// KeySet holds the active signing key and every key accepted for verification
+type KeySet struct {
+	signingKey crypto.Signer
+	signing    Key
+	keys       map[string]Key
+	order      []string
+}
+
+// NewKeySet creates a key set that signs with signer. Extra public keys are
+// accepted for verification only, e.g. the previous key during a rotation.
+func NewKeySet(signer crypto.Signer, extra ...crypto.PublicKey) (*KeySet, error) {
+	signing, err := newKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{
+		signingKey: signer,
+		signing:    signing,
+		keys:       map[string]Key{},
+	}
+	ks.add(signing)
+
+	for _, pub := range extra {
+		key, err := newKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		ks.add(key)
+	}
+
+	return ks, nil
+}
+
+// GenerateKeySet creates a key set with a fresh ES256 key. It is meant for
+// local development; keys are lost on restart.
+func GenerateKeySet() (*KeySet, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(key)
+}
+
+// LoadKeySet reads a PEM-encoded private signing key and any number of
+// PEM-encoded verification keys (public or private) from disk
+func LoadKeySet(signingKeyFile string, verificationKeyFiles []string) (*KeySet, error) {
+	data, err := os.ReadFile(signingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	signer, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key %s: %w", signingKeyFile, err)
+	}
+
+	var extra []crypto.PublicKey
+	for _, file := range verificationKeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read verification key: %w", err)
+		}
+		pub, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse verification key %s: %w", file, err)
+		}
+		extra = append(extra, pub)
+	}
+
+	return NewKeySet(signer, extra...)
+}
+
+// SigningKeyID returns the kid of the current signing key
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signing.ID
+}
+
+// Sign signs claims with the current signing key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signingKey)
+}
+
+// Keyfunc resolves the verification key for a token by its kid
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.PublicKey, nil
+}
+
+// Algorithms returns the signing algorithms of the accepted keys
+func (ks *KeySet) Algorithms() []string {
+	var algs []string
+	seen := map[string]bool{}
+	for _, kid := range ks.order {
+		alg := ks.keys[kid].Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWKS returns the public keys as a JSON Web Key Set
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(ks.order))}
+	for _, kid := range ks.order {
+		set.Keys = append(set.Keys, toJWK(ks.keys[kid]))
+	}
+	return set
+}
+
+func (ks *KeySet) add(key Key) {
+	if _, exists := ks.keys[key.ID]; exists {
+		return
+	}
+	ks.keys[key.ID] = key
+	ks.order = append(ks.order, key.ID)
+}
+
+// JWKSet is a JSON Web Key Set (RFC 7517)
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is a single public JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+func newKey(pub crypto.PublicKey) (Key, error) {
+	var method jwt.SigningMethod
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		default:
+			return Key{}, errors.New("unsupported elliptic curve")
+		}
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return Key{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		method = jwt.SigningMethodRS256
+	default:
+		return Key{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	key := Key{Method: method, PublicKey: pub}
+	key.ID = thumbprint(toJWK(key))
+	return key, nil
+}
+
+func toJWK(key Key) JWK {
+	jwk := JWK{Use: "sig", Kid: key.ID, Alg: key.Method.Alg()}
+	switch k := key.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint used as the key ID
+func thumbprint(jwk JWK) string {
+	var members interface{}
+	switch jwk.Kty {
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if block.Type == "PUBLIC KEY" {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	// Accept a private key file and use its public half
+	signer, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
@@ -0,0 +1,166 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	ks, err := GenerateKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, ks.Keyfunc)
+	if err != nil || !token.Valid {
+		t.Fatalf("token should verify: %v", err)
+	}
+	if token.Header["kid"] != ks.SigningKeyID() {
+		t.Errorf("expected kid %s, got %v", ks.SigningKeyID(), token.Header["kid"])
+	}
+	if token.Method.Alg() != "ES256" {
+		t.Errorf("expected ES256, got %s", token.Method.Alg())
+	}
+}
+
+func TestRotationKeepsOldTokensValid(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	before, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := NewKeySet(newKey, oldKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(oldToken, after.Keyfunc); err != nil {
+		t.Errorf("token signed with the previous key should still verify: %v", err)
+	}
+
+	newToken, err := after.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := jwt.Parse(newToken, after.Keyfunc)
+	if err != nil {
+		t.Fatalf("new token should verify: %v", err)
+	}
+	if parsed.Method.Alg() != "RS256" {
+		t.Errorf("expected RS256, got %s", parsed.Method.Alg())
+	}
+
+	if _, err := jwt.Parse(newToken, before.Keyfunc); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("old key set should not know the new key, got %v", err)
+	}
+
+	jwks := after.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kty != "RSA" || jwks.Keys[1].Kty != "EC" {
+		t.Errorf("unexpected JWKS: %+v", jwks)
+	}
+}
+
+func TestRejectsSymmetricTokens(t *testing.T) {
+	ks, err := GenerateKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	token.Header["kid"] = ks.SigningKeyID()
+	tokenString, err := token.SignedString([]byte("your-secret-key-change-in-production"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(tokenString, ks.Keyfunc); !errors.Is(err, ErrAlgorithmMismatch) {
+		t.Errorf("HS256 token should be rejected, got %v", err)
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	signing, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	previous, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	ks, err := NewKeySet(signing, previous.Public(), other.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	algs := ks.Algorithms()
+	if len(algs) != 2 || algs[0] != "ES256" || algs[1] != "ES384" {
+		t.Errorf("expected [ES256 ES384], got %v", algs)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+
+	signing, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingFile := filepath.Join(dir, "signing.pem")
+	writePEM(t, signingFile, "PRIVATE KEY", der)
+
+	previous, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err = x509.MarshalPKIXPublicKey(previous.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousFile := filepath.Join(dir, "previous.pub.pem")
+	writePEM(t, previousFile, "PUBLIC KEY", der)
+
+	ks, err := LoadKeySet(signingFile, []string{previousFile})
+	if err != nil {
+		t.Fatalf("LoadKeySet failed: %v", err)
+	}
+	if len(ks.JWKS().Keys) != 2 {
+		t.Errorf("expected 2 keys, got %d", len(ks.JWKS().Keys))
+	}
+
+	if _, err := LoadKeySet(previousFile, nil); err == nil {
+		t.Error("a public key cannot be used for signing")
+	}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+package workflow
+
+import (
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// PolicyError explains why a user is blocked by the separation-of-duties policy
+type PolicyError struct {
+	Code    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+var (
+	// ErrSelfApproval is returned when a requester tries to approve their own request
+	ErrSelfApproval = &PolicyError{
+		Code:    "self_approval",
+		Message: "You cannot approve your own request",
+	}
+
+	// ErrEditorApproval is returned when someone who edited a request tries to approve it
+	ErrEditorApproval = &PolicyError{
+		Code:    "editor_approval",
+		Message: "You cannot approve a request you have edited",
+	}
+
+	// ErrStageApproved is returned when an approver approves the same stage twice
+	ErrStageApproved = &PolicyError{
+		Code:    "stage_approved",
+		Message: "You have already approved this stage",
+	}
+
+	// ErrRepeatApproval is returned when an approver who approved one stage
+	// of a request tries to approve another
+	ErrRepeatApproval = &PolicyError{
+		Code:    "repeat_approval",
+		Message: "You have already approved another stage of this request",
+	}
+)
+
+// CheckSeparation enforces the environment's separation-of-duties policy
+// for an approver. editors lists everyone who created or edited the request.
+func CheckSeparation(env models.Environment, request models.Request, approverID uuid.UUID, editors []uuid.UUID) error {
+	if !env.AllowSelfApproval && request.RequesterID == approverID {
+		return ErrSelfApproval
+	}
+	if env.BlockEditorApproval {
+		for _, editor := range editors {
+			if editor == approverID {
+				return ErrEditorApproval
+			}
+		}
+	}
+	return nil
+}
+
+// CheckDistinctApprovers makes every approval of a submission come from a
+// different person, so each stage of a multi-stage policy is a separate
+// review. approvals lists the approved approvals of the current submission.
+// Admins are held to this too.
+func CheckDistinctApprovers(approvals []models.Approval, stage int, approverID uuid.UUID) error {
+	for _, approval := range approvals {
+		if approval.ApproverID == nil || *approval.ApproverID != approverID {
+			continue
+		}
+		if approval.Stage == stage {
+			return ErrStageApproved
+		}
+		return ErrRepeatApproval
+	}
+	return nil
+}
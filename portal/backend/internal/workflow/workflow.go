@@ -0,0 +1,122 @@
+// Package workflow implements the approval policy rules for requests
+package workflow
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+)
+
+var (
+	// ErrRoleRequired is returned when the approver lacks a required role
+	ErrRoleRequired = errors.New("this stage requires a different role")
+
+	// ErrGroupRequired is returned when the approver is not in a required group
+	ErrGroupRequired = errors.New("this stage requires membership in an approver group")
+)
+
+// DefaultStage is used for environments that require approval but have no
+// explicit policy: a single approval from any approver.
+var DefaultStage = models.ApprovalStage{
+	Position:          1,
+	Name:              "Approval",
+	RequiredApprovals: 1,
+}
+
+// Stages returns the ordered approval stages for an environment. It returns
+// nil when the environment does not require approval.
+func Stages(env models.Environment) []models.ApprovalStage {
+	if !env.RequiresApproval {
+		return nil
+	}
+	if len(env.ApprovalStages) == 0 {
+		return []models.ApprovalStage{DefaultStage}
+	}
+
+	stages := make([]models.ApprovalStage, len(env.ApprovalStages))
+	copy(stages, env.ApprovalStages)
+	sort.SliceStable(stages, func(i, j int) bool {
+		return stages[i].Position < stages[j].Position
+	})
+	return stages
+}
+
+// StageAt returns the stage with the given position
+func StageAt(stages []models.ApprovalStage, position int) (models.ApprovalStage, bool) {
+	for _, stage := range stages {
+		if stage.Position == position {
+			return stage, true
+		}
+	}
+	return models.ApprovalStage{}, false
+}
+
+// Advance decides what happens after a stage has received approved
+// approvals. It returns the stage that needs the next approval, or done
+// when every stage is satisfied.
+func Advance(stages []models.ApprovalStage, current models.ApprovalStage, approved int) (next models.ApprovalStage, done bool) {
+	if approved < required(current) {
+		return current, false
+	}
+	for _, stage := range stages {
+		if stage.Position > current.Position {
+			return stage, false
+		}
+	}
+	return models.ApprovalStage{}, true
+}
+
+// CanDecide checks whether a user may approve or reject at the given stage
+func CanDecide(stage models.ApprovalStage, user models.User) error {
+	if len(stage.RequiredRoles) > 0 && !stage.RequiredRoles.Contains(user.Role) && user.Role != models.RoleAdmin {
+		return ErrRoleRequired
+	}
+	if len(stage.RequiredGroups) > 0 {
+		for _, group := range stage.RequiredGroups {
+			if user.Groups.Contains(group) {
+				return nil
+			}
+		}
+		return ErrGroupRequired
+	}
+	return nil
+}
+
+// NewApproval creates the pending approval for a stage of a request
+func NewApproval(request models.Request, stage models.ApprovalStage) models.Approval {
+	approval := models.Approval{
+		RequestID: request.ID,
+		Stage:     stage.Position,
+		StageName: stage.Name,
+		Status:    models.ApprovalPending,
+	}
+	if stage.ID != DefaultStage.ID {
+		id := stage.ID
+		approval.StageID = &id
+	}
+	return approval
+}
+
+// Describe summarises a stage's requirements for error messages
+func Describe(stage models.ApprovalStage) string {
+	var parts []string
+	if len(stage.RequiredRoles) > 0 {
+		parts = append(parts, "role "+strings.Join(stage.RequiredRoles, " or "))
+	}
+	if len(stage.RequiredGroups) > 0 {
+		parts = append(parts, "group "+strings.Join(stage.RequiredGroups, " or "))
+	}
+	if len(parts) == 0 {
+		return stage.Name
+	}
+	return stage.Name + " (" + strings.Join(parts, ", ") + ")"
+}
+
+func required(stage models.ApprovalStage) int {
+	if stage.RequiredApprovals < 1 {
+		return 1
+	}
+	return stage.RequiredApprovals
+}
@@ -0,0 +1,172 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bimakw/gcp-devops-iac/portal/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func prodEnvironment() models.Environment {
+	return models.Environment{
+		Name:             "prod",
+		RequiresApproval: true,
+		ApprovalStages: []models.ApprovalStage{
+			{ID: uuid.New(), Position: 2, Name: "Platform", RequiredApprovals: 1, RequiredRoles: models.StringList{"admin"}},
+			{ID: uuid.New(), Position: 1, Name: "Peer Review", RequiredApprovals: 2},
+		},
+	}
+}
+
+func TestStagesOrdersByPosition(t *testing.T) {
+	stages := Stages(prodEnvironment())
+
+	if len(stages) != 2 {
+		t.Fatalf("expected 2 stages, got %d", len(stages))
+	}
+	if stages[0].Name != "Peer Review" || stages[1].Name != "Platform" {
+		t.Errorf("stages not ordered by position: %v", stages)
+	}
+}
+
+func TestStagesWithoutApproval(t *testing.T) {
+	env := prodEnvironment()
+	env.RequiresApproval = false
+
+	if stages := Stages(env); stages != nil {
+		t.Errorf("expected no stages, got %v", stages)
+	}
+}
+
+func TestStagesDefaultPolicy(t *testing.T) {
+	env := models.Environment{Name: "staging", RequiresApproval: true}
+
+	stages := Stages(env)
+	if len(stages) != 1 || stages[0].RequiredApprovals != 1 {
+		t.Errorf("expected default single-approver stage, got %v", stages)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	stages := Stages(prodEnvironment())
+
+	next, done := Advance(stages, stages[0], 1)
+	if done || next.Position != 1 {
+		t.Errorf("first stage needs two approvals, got next=%d done=%v", next.Position, done)
+	}
+
+	next, done = Advance(stages, stages[0], 2)
+	if done || next.Position != 2 {
+		t.Errorf("expected to move to stage 2, got next=%d done=%v", next.Position, done)
+	}
+
+	_, done = Advance(stages, stages[1], 1)
+	if !done {
+		t.Error("expected policy to be satisfied after the last stage")
+	}
+}
+
+func TestCanDecide(t *testing.T) {
+	roleStage := models.ApprovalStage{Name: "Platform", RequiredRoles: models.StringList{"approver"}}
+	groupStage := models.ApprovalStage{Name: "DBA", RequiredGroups: models.StringList{"dba"}}
+
+	tests := []struct {
+		name  string
+		stage models.ApprovalStage
+		user  models.User
+		err   error
+	}{
+		{"any approver", DefaultStage, models.User{Role: "approver"}, nil},
+		{"matching role", roleStage, models.User{Role: "approver"}, nil},
+		{"admin satisfies role", roleStage, models.User{Role: "admin"}, nil},
+		{"wrong role", roleStage, models.User{Role: "user"}, ErrRoleRequired},
+		{"group member", groupStage, models.User{Role: "approver", Groups: models.StringList{"dba"}}, nil},
+		{"not in group", groupStage, models.User{Role: "admin"}, ErrGroupRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CanDecide(tt.stage, tt.user); !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestNewApproval(t *testing.T) {
+	request := models.Request{ID: uuid.New()}
+
+	approval := NewApproval(request, DefaultStage)
+	if approval.StageID != nil {
+		t.Error("default stage should not set a stage ID")
+	}
+	if approval.Status != models.ApprovalPending || approval.Stage != 1 {
+		t.Errorf("unexpected approval: %+v", approval)
+	}
+
+	stage := prodEnvironment().ApprovalStages[0]
+	approval = NewApproval(request, stage)
+	if approval.StageID == nil || *approval.StageID != stage.ID {
+		t.Error("explicit stage should set the stage ID")
+	}
+}
+
+func TestCheckSeparation(t *testing.T) {
+	requester := uuid.New()
+	editor := uuid.New()
+	approver := uuid.New()
+	request := models.Request{RequesterID: requester}
+	editors := []uuid.UUID{requester, editor}
+
+	tests := []struct {
+		name     string
+		env      models.Environment
+		approver uuid.UUID
+		err      error
+	}{
+		{"independent approver", models.Environment{BlockEditorApproval: true}, approver, nil},
+		{"self approval blocked", models.Environment{}, requester, ErrSelfApproval},
+		{"self approval allowed", models.Environment{AllowSelfApproval: true}, requester, nil},
+		{"editor allowed by default", models.Environment{}, editor, nil},
+		{"editor blocked", models.Environment{BlockEditorApproval: true}, editor, ErrEditorApproval},
+		{"allowed self approval still blocks editors", models.Environment{AllowSelfApproval: true, BlockEditorApproval: true}, requester, ErrEditorApproval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckSeparation(tt.env, request, tt.approver, editors); !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCheckDistinctApprovers(t *testing.T) {
+	first := uuid.New()
+	second := uuid.New()
+	approvals := []models.Approval{
+		{Stage: 1, Status: models.ApprovalApproved, ApproverID: &first},
+		{Stage: 2, Status: models.ApprovalApproved, ApproverID: &second},
+	}
+
+	tests := []struct {
+		name     string
+		stage    int
+		approver uuid.UUID
+		err      error
+	}{
+		{"new approver", 3, uuid.New(), nil},
+		{"second approval of a stage", 2, second, ErrStageApproved},
+		{"approver of an earlier stage", 3, first, ErrRepeatApproval},
+		{"approver of a later stage", 1, second, ErrRepeatApproval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckDistinctApprovers(approvals, tt.stage, tt.approver); !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
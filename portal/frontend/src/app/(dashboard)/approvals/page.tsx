@@ -63,6 +63,12 @@ export default function ApprovalsPage() {
     return new Date(date).toLocaleString();
   };
 
+  const formatCost = (amount: number, signed = false) => {
+    const formatted = `$${Math.abs(amount).toFixed(2)}`;
+    if (!signed) return formatted;
+    return amount < 0 ? `-${formatted}` : `+${formatted}`;
+  };
+
   return (
     <div className="space-y-6">
       <div>
@@ -127,6 +133,31 @@ export default function ApprovalsPage() {
                       </Button>
                     </div>
                   </div>
+                  <div className="mt-4 p-3 bg-muted rounded-lg">
+                    <p className="text-sm font-medium mb-2">Monthly Cost</p>
+                    {approval.request?.plan_cost ? (
+                      <>
+                        <p className="text-sm">
+                          {formatCost(approval.request.plan_cost.delta, true)} per month (
+                          {formatCost(approval.request.plan_cost.past_monthly)} →{' '}
+                          {formatCost(approval.request.plan_cost.monthly)}, from Infracost)
+                        </p>
+                        <ul className="mt-2 text-xs text-muted-foreground space-y-1">
+                          {approval.request.plan_cost.resources
+                            .filter((r) => r.delta !== 0 || r.usage_based)
+                            .map((r) => (
+                              <li key={r.name}>
+                                {r.name}: {r.usage_based ? 'usage based' : formatCost(r.delta, true)}
+                              </li>
+                            ))}
+                        </ul>
+                      </>
+                    ) : (
+                      <p className="text-sm">
+                        ~{formatCost(approval.request?.estimated_cost ?? 0)} per month (estimate)
+                      </p>
+                    )}
+                  </div>
                   <div className="mt-4 p-3 bg-muted rounded-lg">
                     <p className="text-sm font-medium mb-2">Configuration</p>
                     <pre className="text-xs overflow-auto">
@@ -6,7 +6,20 @@ interface RequestOptions {
   headers?: Record<string, string>;
 }
 
-async function request<T>(endpoint: string, options: RequestOptions = {}): Promise<T> {
+async function refreshAccessToken(): Promise<string | null> {
+  const response = await fetch(`${API_BASE}/auth/refresh`, {
+    method: 'POST',
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    return null;
+  }
+  const data: { access_token: string } = await response.json();
+  localStorage.setItem('token', data.access_token);
+  return data.access_token;
+}
+
+async function request<T>(endpoint: string, options: RequestOptions = {}, retry = true): Promise<T> {
   const token = typeof window !== 'undefined' ? localStorage.getItem('token') : null;
 
   const headers: Record<string, string> = {
@@ -21,9 +34,18 @@ async function request<T>(endpoint: string, options: RequestOptions = {}): Promi
   const response = await fetch(`${API_BASE}${endpoint}`, {
     method: options.method || 'GET',
     headers,
+    credentials: 'include',
     body: options.body ? JSON.stringify(options.body) : undefined,
   });
 
+  // Access tokens are short-lived; refresh once and retry
+  if (response.status === 401 && retry && token) {
+    const refreshed = await refreshAccessToken();
+    if (refreshed) {
+      return request<T>(endpoint, options, false);
+    }
+  }
+
   if (!response.ok) {
     const error = await response.json().catch(() => ({ error: 'Request failed' }));
     throw new Error(error.error || 'Request failed');
@@ -32,6 +54,89 @@ async function request<T>(endpoint: string, options: RequestOptions = {}): Promi
   return response.json();
 }
 
+// download fetches a file, such as a run artifact, with the access token
+async function download(endpoint: string, retry = true): Promise<Blob> {
+  const token = typeof window !== 'undefined' ? localStorage.getItem('token') : null;
+  const response = await fetch(`${API_BASE}${endpoint}`, {
+    headers: token ? { Authorization: `Bearer ${token}` } : {},
+    credentials: 'include',
+  });
+
+  if (response.status === 401 && retry && token) {
+    const refreshed = await refreshAccessToken();
+    if (refreshed) {
+      return download(endpoint, false);
+    }
+  }
+
+  if (!response.ok) {
+    const error = await response.json().catch(() => ({ error: 'Download failed' }));
+    throw new Error(error.error || 'Download failed');
+  }
+
+  return response.blob();
+}
+
+// streamEvents reads a Server-Sent Events stream with fetch, which unlike
+// EventSource can send the Authorization header. It reconnects with
+// Last-Event-ID until signal is aborted.
+async function streamEvents(
+  endpoint: string,
+  onEvent: (event: RequestEvent) => void,
+  signal: AbortSignal
+): Promise<void> {
+  let lastEventId = '';
+  let retryMs = 3000;
+
+  while (!signal.aborted) {
+    try {
+      const token = localStorage.getItem('token');
+      const headers: Record<string, string> = { Accept: 'text/event-stream' };
+      if (token) headers['Authorization'] = `Bearer ${token}`;
+      if (lastEventId) headers['Last-Event-ID'] = lastEventId;
+
+      const response = await fetch(`${API_BASE}${endpoint}`, { headers, credentials: 'include', signal });
+      if (response.status === 401 && (await refreshAccessToken())) {
+        continue;
+      }
+      if (!response.ok || !response.body) {
+        const error = await response.json().catch(() => ({ error: 'Request failed' }));
+        throw new Error(error.error || 'Request failed');
+      }
+
+      const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = '';
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) break;
+        buffer += value;
+        let end;
+        while ((end = buffer.indexOf('\n\n')) >= 0) {
+          const frame = buffer.slice(0, end);
+          buffer = buffer.slice(end + 2);
+          let id = '';
+          let type = 'message';
+          let data = '';
+          for (const line of frame.split('\n')) {
+            if (line.startsWith('id: ')) id = line.slice(4);
+            else if (line.startsWith('event: ')) type = line.slice(7);
+            else if (line.startsWith('data: ')) data += line.slice(6);
+            else if (line.startsWith('retry: ')) retryMs = Number(line.slice(7)) || retryMs;
+          }
+          if (!data) continue;
+          if (id) lastEventId = id;
+          onEvent({ id, type, data: JSON.parse(data) } as RequestEvent);
+        }
+      }
+    } catch (err) {
+      if (signal.aborted) return;
+      // Network errors reconnect; errors returned by the API do not
+      if (!(err instanceof TypeError)) throw err;
+    }
+    await new Promise((resolve) => setTimeout(resolve, retryMs));
+  }
+}
+
 // Auth
 export const auth = {
   me: () => request<User>('/auth/me'),
@@ -42,6 +147,10 @@ export const auth = {
 export const environments = {
   list: () => request<Environment[]>('/environments'),
   get: (id: string) => request<Environment>(`/environments/${id}`),
+  create: (data: EnvironmentInput) => request<Environment>('/environments', { method: 'POST', body: data }),
+  update: (id: string, data: Partial<EnvironmentInput>) =>
+    request<Environment>(`/environments/${id}`, { method: 'PUT', body: data }),
+  delete: (id: string) => request<{ message: string }>(`/environments/${id}`, { method: 'DELETE' }),
 };
 
 // Resource Types
@@ -51,21 +160,107 @@ export const resourceTypes = {
   getSchema: (id: string) => request<Record<string, unknown>>(`/resource-types/${id}/schema`),
 };
 
+// Blueprints
+export const blueprints = {
+  list: () => request<Blueprint[]>('/blueprints'),
+  get: (id: string) => request<Blueprint>(`/blueprints/${id}`),
+};
+
 // Requests
 export const requests = {
-  list: (params?: { status?: string; environment_id?: string }) => {
+  list: (params?: {
+    status?: string;
+    environment_id?: string;
+    kind?: RequestKind;
+    resource_id?: string;
+    promoted_from_id?: string;
+    parent_id?: string;
+  }) => {
     const searchParams = new URLSearchParams();
     if (params?.status) searchParams.set('status', params.status);
     if (params?.environment_id) searchParams.set('environment_id', params.environment_id);
+    if (params?.kind) searchParams.set('kind', params.kind);
+    if (params?.resource_id) searchParams.set('resource_id', params.resource_id);
+    if (params?.promoted_from_id) searchParams.set('promoted_from_id', params.promoted_from_id);
+    if (params?.parent_id) searchParams.set('parent_id', params.parent_id);
     const query = searchParams.toString();
     return request<Request[]>(`/requests${query ? `?${query}` : ''}`);
   },
   get: (id: string) => request<Request>(`/requests/${id}`),
+  // Streams status changes, approval decisions and Terraform output until signal is aborted
+  events: (id: string, onEvent: (event: RequestEvent) => void, signal: AbortSignal) =>
+    streamEvents(`/requests/${id}/events`, onEvent, signal),
   create: (data: CreateRequestInput) => request<Request>('/requests', { method: 'POST', body: data }),
   update: (id: string, data: Partial<CreateRequestInput>) =>
     request<Request>(`/requests/${id}`, { method: 'PUT', body: data }),
   delete: (id: string) => request<{ message: string }>(`/requests/${id}`, { method: 'DELETE' }),
   submit: (id: string) => request<Request>(`/requests/${id}/submit`, { method: 'POST' }),
+  withdraw: (id: string) => request<Request>(`/requests/${id}/withdraw`, { method: 'POST' }),
+  promote: (id: string) => request<Request>(`/requests/${id}/promote`, { method: 'POST' }),
+  validate: (id: string, version?: number | 'current') =>
+    request<{ valid: boolean; schema_version: number; fields: { field: string; message: string }[] }>(
+      `/requests/${id}/validate${version !== undefined ? `?version=${version}` : ''}`,
+      { method: 'POST' }
+    ),
+  importInfracost: (id: string, report: unknown) =>
+    request<PlanCost>(`/requests/${id}/infracost`, { method: 'POST', body: report }),
+  changes: (id: string) =>
+    request<{ kind: RequestKind; resource_id?: string; changes: ConfigChange[] }>(`/requests/${id}/changes`),
+  rendered: (id: string) =>
+    request<{ schema_version: number; variables: Record<string, unknown> }>(`/requests/${id}/rendered`),
+  budget: (id: string) => request<BudgetStatus | null>(`/requests/${id}/budget`),
+  runs: (id: string) => request<Run[]>(`/requests/${id}/runs`),
+  // Returns the log chunks after `after`; poll with the last seq to follow a running run
+  runLog: (id: string, runId: string, after?: number) =>
+    request<{ run: Run; chunks: RunLogChunk[] }>(
+      `/requests/${id}/runs/${runId}/log${after !== undefined ? `?after=${after}` : ''}`
+    ),
+  runArtifact: (id: string, runId: string, name: string) =>
+    download(`/requests/${id}/runs/${runId}/artifacts/${encodeURIComponent(name)}`),
+};
+
+// Resource inventory
+export const resources = {
+  list: (params?: {
+    mine?: boolean;
+    status?: string;
+    environment_id?: string;
+    resource_type_id?: string;
+    team_id?: string;
+  }) => {
+    const searchParams = new URLSearchParams();
+    if (params?.mine) searchParams.set('mine', 'true');
+    if (params?.status) searchParams.set('status', params.status);
+    if (params?.environment_id) searchParams.set('environment_id', params.environment_id);
+    if (params?.resource_type_id) searchParams.set('resource_type_id', params.resource_type_id);
+    if (params?.team_id) searchParams.set('team_id', params.team_id);
+    const query = searchParams.toString();
+    return request<Resource[]>(`/resources${query ? `?${query}` : ''}`);
+  },
+  get: (id: string) => request<Resource>(`/resources/${id}`),
+  extend: (id: string, hours: number) =>
+    request<Resource>(`/resources/${id}/extend`, { method: 'POST', body: { hours } }),
+};
+
+// Teams
+export const teams = {
+  list: (params?: { mine?: boolean }) =>
+    request<Team[]>(`/teams${params?.mine ? '?mine=true' : ''}`),
+  get: (id: string) => request<Team>(`/teams/${id}`),
+  create: (data: { name: string; display_name?: string; cost_center?: string }) =>
+    request<Team>('/teams', { method: 'POST', body: data }),
+  update: (id: string, data: { display_name?: string; cost_center?: string; is_active?: boolean }) =>
+    request<Team>(`/teams/${id}`, { method: 'PUT', body: data }),
+  addMember: (id: string, userId: string) =>
+    request<TeamMembership>(`/teams/${id}/members`, { method: 'POST', body: { user_id: userId } }),
+  removeMember: (id: string, userId: string) =>
+    request<{ message: string }>(`/teams/${id}/members/${userId}`, { method: 'DELETE' }),
+  budgets: (id: string) =>
+    request<(Budget & { committed: number; remaining: number })[]>(`/teams/${id}/budgets`),
+  setBudget: (id: string, environmentId: string, data: { monthly_amount: number; policy: 'warn' | 'block' }) =>
+    request<Budget>(`/teams/${id}/budgets/${environmentId}`, { method: 'PUT', body: data }),
+  deleteBudget: (id: string, environmentId: string) =>
+    request<{ message: string }>(`/teams/${id}/budgets/${environmentId}`, { method: 'DELETE' }),
 };
 
 // Approvals
@@ -81,6 +276,27 @@ export const approvals = {
     request<Approval>(`/approvals/${id}/reject`, { method: 'POST', body: { comment } }),
 };
 
+// User administration (admin only)
+export const users = {
+  list: (params?: { q?: string; role?: string; active?: boolean }) => {
+    const searchParams = new URLSearchParams();
+    if (params?.q) searchParams.set('q', params.q);
+    if (params?.role) searchParams.set('role', params.role);
+    if (params?.active !== undefined) searchParams.set('active', String(params.active));
+    const query = searchParams.toString();
+    return request<User[]>(`/admin/users${query ? `?${query}` : ''}`);
+  },
+  get: (id: string) => request<User>(`/admin/users/${id}`),
+  create: (data: { email: string; name?: string; role?: string; groups?: string[] }) =>
+    request<User>('/admin/users', { method: 'POST', body: data }),
+  updateRole: (id: string, role: string) =>
+    request<User>(`/admin/users/${id}/role`, { method: 'PUT', body: { role } }),
+  updateGroups: (id: string, groups: string[]) =>
+    request<User>(`/admin/users/${id}/groups`, { method: 'PUT', body: { groups } }),
+  deactivate: (id: string) => request<User>(`/admin/users/${id}/deactivate`, { method: 'POST' }),
+  reactivate: (id: string) => request<User>(`/admin/users/${id}/reactivate`, { method: 'POST' }),
+};
+
 // Types
 export interface User {
   id: string;
@@ -88,9 +304,24 @@ export interface User {
   name: string;
   role: string;
   avatar_url?: string;
+  groups?: string[];
+  is_active: boolean;
   created_at: string;
 }
 
+export interface EnvironmentInput {
+  name: string;
+  display_name?: string;
+  description?: string;
+  gcp_project_id?: string;
+  region?: string;
+  requires_approval?: boolean;
+  is_active?: boolean;
+  max_ttl_hours?: number;
+  next_environment_id?: string;
+  promotion_overrides?: Record<string, Record<string, unknown>>;
+}
+
 export interface Environment {
   id: string;
   name: string;
@@ -100,6 +331,11 @@ export interface Environment {
   region: string;
   requires_approval: boolean;
   is_active: boolean;
+  allow_self_approval: boolean;
+  block_editor_approval: boolean;
+  max_ttl_hours: number;
+  next_environment_id?: string;
+  promotion_overrides?: Record<string, Record<string, unknown>>;
 }
 
 export interface ResourceType {
@@ -111,35 +347,196 @@ export interface ResourceType {
   config_schema: Record<string, unknown>;
   base_cost: number;
   is_active: boolean;
+  current_version: number;
+  input_mapping?: Record<string, { from?: string; env?: string }>;
+}
+
+export interface CostEstimate {
+  currency: string;
+  monthly: number;
+  items: { name: string; quantity: number; unit: string; unit_price: number; monthly: number }[];
+  warnings?: string[];
+}
+
+export interface PlanCost {
+  source: 'plan' | 'upload';
+  currency: string;
+  monthly: number;
+  past_monthly: number;
+  delta: number;
+  resources: {
+    name: string;
+    resource_type: string;
+    monthly: number;
+    past_monthly: number;
+    delta: number;
+    usage_based?: boolean;
+  }[];
+}
+
+export type RequestKind = 'create' | 'modify' | 'decommission' | 'blueprint';
+
+export interface BlueprintComponent {
+  id: string;
+  blueprint_id: string;
+  name: string;
+  position: number;
+  resource_type_id: string;
+  resource_type?: ResourceType;
+  configuration?: Record<string, unknown>;
+  wiring?: Record<string, string>;
+  depends_on?: string[];
+}
+
+export interface Blueprint {
+  id: string;
+  name: string;
+  display_name: string;
+  description?: string;
+  is_active: boolean;
+  components?: BlueprintComponent[];
+  created_at: string;
+  updated_at: string;
+}
+
+export type RequestEvent =
+  | { id: string; type: 'status'; data: { from: string; to: string; component?: string } }
+  | {
+      id: string;
+      type: 'approval';
+      data: {
+        approval_id: string;
+        stage: number;
+        stage_name?: string;
+        status: string;
+        approver_id: string;
+        comment?: string;
+      };
+    }
+  | { id: string; type: 'log'; data: { line: string; component?: string } }
+  // Events were missed; reload the request and its run logs
+  | { id: string; type: 'reset'; data: Record<string, never> };
+
+export interface Run {
+  id: string;
+  request_id: string;
+  attempt: number;
+  operation: 'plan' | 'apply' | 'destroy';
+  status: 'running' | 'succeeded' | 'failed';
+  exit_code?: number;
+  error?: string;
+  started_at: string;
+  finished_at?: string;
+  backup_uri?: string;
+  artifacts?: RunArtifact[];
+}
+
+export interface RunArtifact {
+  id: string;
+  run_id: string;
+  name: string;
+  content_type: string;
+  size: number;
+  sha256: string;
+  created_at: string;
+}
+
+export interface RunLogChunk {
+  run_id: string;
+  seq: number;
+  data: string;
+  created_at: string;
+}
+
+export interface ConfigChange {
+  field: string;
+  kind: 'added' | 'removed' | 'changed';
+  old?: unknown;
+  new?: unknown;
 }
 
 export interface Request {
   id: string;
   title: string;
   description?: string;
+  kind: RequestKind;
+  resource_id?: string;
+  resource?: Resource;
+  final_backup?: boolean;
+  disable_deletion_protection?: boolean;
   requester_id: string;
   requester?: User;
   environment_id: string;
   environment?: Environment;
-  resource_type_id: string;
+  resource_type_id?: string;
   resource_type?: ResourceType;
   configuration: Record<string, unknown>;
+  schema_version: number;
   terraform_plan?: string;
   estimated_cost: number;
+  cost_breakdown?: CostEstimate;
+  plan_cost?: PlanCost;
+  imported_cost?: PlanCost;
+  cost_delta?: number;
+  team_id?: string;
+  team?: Team;
+  budget?: BudgetStatus;
   status: string;
   priority: string;
+  ttl_hours?: number;
+  promoted_from_id?: string;
+  promoted_from?: Request;
+  blueprint_id?: string;
+  blueprint?: Blueprint;
+  parent_id?: string;
+  component?: string;
+  wiring?: Record<string, string>;
+  depends_on?: string[];
+  wired_variables?: Record<string, unknown>;
+  components?: Request[];
   created_at: string;
   updated_at: string;
   submitted_at?: string;
   completed_at?: string;
 }
 
+export interface Resource {
+  id: string;
+  name: string;
+  request_id: string;
+  request?: Request;
+  last_request_id?: string;
+  environment_id: string;
+  environment?: Environment;
+  resource_type_id: string;
+  resource_type?: ResourceType;
+  owner_id: string;
+  owner?: User;
+  team_id?: string;
+  team?: Team;
+  configuration: Record<string, unknown>;
+  schema_version: number;
+  outputs?: Record<string, unknown>;
+  state_prefix: string;
+  status: 'active' | 'destroyed';
+  expires_at?: string;
+  expiry_warned_at?: string;
+  expiry_request_id?: string;
+  created_at: string;
+  updated_at: string;
+  destroyed_at?: string;
+  wired_variables?: Record<string, unknown>;
+}
+
 export interface Approval {
   id: string;
   request_id: string;
   request?: Request;
-  approver_id: string;
+  approver_id?: string;
   approver?: User;
+  stage: number;
+  stage_id?: string;
+  stage_name?: string;
   status: string;
   comment?: string;
   approved_at?: string;
@@ -150,7 +547,51 @@ export interface CreateRequestInput {
   title: string;
   description?: string;
   environment_id: string;
-  resource_type_id: string;
+  resource_type_id?: string;
   configuration: Record<string, unknown>;
   priority?: string;
+  team_id?: string;
+  kind?: RequestKind;
+  resource_id?: string;
+  final_backup?: boolean;
+  disable_deletion_protection?: boolean;
+  ttl_hours?: number;
+  blueprint_id?: string;
+}
+
+export interface Team {
+  id: string;
+  name: string;
+  display_name: string;
+  cost_center?: string;
+  is_active: boolean;
+  members?: TeamMembership[];
+  budgets?: Budget[];
+}
+
+export interface TeamMembership {
+  id: string;
+  team_id: string;
+  user_id: string;
+  user?: User;
+}
+
+export interface Budget {
+  id: string;
+  team_id: string;
+  environment_id: string;
+  environment?: Environment;
+  monthly_amount: number;
+  policy: 'warn' | 'block';
+}
+
+export interface BudgetStatus {
+  budget_id: string;
+  policy: 'warn' | 'block';
+  monthly_amount: number;
+  committed: number;
+  request_cost: number;
+  remaining: number;
+  exceeded: boolean;
+  blocked: boolean;
 }